@@ -3,10 +3,18 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	"google.golang.org/api/googleapi"
 )
 
 // readIDsFromStdin reads message/label IDs from stdin, one per line
@@ -32,51 +40,292 @@ func readIDsFromStdin() ([]string, error) {
 	return ids, nil
 }
 
-// batchProcessor handles batch operations with progress reporting
+// bulkFlags selects the messages a bulk command acts on when it is not
+// given a single message ID.
+type bulkFlags struct {
+	Stdin  bool   `help:"Read IDs from stdin"`
+	Query  string `help:"Act on every message matching a Gmail search query"`
+	Max    int    `help:"With --query, refuse to act on more than this many messages (0 = no limit)" default:"1000"`
+	DryRun bool   `help:"Show how many messages would change, and a sample, without changing them" name:"dry-run"`
+}
+
+// messageIDs returns the IDs to act on: messageID, the IDs on stdin or every
+// message matching the query. idName is how errors refer to messageID.
+// A query matching more than --max messages is an error, except with
+// --dry-run: then one ID past --max is returned to mark that there are more,
+// which preview and matched report.
+func (b bulkFlags) messageIDs(ctx context.Context, conn *gwcli.CmdG, messageID, idName string, out *outputWriter) ([]string, error) {
+	sources := 0
+	for _, set := range []bool{messageID != "", b.Stdin, b.Query != ""} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return nil, fmt.Errorf("either provide %s, --stdin or --query", idName)
+	case sources > 1:
+		return nil, fmt.Errorf("give only one of %s, --stdin or --query", idName)
+	case b.Stdin:
+		return readIDsFromStdin()
+	case b.Query == "":
+		return []string{messageID}, nil
+	}
+
+	out.writeVerbose("Searching with query: %s", b.Query)
+	limit := b.Max
+	if b.DryRun && limit > 0 {
+		limit++
+	}
+	ids, more, err := conn.ListMessageIDsN(ctx, "", b.Query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	if more && !b.DryRun {
+		return nil, fmt.Errorf("query matches more than --max %d messages; narrow the query or raise --max", b.Max)
+	}
+	out.writeVerbose("Query matched %d messages", len(ids))
+	return ids, nil
+}
+
+// dryRunSample is how many messages a --dry-run shows.
+const dryRunSample = 10
+
+// bulkPreview is JSON output for a bulk command's --dry-run. With More set
+// the query matches more than Matched (--max) messages.
+type bulkPreview struct {
+	Action  string              `json:"action"`
+	Query   string              `json:"query,omitempty"`
+	Matched int                 `json:"matched"`
+	More    bool                `json:"more,omitempty"`
+	Sample  []messageListOutput `json:"sample"`
+}
+
+// overMax drops the marker ID messageIDs adds in a --dry-run when the query
+// matches more than --max messages, and reports whether it was there.
+func (b bulkFlags) overMax(ids []string) ([]string, bool) {
+	if b.DryRun && b.Query != "" && b.Max > 0 && len(ids) > b.Max {
+		return ids[:b.Max], true
+	}
+	return ids, false
+}
+
+// matched describes how many messages ids stands for in a --dry-run
+// summary, such as "12" or "more than 1000".
+func (b bulkFlags) matched(ids []string) string {
+	if ids, more := b.overMax(ids); more {
+		return fmt.Sprintf("more than %d", len(ids))
+	}
+	return strconv.Itoa(len(ids))
+}
+
+// preview shows what a bulk command would do to ids without doing it.
+// summary describes the change, such as "delete 12 messages"; build the
+// count with matched.
+func (b bulkFlags) preview(ctx context.Context, conn *gwcli.CmdG, ids []string, action, summary string, out *outputWriter) error {
+	ids, more := b.overMax(ids)
+	res := bulkPreview{Action: action, Query: b.Query, Matched: len(ids), More: more, Sample: []messageListOutput{}}
+	for i, id := range ids {
+		if i == dryRunSample {
+			break
+		}
+		msg := gwcli.NewMessage(conn, id)
+		// Header errors leave the field empty, as in messages list.
+		from, _ := msg.GetHeader(ctx, "From")
+		subject, _ := msg.GetHeader(ctx, "Subject")
+		date, _ := msg.GetTimeFmt(ctx)
+		res.Sample = append(res.Sample, messageListOutput{ID: id, Date: date, From: from, Subject: subject})
+	}
+
+	if out.json {
+		return out.writeJSON(res)
+	}
+	out.writeMessage("Dry run: would " + summary)
+	if len(res.Sample) == 0 {
+		return nil
+	}
+	rows := make([][]string, len(res.Sample))
+	for i, m := range res.Sample {
+		rows[i] = []string{m.ID, m.Date, truncateString(m.From, 30), truncateString(m.Subject, 40)}
+	}
+	if err := out.writeTable([]string{"ID", "DATE", "FROM", "SUBJECT"}, rows); err != nil {
+		return err
+	}
+	switch rest := len(ids) - len(res.Sample); {
+	case more:
+		out.writeMessage(fmt.Sprintf("... and more than %d others; narrow the query or raise --max before running it", rest))
+	case rest > 0:
+		out.writeMessage(fmt.Sprintf("... and %d more", rest))
+	}
+	return nil
+}
+
+// batchConcurrency is how many BatchModify calls run at once. Each call
+// costs 50 quota units against Gmail's per-user limit of 250 per second.
+const batchConcurrency = 4
+
+// batchFailure is a message a batch operation failed for.
+type batchFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	err   error
+}
+
+// batchOp acts on a chunk of message IDs in one API call, such as
+// CmdG.BatchModify or CmdG.BatchDelete.
+type batchOp func(ctx context.Context, ids []string) error
+
+// batchProcessor runs a batchOp over many messages: IDs are sent in chunks
+// of up to gwcli.BatchModifyLimit, with at most batchConcurrency chunks in
+// flight. Rate limited and failed chunks are retried with backoff. Gmail
+// fails a whole chunk for one bad message ID, so a chunk rejected for one is
+// split in halves until the failing IDs are isolated.
 type batchProcessor struct {
 	total     int
 	processed int
-	errors    []error
+	failed    []batchFailure
 	verbose   bool
+	m         sync.Mutex
 }
 
 func newBatchProcessor(total int, verbose bool) *batchProcessor {
 	return &batchProcessor{
 		total:   total,
 		verbose: verbose,
-		errors:  []error{},
+		failed:  []batchFailure{},
 	}
 }
 
-// process executes fn for each ID with progress reporting
-func (bp *batchProcessor) process(ctx context.Context, ids []string, fn func(context.Context, string) error) error {
-	for i, id := range ids {
-		if err := fn(ctx, id); err != nil {
-			bp.errors = append(bp.errors, fmt.Errorf("ID %s: %w", id, err))
+// modify adds and removes labels on ids.
+func (bp *batchProcessor) modify(ctx context.Context, conn *gwcli.CmdG, ids, add, remove []string) {
+	bp.run(ctx, ids, func(ctx context.Context, chunk []string) error {
+		return conn.BatchModify(ctx, chunk, add, remove)
+	})
+}
+
+// run applies op to ids.
+func (bp *batchProcessor) run(ctx context.Context, ids []string, op batchOp) {
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > gwcli.BatchModifyLimit {
+			chunk = chunk[:gwcli.BatchModifyLimit]
+		}
+		ids = ids[len(chunk):]
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chunk []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			bp.runChunk(ctx, chunk, op)
+		}(chunk)
+	}
+	wg.Wait()
+}
+
+func (bp *batchProcessor) runChunk(ctx context.Context, ids []string, op batchOp) {
+	err := retryAPI(ctx, func() error {
+		return op(ctx, ids)
+	}, func(err error, delay time.Duration) {
+		if bp.verbose {
+			fmt.Fprintf(os.Stderr, "Warning: retrying %d messages in %v: %v\n", len(ids), delay, err)
+		}
+	})
+	if err != nil && len(ids) > 1 && isInvalidMessageID(err) {
+		mid := len(ids) / 2
+		bp.runChunk(ctx, ids[:mid], op)
+		bp.runChunk(ctx, ids[mid:], op)
+		return
+	}
+
+	bp.m.Lock()
+	defer bp.m.Unlock()
+	for _, id := range ids {
+		if err != nil {
+			bp.failed = append(bp.failed, batchFailure{ID: id, Error: err.Error(), err: err})
 			if bp.verbose {
 				fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", id, err)
 			}
 		}
-		bp.processed++
+	}
+	bp.processed += len(ids)
+	if bp.verbose && bp.total > gwcli.BatchModifyLimit {
+		fmt.Fprintf(os.Stderr, "Progress: %d/%d\n", bp.processed, bp.total)
+	}
+}
 
-		// Show progress for large batches
-		if bp.verbose && len(ids) > 10 && (i+1)%10 == 0 {
-			fmt.Fprintf(os.Stderr, "Progress: %d/%d\n", i+1, len(ids))
-		}
+// isInvalidMessageID reports whether err is Gmail rejecting a message ID in
+// the request: "Invalid id value" or a message that was not found. Other
+// rejections, such as of a label, fail every part of the request alike, so
+// splitting it would only multiply the failed calls.
+func isInvalidMessageID(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
 	}
+	switch apiErr.Code {
+	case http.StatusNotFound:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(strings.ToLower(apiErr.Message), "invalid id")
+	}
+	return false
+}
 
+// succeeded is the number of IDs processed without error.
+func (bp *batchProcessor) succeeded() int {
+	return bp.processed - len(bp.failed)
+}
+
+// output is the JSON result of a batch operation, counting successes
+// under key.
+func (bp *batchProcessor) output(key string) map[string]interface{} {
+	return map[string]interface{}{
+		key:      bp.succeeded(),
+		"errors": len(bp.failed),
+		"failed": bp.failed,
+	}
+}
+
+// err returns an error if any ID failed.
+func (bp *batchProcessor) err() error {
+	switch {
+	case len(bp.failed) == 1:
+		return fmt.Errorf("message %s: %s", bp.failed[0].ID, bp.failed[0].Error)
+	case len(bp.failed) > 1:
+		return fmt.Errorf("%d of %d messages failed", len(bp.failed), bp.total)
+	}
 	return nil
 }
 
+// finish writes the result of a batch operation and returns an error if
+// any ID failed. JSON counts successes under key; text shows single for one
+// successful ID, and the report otherwise.
+func (bp *batchProcessor) finish(out *outputWriter, key, single string) error {
+	switch {
+	case out.json:
+		if err := out.writeJSON(bp.output(key)); err != nil {
+			return err
+		}
+	case bp.total == 0:
+		out.writeMessage("No messages matched")
+	case bp.total == 1 && len(bp.failed) == 0:
+		out.writeMessage(single)
+	case bp.total > 1:
+		bp.report(out.writer)
+	}
+	return bp.err()
+}
+
 // report prints final batch processing report
 func (bp *batchProcessor) report(w io.Writer) {
-	fmt.Fprintf(w, "Processed %d/%d items\n", bp.processed-len(bp.errors), bp.total)
-	if len(bp.errors) > 0 {
-		fmt.Fprintf(w, "Errors: %d\n", len(bp.errors))
-		if bp.verbose {
-			for _, err := range bp.errors {
-				fmt.Fprintf(os.Stderr, "  - %v\n", err)
-			}
+	fmt.Fprintf(w, "Processed %d/%d items\n", bp.succeeded(), bp.total)
+	if len(bp.failed) > 0 {
+		fmt.Fprintf(w, "Errors: %d\n", len(bp.failed))
+		for _, f := range bp.failed {
+			fmt.Fprintf(os.Stderr, "  - ID %s: %s\n", f.ID, f.Error)
 		}
 	}
 }
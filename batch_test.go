@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// fakeBatchAPI serves message searches and headers and records batchModify
+// calls. Calls naming any ID in invalid fail with a 400, as Gmail does for
+// unknown message IDs; before that, calls answer with the errors in fail,
+// in turn.
+type fakeBatchAPI struct {
+	t        *testing.T
+	matches  map[string][]string // search query -> message IDs
+	invalid  map[string]bool
+	fail     []fakeError
+	modified []gmail.BatchModifyMessagesRequest
+}
+
+func newFakeBatchAPI(t *testing.T) *fakeBatchAPI {
+	return &fakeBatchAPI{t: t, matches: map[string][]string{}, invalid: map[string]bool{}}
+}
+
+func (f *fakeBatchAPI) conn() *gwcli.CmdG {
+	return newFakeGmail(f.t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "messages" && req.Method == http.MethodGet:
+			return messageList(f.matches[req.URL.Query().Get("q")]...)
+		case path == "messages/batchModify":
+			var r gmail.BatchModifyMessagesRequest
+			decodeRequest(f.t, req, &r)
+			f.modified = append(f.modified, r)
+			if len(f.fail) > 0 {
+				e := f.fail[0]
+				f.fail = f.fail[1:]
+				return e
+			}
+			for _, id := range r.Ids {
+				if f.invalid[id] {
+					return fakeError{code: http.StatusBadRequest, message: "Invalid id value", reason: "invalidArgument"}
+				}
+			}
+			return "{}"
+		case strings.HasPrefix(path, "messages/") && req.Method == http.MethodGet:
+			id := strings.TrimPrefix(path, "messages/")
+			return &gmail.Message{Id: id, Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: "old@example.com"},
+				{Name: "Subject", Value: "Old news " + id},
+			}}}
+		}
+		return nil
+	})
+}
+
+func TestBatchProcessor_Chunks(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	ids := make([]string, 2500)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("m%d", i)
+	}
+
+	bp := newBatchProcessor(len(ids), false)
+	bp.modify(context.Background(), api.conn(), ids, []string{"Label_1"}, nil)
+
+	if len(api.modified) != 3 {
+		t.Fatalf("expected 3 batchModify calls, got %d", len(api.modified))
+	}
+	sent := map[string]bool{}
+	for _, m := range api.modified {
+		if len(m.Ids) > 1000 {
+			t.Errorf("batchModify call with %d IDs", len(m.Ids))
+		}
+		for _, id := range m.Ids {
+			sent[id] = true
+		}
+	}
+	if len(sent) != len(ids) || bp.succeeded() != len(ids) || bp.err() != nil {
+		t.Errorf("sent %d IDs, succeeded %d, err %v", len(sent), bp.succeeded(), bp.err())
+	}
+}
+
+func TestBatchProcessor_ReportsFailedIDs(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	api.invalid["m3"] = true
+	ids := []string{"m1", "m2", "m3", "m4", "m5"}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	bp := newBatchProcessor(len(ids), false)
+	bp.modify(context.Background(), api.conn(), ids, nil, []string{"UNREAD"})
+	err := bp.finish(out, "marked", "Message marked as read")
+	if err == nil {
+		t.Fatal("expected an error for the invalid ID")
+	}
+
+	var got struct {
+		Marked int            `json:"marked"`
+		Errors int            `json:"errors"`
+		Failed []batchFailure `json:"failed"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if got.Marked != 4 || got.Errors != 1 || len(got.Failed) != 1 || got.Failed[0].ID != "m3" {
+		t.Errorf("output = %+v", got)
+	}
+}
+
+func TestBatchProcessor_RetriesRateLimit(t *testing.T) {
+	apiRetryDelay = time.Millisecond
+	defer func() { apiRetryDelay = time.Second }()
+	api := newFakeBatchAPI(t)
+	api.fail = []fakeError{
+		{code: http.StatusTooManyRequests, message: "Too many requests", reason: "rateLimitExceeded"},
+		{code: http.StatusServiceUnavailable, message: "Backend error"},
+	}
+
+	bp := newBatchProcessor(2, false)
+	bp.modify(context.Background(), api.conn(), []string{"m1", "m2"}, []string{"Label_1"}, nil)
+	if len(api.modified) != 3 || bp.succeeded() != 2 || bp.err() != nil {
+		t.Errorf("%d batchModify calls, succeeded %d, err %v; want 3 calls and 2 successes", len(api.modified), bp.succeeded(), bp.err())
+	}
+}
+
+func TestBatchProcessor_DoesNotSplitRequestErrors(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	api.fail = []fakeError{{code: http.StatusBadRequest, message: "Invalid label: Label_9", reason: "invalidArgument"}}
+	ids := []string{"m1", "m2", "m3", "m4"}
+
+	bp := newBatchProcessor(len(ids), false)
+	bp.modify(context.Background(), api.conn(), ids, []string{"Label_9"}, nil)
+	if len(api.modified) != 1 {
+		t.Errorf("expected 1 batchModify call, got %d", len(api.modified))
+	}
+	if len(bp.failed) != len(ids) {
+		t.Errorf("failed = %d, want %d", len(bp.failed), len(ids))
+	}
+}
+
+func TestRunMessagesMarkRead_Query(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	api.matches["from:news@example.com"] = []string{"m1", "m2", "m3"}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	sel := bulkFlags{Query: "from:news@example.com", Max: 1000}
+	if err := runMessagesMarkRead(context.Background(), api.conn(), "", sel, false, out); err != nil {
+		t.Fatalf("runMessagesMarkRead() error = %v", err)
+	}
+	if len(api.modified) != 1 || strings.Join(api.modified[0].Ids, ",") != "m1,m2,m3" {
+		t.Errorf("batchModify = %+v", api.modified)
+	}
+}
+
+func TestRunMessagesDelete_QueryMax(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	api.matches["older_than:1y"] = []string{"m1", "m2", "m3"}
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	sel := bulkFlags{Query: "older_than:1y", Max: 2}
+	err := runMessagesDelete(context.Background(), api.conn(), "", true, sel, false, out)
+	if err == nil || !strings.Contains(err.Error(), "more than --max 2") {
+		t.Errorf("error = %v", err)
+	}
+	if len(api.modified) != 0 {
+		t.Errorf("messages were modified: %+v", api.modified)
+	}
+}
+
+func TestRunMessagesDelete_RequiresForce(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runMessagesDelete(context.Background(), api.conn(), "", false, bulkFlags{Query: "older_than:1y"}, false, out)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestRunMessagesDelete_DryRun(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	api.matches["older_than:1y"] = []string{"m1", "m2"}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	sel := bulkFlags{Query: "older_than:1y", Max: 1000, DryRun: true}
+	if err := runMessagesDelete(context.Background(), api.conn(), "", false, sel, false, out); err != nil {
+		t.Fatalf("runMessagesDelete() error = %v", err)
+	}
+	if len(api.modified) != 0 {
+		t.Errorf("dry run modified messages: %+v", api.modified)
+	}
+	var got bulkPreview
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if got.Action != "delete" || got.Matched != 2 || len(got.Sample) != 2 || got.Sample[1].Subject != "Old news m2" {
+		t.Errorf("preview = %+v", got)
+	}
+}
+
+func TestRunMessagesDelete_DryRunOverMax(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	api.matches["older_than:1y"] = []string{"m1", "m2", "m3"}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	sel := bulkFlags{Query: "older_than:1y", Max: 2, DryRun: true}
+	if err := runMessagesDelete(context.Background(), api.conn(), "", false, sel, false, out); err != nil {
+		t.Fatalf("runMessagesDelete() error = %v", err)
+	}
+	var got bulkPreview
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if got.Matched != 2 || !got.More || len(got.Sample) != 2 {
+		t.Errorf("preview = %+v, want 2 matched, more and a sample of 2", got)
+	}
+
+	buf.Reset()
+	out.json = false
+	if err := runMessagesDelete(context.Background(), api.conn(), "", false, sel, false, out); err != nil {
+		t.Fatalf("runMessagesDelete() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "Dry run: would delete more than 2 messages") {
+		t.Errorf("output = %q", buf.String())
+	}
+}
+
+func TestBulkFlags_OneSource(t *testing.T) {
+	api := newFakeBatchAPI(t)
+	out := &outputWriter{writer: &bytes.Buffer{}}
+	_, err := bulkFlags{Query: "is:unread"}.messageIDs(context.Background(), api.conn(), "m1", "message ID", out)
+	if err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("error = %v", err)
+	}
+}
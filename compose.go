@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// extraMIMETypes covers common attachment types that the mime package does
+// not know about without a system mime.types file.
+var extraMIMETypes = map[string]string{
+	".csv":  "text/csv",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".eml":  "message/rfc822",
+	".gz":   "application/gzip",
+	".ics":  "text/calendar",
+	".md":   "text/markdown",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".rtf":  "application/rtf",
+	".tar":  "application/x-tar",
+	".tgz":  "application/gzip",
+	".txt":  "text/plain",
+	".vcf":  "text/vcard",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".zip":  "application/zip",
+	".7z":   "application/x-7z-compressed",
+}
+
+// detectContentType picks a MIME type for an attachment from its file
+// extension, falling back to sniffing the first bytes of data.
+func detectContentType(filename string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if t, ok := extraMIMETypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+// base64Body encodes data as base64 wrapped at 76 columns, as RFC 2045
+// requires.
+func base64Body(data []byte) string {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(enc) > 76 {
+		b.WriteString(enc[:76])
+		b.WriteString("\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc)
+	return b.String()
+}
+
+// attachmentPart returns a base64 encoded part for a file attachment. An
+// empty mimeType is detected from the file name and contents.
+func attachmentPart(filename, mimeType string, data []byte) *gwcli.Part {
+	if mimeType == "" {
+		mimeType = detectContentType(filename, data)
+	}
+	return &gwcli.Part{
+		Header: textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf(`%s; name=%q`, mimeType, filename)},
+			"Content-Disposition":       {fmt.Sprintf(`attachment; filename=%q`, filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		},
+		Contents: base64Body(data),
+	}
+}
+
+// inlineContentID returns the Content-ID of the n-th (from 1) inline file:
+// its file name with everything but ASCII letters, digits, '.', '-' and '_'
+// replaced by '_', followed by ".<n>@gwcli". The number keeps files with
+// the same name apart, and the result is always a valid msg-id.
+func inlineContentID(filename string, n int) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, filename)
+	return fmt.Sprintf("%s.%d@gwcli", name, n)
+}
+
+// inlinePart returns a part for a file embedded in an HTML body. It is
+// referenced from the HTML as cid:<contentID>.
+func inlinePart(filename, contentID string, data []byte) *gwcli.Part {
+	p := attachmentPart(filename, "", data)
+	p.Header.Set("Content-Disposition", fmt.Sprintf(`inline; filename=%q`, filename))
+	p.Header.Set("Content-ID", "<"+contentID+">")
+	return p
+}
+
+// textPart returns a UTF-8 body part of the given text subtype. Bodies that
+// are not 7-bit safe are quoted-printable encoded, so the part survives
+// transport unchanged and can be covered by a PGP/MIME signature (RFC 3156
+// section 5 requires signed content to be 7-bit).
+func textPart(subtype, body string) *gwcli.Part {
+	p := &gwcli.Part{
+		Header: textproto.MIMEHeader{
+			"Content-Type":        {fmt.Sprintf(`text/%s; charset="UTF-8"`, subtype)},
+			"Content-Disposition": {"inline"},
+		},
+		Contents: body,
+	}
+	if !sevenBitSafe(body) {
+		var buf bytes.Buffer
+		w := quotedprintable.NewWriter(&buf)
+		w.Write([]byte(body))
+		w.Close()
+		p.Header.Set("Content-Transfer-Encoding", "quoted-printable")
+		p.Contents = buf.String()
+	}
+	return p
+}
+
+// sevenBitSafe reports whether body can be sent without a transfer
+// encoding: ASCII only, lines within the SMTP limit of 998 characters, and
+// no trailing whitespace that a mail server might strip.
+func sevenBitSafe(body string) bool {
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		if len(line) > 998 || strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") {
+			return false
+		}
+		for i := 0; i < len(line); i++ {
+			if line[i] >= 0x80 || line[i] == '\r' || line[i] == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// multipartPart nests parts inside a single multipart/<subtype> part.
+func multipartPart(subtype string, parts []*gwcli.Part) (*gwcli.Part, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		pw, err := w.CreatePart(p.Header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create part: %w", err)
+		}
+		if _, err := pw.Write([]byte(p.Contents)); err != nil {
+			return nil, fmt.Errorf("failed to write part: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multipart: %w", err)
+	}
+	return &gwcli.Part{
+		Header: textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf("multipart/%s; boundary=%s", subtype, w.Boundary())},
+		},
+		Contents: buf.String(),
+	}, nil
+}
+
+// markdownRenderer renders GitHub flavored markdown (tables, fenced code,
+// autolinks). Raw HTML in the source is dropped and unsafe link schemes
+// are not rendered.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// renderMarkdown converts a markdown body to sanitized HTML.
+func renderMarkdown(body string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(body), &buf); err != nil {
+		return "", fmt.Errorf("failed to render markdown: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// appendSignature appends an alias signature (HTML, as stored by Gmail) to
+// a body. Plain bodies get the usual "-- " separator and a text version of
+// the signature; markdown bodies get a horizontal rule.
+func appendSignature(body, signature string, html, markdown bool) string {
+	if html {
+		return body + `<br><br><div class="gmail_signature">` + signature + `</div>`
+	}
+	text, err := convertHTMLToMarkdown(signature)
+	if err != nil {
+		text = stripHTMLTags(signature)
+	}
+	body = strings.TrimRight(body, "\n")
+	if markdown {
+		return body + "\n\n---\n\n" + text + "\n"
+	}
+	return body + "\n\n-- \n" + text + "\n"
+}
+
+// htmlBodyPart returns the body part for an HTML message: a
+// multipart/alternative with a plain-text version for text-only clients,
+// wrapped in multipart/related together with any inline parts. When plain
+// is empty it is derived from the HTML.
+func htmlBodyPart(body, plain string, inline []*gwcli.Part) (*gwcli.Part, error) {
+	if plain == "" {
+		var err error
+		if plain, err = convertHTMLToMarkdown(body); err != nil {
+			plain = stripHTMLTags(body)
+		}
+	}
+	alt, err := multipartPart("alternative", []*gwcli.Part{
+		textPart("plain", plain),
+		textPart("html", body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(inline) == 0 {
+		return alt, nil
+	}
+	return multipartPart("related", append([]*gwcli.Part{alt}, inline...))
+}
+
+// readInlineParts reads files to embed in an HTML body, numbered in order
+// for their Content-IDs.
+func readInlineParts(paths []string) ([]*gwcli.Part, error) {
+	var parts []*gwcli.Part
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inline file %s: %w", path, err)
+		}
+		name := filepath.Base(path)
+		parts = append(parts, inlinePart(name, inlineContentID(name, i+1), data))
+	}
+	return parts, nil
+}
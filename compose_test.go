@@ -0,0 +1,253 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"report.DOCX", "PK\x03\x04", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"data.csv", "a,b\n1,2\n", "text/csv"},
+		{"archive.zip", "PK\x03\x04", "application/zip"},
+		{"scan.pdf", "%PDF-1.7", "application/pdf"},
+		{"photo", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"notes", "just some text", "text/plain; charset=utf-8"},
+		{"blob", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectContentType(tt.name, []byte(tt.data)); got != tt.want {
+				t.Errorf("detectContentType(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// rawPart is a parsed MIME part with its undecoded body.
+type rawPart struct {
+	Header textproto.MIMEHeader
+	Body   string
+}
+
+// readMultipart returns the parts of a multipart body with the given
+// Content-Type header.
+func readMultipart(t *testing.T, contentType, body string) []rawPart {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("parse %q: %v", contentType, err)
+	}
+	r := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []rawPart
+	for {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		parts = append(parts, rawPart{Header: p.Header, Body: string(data)})
+	}
+}
+
+func TestBuildOutgoingMessage_HTMLWithInline(t *testing.T) {
+	dir := t.TempDir()
+	logo := filepath.Join(dir, "logo.png")
+	if err := os.WriteFile(logo, []byte("\x89PNG\r\n\x1a\nimage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sheet := filepath.Join(dir, "q3.xlsx")
+	if err := os.WriteFile(sheet, []byte("PK\x03\x04"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, parts, err := buildOutgoingMessage([]string{"a@example.com"}, nil, nil, "Hi",
+		`<p>Hello <b>there</b></p><img src="cid:logo.png.1@gwcli">`, []string{sheet}, []string{logo}, composeOptions{html: true})
+	if err != nil {
+		t.Fatalf("buildOutgoingMessage() error = %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("expected body and attachment parts, got %d", len(parts))
+	}
+
+	if ct := parts[1].Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet;") {
+		t.Errorf("attachment Content-Type = %q", ct)
+	}
+
+	related := parts[0]
+	if ct := related.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/related;") {
+		t.Fatalf("body Content-Type = %q, want multipart/related", ct)
+	}
+	relParts := readMultipart(t, related.Header.Get("Content-Type"), related.Contents)
+	if len(relParts) != 2 {
+		t.Fatalf("expected 2 related parts, got %d", len(relParts))
+	}
+	img := relParts[1]
+	if got := img.Header.Get("Content-Id"); got != "<logo.png.1@gwcli>" {
+		t.Errorf("Content-ID = %q, want <logo.png.1@gwcli>", got)
+	}
+	if got := img.Header.Get("Content-Disposition"); !strings.HasPrefix(got, "inline;") {
+		t.Errorf("inline Content-Disposition = %q", got)
+	}
+	if got := img.Header.Get("Content-Type"); !strings.HasPrefix(got, "image/png;") {
+		t.Errorf("inline Content-Type = %q", got)
+	}
+
+	altType := relParts[0].Header.Get("Content-Type")
+	if !strings.HasPrefix(altType, "multipart/alternative;") {
+		t.Fatalf("first related part = %q, want multipart/alternative", altType)
+	}
+	alt := readMultipart(t, altType, relParts[0].Body)
+	if len(alt) != 2 {
+		t.Fatalf("expected 2 alternatives, got %d", len(alt))
+	}
+	plain := alt[0].Body
+	if !strings.HasPrefix(alt[0].Header.Get("Content-Type"), "text/plain") || strings.Contains(plain, "<b>") {
+		t.Errorf("plain alternative = %q (%s)", plain, alt[0].Header.Get("Content-Type"))
+	}
+	if !strings.Contains(plain, "Hello") {
+		t.Errorf("plain alternative missing text: %q", plain)
+	}
+	if !strings.HasPrefix(alt[1].Header.Get("Content-Type"), "text/html") {
+		t.Errorf("second alternative = %q, want text/html", alt[1].Header.Get("Content-Type"))
+	}
+}
+
+func TestInlineContentID(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"logo.png", 1, "logo.png.1@gwcli"},
+		{"team photo.jpg", 2, "team_photo.jpg.2@gwcli"},
+		{"Grüße<1>.gif", 3, "Gr__e_1_.gif.3@gwcli"},
+	}
+	for _, tt := range tests {
+		if got := inlineContentID(tt.name, tt.n); got != tt.want {
+			t.Errorf("inlineContentID(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+
+	// The same file name from two directories gets two IDs.
+	dir := t.TempDir()
+	var paths []string
+	for _, sub := range []string{"a", "b"} {
+		p := filepath.Join(dir, sub, "logo.png")
+		os.MkdirAll(filepath.Dir(p), 0o700)
+		if err := os.WriteFile(p, []byte(sub), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	parts, err := readInlineParts(paths)
+	if err != nil {
+		t.Fatalf("readInlineParts() error = %v", err)
+	}
+	if a, b := parts[0].Header.Get("Content-Id"), parts[1].Header.Get("Content-Id"); a != "<logo.png.1@gwcli>" || b != "<logo.png.2@gwcli>" {
+		t.Errorf("Content-IDs = %s, %s", a, b)
+	}
+}
+
+func TestBuildOutgoingMessage_InlineRequiresHTML(t *testing.T) {
+	_, _, err := buildOutgoingMessage([]string{"a@example.com"}, nil, nil, "Hi", "plain", nil, []string{"logo.png"}, composeOptions{})
+	if err == nil || !strings.Contains(err.Error(), "--inline requires --html") {
+		t.Errorf("error = %v, want --inline requires --html", err)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	src := "# Status\n\n| Task | Owner |\n| --- | --- |\n| Deploy | Ana |\n\n```go\nfmt.Println(\"hi\")\n```\n\n" +
+		"See [the docs](https://example.com/docs) or https://example.com.\n\n<script>alert(1)</script>\n\n[bad](javascript:alert(1))\n"
+	got, err := renderMarkdown(src)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	for _, want := range []string{
+		"<h1>Status</h1>",
+		"<table>",
+		"<td>Deploy</td>",
+		`<pre><code class="language-go">`,
+		`<a href="https://example.com/docs">the docs</a>`,
+		`<a href="https://example.com">https://example.com</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rendered HTML missing %q:\n%s", want, got)
+		}
+	}
+	for _, bad := range []string{"<script>", "javascript:"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("rendered HTML should not contain %q:\n%s", bad, got)
+		}
+	}
+}
+
+func TestBuildOutgoingMessage_Markdown(t *testing.T) {
+	src := "Hello **team**\n"
+	_, parts, err := buildOutgoingMessage([]string{"a@example.com"}, nil, nil, "Hi", src, nil, nil, composeOptions{markdown: true})
+	if err != nil {
+		t.Fatalf("buildOutgoingMessage() error = %v", err)
+	}
+	if len(parts) != 1 {
+		t.Fatalf("expected a single body part, got %d", len(parts))
+	}
+	ct := parts[0].Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "multipart/alternative;") {
+		t.Fatalf("body Content-Type = %q, want multipart/alternative", ct)
+	}
+	alt := readMultipart(t, ct, parts[0].Contents)
+	if len(alt) != 2 {
+		t.Fatalf("expected 2 alternatives, got %d", len(alt))
+	}
+	if alt[0].Body != src {
+		t.Errorf("plain part = %q, want the original markdown", alt[0].Body)
+	}
+	if !strings.Contains(alt[1].Body, "<strong>team</strong>") {
+		t.Errorf("html part = %q", alt[1].Body)
+	}
+
+	if _, _, err := buildOutgoingMessage([]string{"a@example.com"}, nil, nil, "Hi", src, nil, nil, composeOptions{html: true, markdown: true}); err == nil {
+		t.Error("expected --html and --markdown to be rejected together")
+	}
+}
+
+func TestTextPart_QuotedPrintable(t *testing.T) {
+	if p := textPart("plain", "plain ascii\nbody\n"); p.Header.Get("Content-Transfer-Encoding") != "" || p.Contents != "plain ascii\nbody\n" {
+		t.Errorf("7-bit body should be left as is, got %v %q", p.Header, p.Contents)
+	}
+
+	body := "Grüße, " + strings.Repeat("x", 1000) + "\ntrailing space \n"
+	p := textPart("plain", body)
+	if got := p.Header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+		t.Fatalf("Content-Transfer-Encoding = %q, want quoted-printable", got)
+	}
+	for _, line := range strings.Split(p.Contents, "\r\n") {
+		if len(line) > 76 || strings.ContainsFunc(line, func(r rune) bool { return r >= 0x80 }) {
+			t.Errorf("encoded line is not 7-bit: %q", line)
+		}
+	}
+	dec, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(p.Contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(body, "\n", "\r\n"); string(dec) != want {
+		t.Errorf("decoded body = %q, want %q", dec, want)
+	}
+}
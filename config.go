@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/wesnick/gwcli/pkg/gpg"
 	"github.com/wesnick/gwcli/pkg/gwcli"
 )
 
@@ -12,12 +13,14 @@ func getConnection(configDir string, userEmail string, verbose bool) (*gwcli.Cmd
 	if err != nil {
 		return nil, fmt.Errorf("failed to create connection: %w", err)
 	}
+	// Used to verify and decrypt PGP/MIME messages when they are loaded.
+	gwcli.GPG = gpg.New(gpgBinary)
 
 	return conn, nil
 }
 
 // runConfigure runs the OAuth configuration flow
-func runConfigure(configDir string) error {
+func runConfigure(configDir string, allowPurge bool) error {
 	paths, err := gwcli.GetConfigPaths(configDir)
 	if err != nil {
 		return err
@@ -28,9 +31,12 @@ func runConfigure(configDir string) error {
 	fmt.Printf("Required files:\n")
 	fmt.Printf("  - %s (OAuth credentials from Google Console)\n", paths.Credentials)
 	fmt.Printf("  - %s (will be auto-generated)\n\n", paths.Token)
+	if allowPurge {
+		fmt.Printf("Also requesting https://mail.google.com/ (full mailbox access) for `trash empty`.\n\n")
+	}
 
 	ctx := context.Background()
-	if err := gwcli.ConfigureAuth(ctx, paths, 8080); err != nil {
+	if err := gwcli.ConfigureAuth(ctx, paths, 8080, allowPurge); err != nil {
 		return fmt.Errorf("configuration failed: %w", err)
 	}
 
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// draftListOutput is JSON output format for draft lists.
+type draftListOutput struct {
+	ID        string `json:"id"`
+	MessageID string `json:"messageId"`
+	ThreadID  string `json:"threadId,omitempty"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Date      string `json:"date"`
+	Snippet   string `json:"snippet"`
+}
+
+// draftReadOutput is JSON output format for a single draft.
+type draftReadOutput struct {
+	ID          string            `json:"id"`
+	MessageID   string            `json:"messageId"`
+	ThreadID    string            `json:"threadId,omitempty"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body,omitempty"`
+	BodyHTML    string            `json:"bodyHtml,omitempty"`
+	Attachments []attachmentInfo  `json:"attachments,omitempty"`
+}
+
+// loadDraft fetches a draft with its full payload.
+func loadDraft(ctx context.Context, conn *gwcli.CmdG, draftID string) (*gwcli.Draft, error) {
+	d := gwcli.NewDraft(conn, draftID)
+	if _, err := d.GetBody(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get draft: %w", err)
+	}
+	if d.Response == nil || d.Response.Message == nil {
+		return nil, fmt.Errorf("draft %s has no message", draftID)
+	}
+	return d, nil
+}
+
+// runDraftsList lists all drafts.
+func runDraftsList(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) error {
+	drafts, err := conn.ListDrafts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list drafts: %w", err)
+	}
+	if len(drafts) == 0 {
+		return out.WriteEmptyList("No drafts found")
+	}
+
+	output := make([]draftListOutput, 0, len(drafts))
+	for _, d := range drafts {
+		to, _ := d.GetHeader(ctx, "To")
+		subject, _ := d.GetHeader(ctx, "Subject")
+		date, _ := d.GetHeader(ctx, "Date")
+		item := draftListOutput{ID: d.ID, To: to, Subject: subject, Date: date}
+		if d.Response != nil && d.Response.Message != nil {
+			item.MessageID = d.Response.Message.Id
+			item.ThreadID = d.Response.Message.ThreadId
+			item.Snippet = d.Response.Message.Snippet
+		}
+		output = append(output, item)
+	}
+
+	if out.json {
+		return out.writeJSON(output)
+	}
+
+	headers := []string{"ID", "TO", "SUBJECT", "DATE"}
+	rows := make([][]string, len(output))
+	for i, d := range output {
+		rows[i] = []string{
+			d.ID,
+			truncateString(d.To, 30),
+			truncateString(draftSubject(d), 50),
+			d.Date,
+		}
+	}
+	return out.writeTable(headers, rows)
+}
+
+// runDraftsRead prints a draft for review.
+func runDraftsRead(ctx context.Context, conn *gwcli.CmdG, draftID string, preferPlain bool, out *outputWriter) error {
+	d, err := loadDraft(ctx, conn, draftID)
+	if err != nil {
+		return err
+	}
+	m := d.Response.Message
+
+	var infos []attachmentInfo
+	extractAttachmentsFromPart(m.Payload, &infos)
+	for i := range infos {
+		infos[i].Index = i
+	}
+
+	if out.json {
+		output := draftReadOutput{
+			ID:        d.ID,
+			MessageID: m.Id,
+			ThreadID:  m.ThreadId,
+			Headers:   make(map[string]string),
+			Body:      extractPlainTextFromPart(m.Payload),
+			BodyHTML:  extractHTMLFromPart(m.Payload),
+		}
+		for _, h := range []string{"From", "To", "Cc", "Bcc", "Subject", "Date", "In-Reply-To", "References"} {
+			if v := headerValue(m.Payload, h); v != "" {
+				output.Headers[h] = v
+			}
+		}
+		if len(infos) > 0 {
+			output.Attachments = infos
+		}
+		return out.writeJSON(output)
+	}
+
+	format := FormatMarkdown
+	if preferPlain {
+		format = FormatPlainText
+	}
+	body, note, err := threadMessageBody(m, format)
+	if err != nil {
+		return err
+	}
+
+	var attachmentsMeta []AttachmentMeta
+	for _, att := range infos {
+		attachmentsMeta = append(attachmentsMeta, AttachmentMeta{
+			Index:    att.Index,
+			Filename: att.Filename,
+			MimeType: att.MimeType,
+			Size:     att.Size,
+		})
+	}
+
+	formatted, err := formatEmailAsMarkdown(EmailFrontmatter{
+		DraftID:   d.ID,
+		MessageID: m.Id,
+		ThreadID:  m.ThreadId,
+		From:      headerValue(m.Payload, "From"),
+		To:        headerValue(m.Payload, "To"),
+		Cc:        headerValue(m.Payload, "Cc"),
+		Bcc:       headerValue(m.Payload, "Bcc"),
+		Subject:   headerValue(m.Payload, "Subject"),
+		Date:      headerValue(m.Payload, "Date"),
+		Labels:    m.LabelIds,
+		Note:      note,
+	}, body, attachmentsMeta)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out.writer, formatted)
+	return nil
+}
+
+// splitAddressHeader splits an address header back into individual
+// recipients.
+func splitAddressHeader(v string) []string {
+	if v == "" {
+		return nil
+	}
+	as, err := mail.ParseAddressList(v)
+	if err != nil {
+		return []string{v}
+	}
+	ret := make([]string, len(as))
+	for i, a := range as {
+		ret[i] = a.String()
+	}
+	return ret
+}
+
+// draftsUpdateCmd holds the flags for `gwcli drafts update`.
+type draftsUpdateCmd struct {
+	DraftID  string   `arg:"" name:"draft-id" help:"Draft ID"`
+	To       []string `help:"Replace recipients"`
+	Cc       []string `help:"Replace CC recipients"`
+	Bcc      []string `help:"Replace BCC recipients"`
+	ClearCc  bool     `help:"Remove all CC recipients" name:"clear-cc"`
+	ClearBcc bool     `help:"Remove all BCC recipients" name:"clear-bcc"`
+	Subject  string   `help:"Replace subject line"`
+	Body     string   `help:"Replace message body"`
+	Attach   []string `help:"Add file attachments" type:"existingfile"`
+	HTML     bool     `help:"Body is HTML"`
+}
+
+// draftOwnHeaders are the headers runDraftsUpdate writes itself. Every
+// other header of the draft, such as From, Reply-To, In-Reply-To and custom
+// headers, is kept as it is.
+var draftOwnHeaders = map[string]bool{
+	"To":           true,
+	"Cc":           true,
+	"Bcc":          true,
+	"Subject":      true,
+	"Date":         true,
+	"Mime-Version": true,
+}
+
+// runDraftsUpdate rewrites a draft. Fields that are not given keep their
+// current value, Cc and Bcc are removed with --clear-cc and --clear-bcc, and
+// existing attachments are kept. Without --body the body is kept as it is,
+// HTML alternative and inline images included. Signed or encrypted drafts
+// are refused, since rewriting them would drop the OpenPGP protection.
+func runDraftsUpdate(ctx context.Context, conn *gwcli.CmdG, c draftsUpdateCmd, out *outputWriter) error {
+	if c.ClearCc && len(c.Cc) > 0 {
+		return fmt.Errorf("use either --cc or --clear-cc")
+	}
+	if c.ClearBcc && len(c.Bcc) > 0 {
+		return fmt.Errorf("use either --bcc or --clear-bcc")
+	}
+	d, err := loadDraft(ctx, conn, c.DraftID)
+	if err != nil {
+		return err
+	}
+	m := d.Response.Message
+	if mt := m.Payload.MimeType; mt == "multipart/signed" || mt == "multipart/encrypted" {
+		return fmt.Errorf("draft %s is signed or encrypted and cannot be updated without losing that; delete it and create a new one with 'messages draft --sign/--encrypt'", d.ID)
+	}
+
+	to, cc, bcc, subject := c.To, c.Cc, c.Bcc, c.Subject
+	if len(to) == 0 {
+		to = splitAddressHeader(headerValue(m.Payload, "To"))
+	}
+	if len(cc) == 0 && !c.ClearCc {
+		cc = splitAddressHeader(headerValue(m.Payload, "Cc"))
+	}
+	if len(bcc) == 0 && !c.ClearBcc {
+		bcc = splitAddressHeader(headerValue(m.Payload, "Bcc"))
+	}
+	if subject == "" {
+		subject = headerValue(m.Payload, "Subject")
+	}
+
+	attached, inline, err := originalAttachmentParts(ctx, conn, m.Id, m.Payload, true, out)
+	if err != nil {
+		return err
+	}
+
+	headers, parts, err := buildOutgoingMessage(to, cc, bcc, subject, c.Body, c.Attach, nil, composeOptions{html: c.HTML})
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.Body == "":
+		parts[0], err = draftBodyPart(m.Payload, inline)
+	case c.HTML && len(inline) > 0:
+		// parts[0] is the new multipart/alternative body; the images it may
+		// still reference stay next to it.
+		parts[0], err = multipartPart("related", append([]*gwcli.Part{parts[0]}, inline...))
+	}
+	if err != nil {
+		return err
+	}
+	for _, h := range m.Payload.Headers {
+		key := textproto.CanonicalMIMEHeaderKey(h.Name)
+		if draftOwnHeaders[key] || strings.HasPrefix(key, "Content-") {
+			continue
+		}
+		headers[key] = append(headers[key], h.Value)
+	}
+	parts = append(parts, attached...)
+
+	if err := d.UpdateParts(ctx, "mixed", headers, parts); err != nil {
+		return fmt.Errorf("failed to update draft: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]string{"status": "updated", "draftId": d.ID})
+	}
+
+	out.writeMessage(fmt.Sprintf("Draft updated: %s", d.ID))
+	return nil
+}
+
+// draftBodyPart rebuilds the current body of a draft: its plain text, or
+// its HTML and plain-text alternatives together with the inline parts.
+func draftBodyPart(payload *gmail.MessagePart, inline []*gwcli.Part) (*gwcli.Part, error) {
+	plain := extractPlainTextFromPart(payload)
+	html := extractHTMLFromPart(payload)
+	if html == "" {
+		return textPart("plain", plain), nil
+	}
+	return htmlBodyPart(html, plain, inline)
+}
+
+// runDraftsSend sends an existing draft.
+func runDraftsSend(ctx context.Context, conn *gwcli.CmdG, draftID string, out *outputWriter) error {
+	d := gwcli.NewDraft(conn, draftID)
+	sent, err := d.Send(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to send draft: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]string{"status": "sent", "draftId": draftID, "id": sent.Id, "threadId": sent.ThreadId})
+	}
+
+	out.writeMessage(fmt.Sprintf("Draft %s sent (ID: %s)", draftID, sent.Id))
+	return nil
+}
+
+// runDraftsDelete permanently deletes a draft.
+func runDraftsDelete(ctx context.Context, conn *gwcli.CmdG, draftID string, force bool, out *outputWriter) error {
+	if !force {
+		return fmt.Errorf("refusing to delete draft %s without --force", draftID)
+	}
+	d := gwcli.NewDraft(conn, draftID)
+	if err := d.Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]string{"status": "deleted", "draftId": draftID})
+	}
+
+	out.writeMessage(fmt.Sprintf("Deleted draft %s", draftID))
+	return nil
+}
+
+// draftSubject returns the subject a draft would be listed under.
+func draftSubject(d draftListOutput) string {
+	if strings.TrimSpace(d.Subject) == "" {
+		return "(no subject)"
+	}
+	return d.Subject
+}
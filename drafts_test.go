@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+const draftTestResponse = `{"id":"D1","message":{"id":"M1","threadId":"T1","snippet":"hello there","payload":{
+	"mimeType":"text/plain",
+	"headers":[
+		{"name":"From","value":"Support <support@example.com>"},
+		{"name":"Reply-To","value":"help@example.com"},
+		{"name":"X-Ticket","value":"1234"},
+		{"name":"To","value":"Alice <alice@example.com>"},
+		{"name":"Cc","value":"carol@example.com"},
+		{"name":"Bcc","value":"dave@example.com"},
+		{"name":"Subject","value":"Draft subject"},
+		{"name":"Date","value":"Mon, 2 Jun 2025 10:00:00 +0000"},
+		{"name":"In-Reply-To","value":"<orig@example.com>"}],
+	"body":{"data":"aGVsbG8gdGhlcmU="}}}}`
+
+// htmlDraftResponse is a draft with an HTML body, a plain-text alternative
+// and an inline image referenced as cid:logo.
+const htmlDraftResponse = `{"id":"D1","message":{"id":"M1","threadId":"T1","payload":{
+	"mimeType":"multipart/related","body":{"size":0},
+	"headers":[{"name":"To","value":"alice@example.com"},{"name":"Subject","value":"Newsletter"}],
+	"parts":[
+		{"mimeType":"multipart/alternative","body":{"size":0},"parts":[
+			{"mimeType":"text/plain","body":{"data":"SGVsbG8="}},
+			{"mimeType":"text/html","body":{"data":"PHA-SGVsbG88aW1nIHNyYz0iY2lkOmxvZ28iPjwvcD4="}}]},
+		{"mimeType":"image/png","filename":"logo.png","headers":[{"name":"Content-ID","value":"<logo>"},{"name":"Content-Disposition","value":"inline; filename=\"logo.png\""}],
+			"body":{"data":"UE5H","size":3}}]}}}`
+
+// newFakeDraftsConn serves draft as the single draft D1 and records update
+// and send requests.
+func newFakeDraftsConn(t *testing.T, draft string, updated **gmail.Draft, sent *bool) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "drafts" && req.Method == http.MethodGet:
+			return `{"drafts":[{"id":"D1"}]}`
+		case path == "drafts/send":
+			*sent = true
+			return `{"id":"M2","threadId":"T1"}`
+		case path == "drafts/D1" && req.Method == http.MethodGet:
+			return draft
+		case path == "drafts/D1" && req.Method == http.MethodPut:
+			*updated = &gmail.Draft{}
+			decodeRequest(t, req, *updated)
+			return `{"id":"D1"}`
+		}
+		return nil
+	})
+}
+
+func TestRunDraftsList(t *testing.T) {
+	var sent bool
+	var updated *gmail.Draft
+	conn := newFakeDraftsConn(t, draftTestResponse, &updated, &sent)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runDraftsList(context.Background(), conn, out); err != nil {
+		t.Fatalf("runDraftsList() error = %v", err)
+	}
+
+	var got []draftListOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 draft, got %d", len(got))
+	}
+	if got[0].ID != "D1" || got[0].MessageID != "M1" || got[0].Subject != "Draft subject" || got[0].ThreadID != "T1" {
+		t.Errorf("unexpected draft: %+v", got[0])
+	}
+}
+
+func TestRunDraftsUpdate_KeepsUnsetFields(t *testing.T) {
+	var sent bool
+	var updated *gmail.Draft
+	conn := newFakeDraftsConn(t, draftTestResponse, &updated, &sent)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runDraftsUpdate(context.Background(), conn, draftsUpdateCmd{DraftID: "D1", Subject: "New subject"}, out); err != nil {
+		t.Fatalf("runDraftsUpdate() error = %v", err)
+	}
+	if updated == nil || updated.Message == nil {
+		t.Fatal("expected draft update request")
+	}
+	if updated.Message.ThreadId != "T1" {
+		t.Errorf("threadId = %q, want T1 to be kept", updated.Message.ThreadId)
+	}
+	raw, err := gwcli.MIMEDecode(updated.Message.Raw)
+	if err != nil {
+		t.Fatalf("decode raw: %v", err)
+	}
+	for _, want := range []string{
+		"Subject: New subject",
+		`To: "Alice" <alice@example.com>`,
+		"Cc: carol@example.com",
+		"Bcc: dave@example.com",
+		"In-Reply-To: <orig@example.com>",
+		`From: "Support" <support@example.com>`,
+		"Reply-To: help@example.com",
+		"X-Ticket: 1234",
+		"hello there",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("updated draft missing %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestRunDraftsUpdate_KeepsHTMLAndInlineImages(t *testing.T) {
+	var sent bool
+	var updated *gmail.Draft
+	conn := newFakeDraftsConn(t, htmlDraftResponse, &updated, &sent)
+
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	if err := runDraftsUpdate(context.Background(), conn, draftsUpdateCmd{DraftID: "D1", Subject: "Newsletter #2"}, out); err != nil {
+		t.Fatalf("runDraftsUpdate() error = %v", err)
+	}
+	raw, err := gwcli.MIMEDecode(updated.Message.Raw)
+	if err != nil {
+		t.Fatalf("decode raw: %v", err)
+	}
+	for _, want := range []string{
+		"Content-Type: multipart/related",
+		"Content-Type: multipart/alternative",
+		`<p>Hello<img src="cid:logo"></p>`,
+		"Content-Id: <logo>",
+		`Content-Disposition: inline; filename="logo.png"`,
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("updated draft missing %q:\n%s", want, raw)
+		}
+	}
+	if strings.Contains(raw, "attachment;") {
+		t.Errorf("inline image was re-attached as an attachment:\n%s", raw)
+	}
+}
+
+func TestRunDraftsUpdate_RefusesSigned(t *testing.T) {
+	var sent bool
+	var updated *gmail.Draft
+	signed := strings.Replace(htmlDraftResponse, `"multipart/related"`, `"multipart/signed"`, 1)
+	conn := newFakeDraftsConn(t, signed, &updated, &sent)
+
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runDraftsUpdate(context.Background(), conn, draftsUpdateCmd{DraftID: "D1", Subject: "Changed"}, out)
+	if err == nil || !strings.Contains(err.Error(), "signed or encrypted") {
+		t.Errorf("error = %v", err)
+	}
+	if updated != nil {
+		t.Error("signed draft was updated")
+	}
+}
+
+func TestRunDraftsUpdate_ClearCc(t *testing.T) {
+	var sent bool
+	var updated *gmail.Draft
+	conn := newFakeDraftsConn(t, draftTestResponse, &updated, &sent)
+
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	c := draftsUpdateCmd{DraftID: "D1", ClearCc: true, ClearBcc: true}
+	if err := runDraftsUpdate(context.Background(), conn, c, out); err != nil {
+		t.Fatalf("runDraftsUpdate() error = %v", err)
+	}
+	raw, err := gwcli.MIMEDecode(updated.Message.Raw)
+	if err != nil {
+		t.Fatalf("decode raw: %v", err)
+	}
+	if strings.Contains(raw, "Cc:") || strings.Contains(raw, "Bcc:") {
+		t.Errorf("Cc and Bcc should be cleared:\n%s", raw)
+	}
+	if !strings.Contains(raw, "Subject: Draft subject") || !strings.Contains(raw, "hello there") {
+		t.Errorf("subject and body should be kept:\n%s", raw)
+	}
+
+	c = draftsUpdateCmd{DraftID: "D1", Cc: []string{"erin@example.com"}, ClearCc: true}
+	if err := runDraftsUpdate(context.Background(), conn, c, out); err == nil {
+		t.Error("expected --cc with --clear-cc to be rejected")
+	}
+}
+
+func TestRunDraftsSendAndDelete(t *testing.T) {
+	var sent bool
+	var updated *gmail.Draft
+	conn := newFakeDraftsConn(t, draftTestResponse, &updated, &sent)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runDraftsSend(context.Background(), conn, "D1", out); err != nil {
+		t.Fatalf("runDraftsSend() error = %v", err)
+	}
+	if !sent {
+		t.Error("expected drafts.send to be called")
+	}
+	var res map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res["id"] != "M2" || res["threadId"] != "T1" || res["draftId"] != "D1" {
+		t.Errorf("output = %v, want id M2, threadId T1 and draftId D1", res)
+	}
+
+	if err := runDraftsDelete(context.Background(), conn, "D1", false, out); err == nil {
+		t.Error("expected delete without --force to fail")
+	}
+}
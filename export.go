@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// Export formats for messages export.
+const (
+	exportFormatMbox    = "mbox"
+	exportFormatMaildir = "maildir"
+)
+
+// exportManifestName is the manifest file name inside a Maildir. For mbox
+// the manifest sits next to the mbox file as <file>.manifest.json.
+const exportManifestName = ".gwcli-manifest.json"
+
+// exportManifest records what has been exported, so later runs only add new
+// messages.
+type exportManifest struct {
+	Format   string                `json:"format"`
+	Updated  string                `json:"updated"`
+	Messages []exportManifestEntry `json:"messages"`
+}
+
+type exportManifestEntry struct {
+	ID         string   `json:"id"`
+	ThreadID   string   `json:"threadId"`
+	Labels     []string `json:"labels"`
+	Date       string   `json:"date"`
+	From       string   `json:"from"`
+	Subject    string   `json:"subject"`
+	File       string   `json:"file"`
+	ExportedAt string   `json:"exportedAt"`
+}
+
+// exportFailure is one message that could not be exported.
+type exportFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// exportResult is JSON output format for messages export.
+type exportResult struct {
+	Format   string          `json:"format"`
+	Out      string          `json:"out"`
+	Manifest string          `json:"manifest"`
+	Exported int             `json:"exported"`
+	Skipped  int             `json:"skipped"`
+	Failed   []exportFailure `json:"failed,omitempty"`
+}
+
+// exportManifestPath returns where the manifest for outPath lives.
+func exportManifestPath(format, outPath string) string {
+	if format == exportFormatMaildir {
+		return filepath.Join(outPath, exportManifestName)
+	}
+	return outPath + ".manifest.json"
+}
+
+func loadExportManifest(path, format string) (*exportManifest, error) {
+	m := &exportManifest{Format: format, Messages: []exportManifestEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	if m.Format != format {
+		return nil, fmt.Errorf("%s was exported as %s, not %s", path, m.Format, format)
+	}
+	return m, nil
+}
+
+func saveExportManifest(path string, m *exportManifest) error {
+	m.Updated = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// maildirFlags maps Gmail system labels to Maildir info flags, in the
+// alphabetical order the Maildir spec requires.
+func maildirFlags(labelIDs []string) string {
+	has := map[string]bool{}
+	for _, l := range labelIDs {
+		has[l] = true
+	}
+	var flags []byte
+	if has["DRAFT"] {
+		flags = append(flags, 'D')
+	}
+	if has["STARRED"] {
+		flags = append(flags, 'F')
+	}
+	if !has[gwcli.Unread] {
+		flags = append(flags, 'S')
+	}
+	if has["TRASH"] {
+		flags = append(flags, 'T')
+	}
+	return string(flags)
+}
+
+// exportKeywords returns the label names to record in X-Keywords.
+func exportKeywords(labelIDs []string, idToName map[string]string) []string {
+	var names []string
+	for _, id := range labelIDs {
+		if id == gwcli.Unread {
+			// Read state is carried by Status/Maildir flags instead.
+			continue
+		}
+		if n, ok := idToName[id]; ok {
+			names = append(names, n)
+		} else {
+			names = append(names, id)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportHeaders returns extra header lines added to each exported message.
+func exportHeaders(msgID, threadID string, keywords []string, unread bool) string {
+	var b strings.Builder
+	if len(keywords) > 0 {
+		fmt.Fprintf(&b, "X-Keywords: %s\n", strings.Join(keywords, ", "))
+	}
+	fmt.Fprintf(&b, "X-GM-MSGID: %s\n", msgID)
+	fmt.Fprintf(&b, "X-GM-THRID: %s\n", threadID)
+	if unread {
+		b.WriteString("Status: O\n")
+	} else {
+		b.WriteString("Status: RO\n")
+	}
+	return b.String()
+}
+
+var mboxFromRE = regexp.MustCompile(`(?m)^(>*From )`)
+
+// mboxEntry renders one message in mboxrd format: a From_ separator line,
+// the extra headers, and the message with "From " lines escaped.
+func mboxEntry(raw, extraHeaders, sender string, date time.Time) string {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	if !strings.HasSuffix(raw, "\n") {
+		raw += "\n"
+	}
+	raw = mboxFromRE.ReplaceAllString(raw, ">$1")
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	return fmt.Sprintf("From %s %s\n%s%s\n", sender, date.UTC().Format(time.ANSIC), extraHeaders, raw)
+}
+
+// maildirEntry renders one message for a Maildir: the extra headers followed
+// by the message, with line endings normalized to LF as mboxEntry does so the
+// added headers and the original message agree.
+func maildirEntry(raw, extraHeaders string) string {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	if !strings.HasSuffix(raw, "\n") {
+		raw += "\n"
+	}
+	return extraHeaders + raw
+}
+
+// envelopeSender extracts the bare address from a From header.
+func envelopeSender(from string) string {
+	if a, err := mail.ParseAddress(from); err == nil {
+		return a.Address
+	}
+	return ""
+}
+
+// ensureMaildir creates the cur/new/tmp layout under dir.
+func ensureMaildir(dir string) error {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeMaildirMessage delivers contents into dir/cur via dir/tmp and returns
+// the path relative to dir.
+func writeMaildirMessage(dir, name, contents string) (string, error) {
+	tmp := filepath.Join(dir, "tmp", name)
+	if err := os.WriteFile(tmp, []byte(contents), 0o600); err != nil {
+		return "", err
+	}
+	rel := filepath.Join("cur", name)
+	if err := os.Rename(tmp, filepath.Join(dir, rel)); err != nil {
+		return "", err
+	}
+	return rel, nil
+}
+
+// fetchRawMessage fetches a message in raw format. The raw response also
+// carries the labels, thread and internal date, so exporting a message
+// takes one request.
+func fetchRawMessage(ctx context.Context, conn *gwcli.CmdG, id string) (*gmail.Message, string, error) {
+	m, err := conn.GmailService().Users.Messages.Get("me", id).Format("raw").Context(ctx).Do()
+	if err != nil {
+		return nil, "", err
+	}
+	raw, err := gwcli.MIMEDecode(m.Raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode message: %w", err)
+	}
+	return m, raw, nil
+}
+
+// rawHeader returns the decoded value of a header of a raw message.
+func rawHeader(head mail.Header, name string) string {
+	v := head.Get(name)
+	if dec, err := new(mime.WordDecoder).DecodeHeader(v); err == nil {
+		return dec
+	}
+	return v
+}
+
+// exportManifestEvery is how many exported messages may go by between
+// manifest checkpoints.
+const exportManifestEvery = 100
+
+// runMessagesExport exports messages matching query to an mbox file or a
+// Maildir. Messages already listed in the manifest are skipped. The manifest
+// is checkpointed as the export runs and saved again on every exit path,
+// including an interrupt, so a rerun never duplicates exported messages.
+func runMessagesExport(ctx context.Context, conn *gwcli.CmdG, query, format, outPath string, limit int, out *outputWriter) (err error) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if format != exportFormatMbox && format != exportFormatMaildir {
+		return fmt.Errorf("unsupported format %q (use mbox or maildir)", format)
+	}
+	if outPath == "" {
+		return fmt.Errorf("--out is required")
+	}
+
+	if format == exportFormatMaildir {
+		if err := ensureMaildir(outPath); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(outPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	manifestPath := exportManifestPath(format, outPath)
+	manifest, err := loadExportManifest(manifestPath, format)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(manifest.Messages))
+	for _, e := range manifest.Messages {
+		done[e.ID] = true
+	}
+
+	idToName, err := labelIDToName(ctx, conn, out)
+	if err != nil {
+		return err
+	}
+
+	// Only IDs are listed; messages already in the manifest are never
+	// fetched.
+	ids, _, err := conn.ListMessageIDsN(ctx, "", query, limit)
+	if err != nil {
+		return fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	var mbox *os.File
+	if format == exportFormatMbox {
+		mbox, err = os.OpenFile(outPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open mbox: %w", err)
+		}
+		defer mbox.Close()
+	}
+
+	saved := len(manifest.Messages)
+	defer func() {
+		if len(manifest.Messages) == saved {
+			return
+		}
+		if mbox != nil {
+			if serr := mbox.Sync(); serr != nil && err == nil {
+				err = fmt.Errorf("failed to write mbox: %w", serr)
+			}
+		}
+		if serr := saveExportManifest(manifestPath, manifest); serr != nil && err == nil {
+			err = serr
+		}
+	}()
+
+	result := exportResult{Format: format, Out: outPath, Manifest: manifestPath}
+	for i, id := range ids {
+		if ctx.Err() != nil {
+			return fmt.Errorf("export interrupted after %d messages: %w", result.Exported, ctx.Err())
+		}
+		if done[id] {
+			result.Skipped++
+			continue
+		}
+		out.writeVerbose("Exporting %d/%d: %s", i+1, len(ids), id)
+
+		msg, raw, err := fetchRawMessage(ctx, conn, id)
+		if err != nil {
+			result.Failed = append(result.Failed, exportFailure{ID: id, Error: err.Error()})
+			continue
+		}
+
+		var from, subject string
+		if m, err := mail.ReadMessage(strings.NewReader(raw)); err == nil {
+			from, subject = rawHeader(m.Header, "From"), rawHeader(m.Header, "Subject")
+		}
+		labelIDs := msg.LabelIds
+		threadID := msg.ThreadId
+		date := time.UnixMilli(msg.InternalDate)
+		unread := false
+		for _, l := range labelIDs {
+			unread = unread || l == gwcli.Unread
+		}
+		keywords := exportKeywords(labelIDs, idToName)
+		extra := exportHeaders(id, threadID, keywords, unread)
+
+		var file string
+		if format == exportFormatMbox {
+			if _, err := mbox.WriteString(mboxEntry(raw, extra, envelopeSender(from), date)); err != nil {
+				return fmt.Errorf("failed to write mbox: %w", err)
+			}
+			file = filepath.Base(outPath)
+		} else {
+			name := fmt.Sprintf("%d.%s.gwcli:2,%s", date.Unix(), id, maildirFlags(labelIDs))
+			if file, err = writeMaildirMessage(outPath, name, maildirEntry(raw, extra)); err != nil {
+				result.Failed = append(result.Failed, exportFailure{ID: id, Error: err.Error()})
+				continue
+			}
+		}
+
+		manifest.Messages = append(manifest.Messages, exportManifestEntry{
+			ID:         id,
+			ThreadID:   threadID,
+			Labels:     keywords,
+			Date:       date.UTC().Format(time.RFC3339),
+			From:       from,
+			Subject:    subject,
+			File:       file,
+			ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		})
+		done[id] = true
+		result.Exported++
+
+		if len(manifest.Messages)-saved >= exportManifestEvery {
+			if mbox != nil {
+				if err := mbox.Sync(); err != nil {
+					return fmt.Errorf("failed to write mbox: %w", err)
+				}
+			}
+			if err := saveExportManifest(manifestPath, manifest); err != nil {
+				return err
+			}
+			saved = len(manifest.Messages)
+		}
+	}
+
+	if out.json {
+		if err := out.writeJSON(result); err != nil {
+			return err
+		}
+	} else {
+		out.writeMessage(fmt.Sprintf("Exported %d messages to %s (%d already exported)", result.Exported, outPath, result.Skipped))
+		for _, f := range result.Failed {
+			fmt.Fprintf(os.Stderr, "Warning: failed to export %s: %s\n", f.ID, f.Error)
+		}
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d messages failed to export", len(result.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+)
+
+// newFakeExportConn serves two messages: M1 (unread, labeled Work) and M2
+// (read, starred), in raw format only. rawCalls counts raw fetches; onRaw,
+// if set, is called with the ID of each raw fetch.
+func newFakeExportConn(t *testing.T, rawCalls *int, onRaw func(id string)) *gwcli.CmdG {
+	t.Helper()
+	labels := map[string]string{
+		"M1": `["INBOX","UNREAD","Label_1"]`,
+		"M2": `["INBOX","STARRED"]`,
+	}
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "labels":
+			return `{"labels":[{"id":"Label_1","name":"Work","type":"user"}]}`
+		case path == "messages":
+			return `{"messages":[{"id":"M1","threadId":"T1"},{"id":"M2","threadId":"T2"}]}`
+		case strings.HasPrefix(path, "messages/"):
+			id := strings.TrimPrefix(path, "messages/")
+			if !strings.EqualFold(req.URL.Query().Get("format"), "raw") {
+				return nil
+			}
+			*rawCalls++
+			if onRaw != nil {
+				onRaw(id)
+			}
+			raw := fmt.Sprintf("From: Alice <a@example.com>\r\nSubject: =?utf-8?q?Hi_%s?=\r\n\r\nFrom here on\r\nbody %s\r\n", id, id)
+			return fmt.Sprintf(`{"id":%q,"threadId":"T%s","labelIds":%s,"internalDate":"1700000000000","raw":%q}`,
+				id, id[1:], labels[id], gwcli.MIMEEncode(raw))
+		}
+		return nil
+	})
+}
+
+func TestRunMessagesExport_MboxIncremental(t *testing.T) {
+	outPath := filepath.Join(t.TempDir(), "archive.mbox")
+	var rawCalls int
+	conn := newFakeExportConn(t, &rawCalls, nil)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesExport(context.Background(), conn, "label:Work", "mbox", outPath, 0, out); err != nil {
+		t.Fatalf("runMessagesExport() error = %v", err)
+	}
+	var res exportResult
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res.Exported != 2 || res.Skipped != 0 {
+		t.Errorf("first run exported=%d skipped=%d, want 2/0", res.Exported, res.Skipped)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox := string(data)
+	for _, want := range []string{
+		"From a@example.com Tue Nov 14 22:13:20 2023\n",
+		"X-Keywords: INBOX, Work\n",
+		"Status: O\n",
+		"Status: RO\n",
+		"\n>From here on\n",
+	} {
+		if !strings.Contains(mbox, want) {
+			t.Errorf("mbox missing %q:\n%s", want, mbox)
+		}
+	}
+	if strings.Contains(mbox, "\r\n") {
+		t.Error("mbox should use LF line endings")
+	}
+
+	// A second run finds everything in the manifest and fetches nothing.
+	buf.Reset()
+	rawCalls = 0
+	if err := runMessagesExport(context.Background(), conn, "label:Work", "mbox", outPath, 0, out); err != nil {
+		t.Fatalf("second runMessagesExport() error = %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Exported != 0 || res.Skipped != 2 || rawCalls != 0 {
+		t.Errorf("second run exported=%d skipped=%d rawCalls=%d, want 0/2/0", res.Exported, res.Skipped, rawCalls)
+	}
+
+	m, err := loadExportManifest(res.Manifest, "mbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Messages) != 2 || m.Messages[0].ID != "M1" || m.Messages[0].Subject != "Hi M1" {
+		t.Errorf("unexpected manifest: %+v", m.Messages)
+	}
+}
+
+func TestRunMessagesExport_MaildirFlags(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Mail")
+	var rawCalls int
+	conn := newFakeExportConn(t, &rawCalls, nil)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesExport(context.Background(), conn, "in:inbox", "maildir", dir, 0, out); err != nil {
+		t.Fatalf("runMessagesExport() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cur"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"1700000000.M1.gwcli:2,", "1700000000.M2.gwcli:2,FS"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("maildir files = %v, want %v", names, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, exportManifestName)); err != nil {
+		t.Errorf("manifest not written: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cur", want[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("\r")) {
+		t.Errorf("maildir message has mixed line endings: %q", data)
+	}
+	if !bytes.HasPrefix(data, []byte("X-Keywords: INBOX, Work\nX-GM-MSGID: M1\n")) {
+		t.Errorf("maildir message = %q, want extra headers first", data)
+	}
+}
+
+func TestRunMessagesExport_InterruptSavesManifest(t *testing.T) {
+	outPath := filepath.Join(t.TempDir(), "mail.mbox")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var rawCalls int
+	conn := newFakeExportConn(t, &rawCalls, func(id string) {
+		if id == "M1" {
+			cancel()
+		}
+	})
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	err := runMessagesExport(ctx, conn, "label:Work", "mbox", outPath, 0, out)
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("runMessagesExport() error = %v, want interrupted", err)
+	}
+
+	m, err := loadExportManifest(exportManifestPath("mbox", outPath), "mbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Messages) != 1 || m.Messages[0].ID != "M1" {
+		t.Errorf("manifest = %+v, want only M1", m.Messages)
+	}
+}
+
+func TestMboxEntryEscapesFromLines(t *testing.T) {
+	got := mboxEntry("Subject: x\r\n\r\nFrom me\r\n>From you\r\n", "", "", time.Unix(0, 0))
+	want := "From MAILER-DAEMON Thu Jan  1 00:00:00 1970\nSubject: x\n\n>From me\n>>From you\n\n"
+	if got != want {
+		t.Errorf("mboxEntry() = %q, want %q", got, want)
+	}
+}
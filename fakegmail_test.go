@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// fakeGmailFunc answers one request to a fake Gmail API. path is the
+// request path below users/me, such as "messages/M1". A string result is
+// sent as is, a fakeError as an API error, anything else as JSON; nil fails
+// the test as an unexpected request.
+type fakeGmailFunc func(req *http.Request, path string) interface{}
+
+// fakeError is an API error response from a fakeGmailFunc.
+type fakeError struct {
+	code    int
+	message string
+	reason  string
+}
+
+// newFakeGmail returns a connection whose Gmail API requests are answered
+// by serve. Requests are served one at a time, so serve may record them
+// without locking.
+func newFakeGmail(t *testing.T, serve fakeGmailFunc) *gwcli.CmdG {
+	t.Helper()
+	var m sync.Mutex
+	client := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			path := strings.TrimPrefix(req.URL.Path, "/upload")
+			path = strings.TrimPrefix(path, "/gmail/v1/users/me/")
+			status := http.StatusOK
+			var b []byte
+			switch body := serve(req, path).(type) {
+			case nil:
+				t.Fatalf("unexpected request: %s %s", req.Method, req.URL.String())
+			case string:
+				b = []byte(body)
+			case fakeError:
+				status = body.code
+				e := map[string]interface{}{"code": body.code, "message": body.message}
+				if body.reason != "" {
+					e["errors"] = []map[string]string{{"reason": body.reason, "message": body.message}}
+				}
+				b, _ = json.Marshal(map[string]interface{}{"error": e})
+			default:
+				b, _ = json.Marshal(body)
+			}
+			return &http.Response{
+				StatusCode: status,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader(b)),
+			}, nil
+		}),
+	}
+	conn, err := gwcli.NewFake(client)
+	if err != nil {
+		t.Fatalf("NewFake() error = %v", err)
+	}
+	return conn
+}
+
+// decodeRequest decodes the JSON body of req into v.
+func decodeRequest(t *testing.T, req *http.Request, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s request: %v", req.URL.Path, err)
+	}
+}
+
+// messageList is a messages.list response listing ids.
+func messageList(ids ...string) *gmail.ListMessagesResponse {
+	msgs := []*gmail.Message{}
+	for _, id := range ids {
+		msgs = append(msgs, &gmail.Message{Id: id})
+	}
+	return &gmail.ListMessagesResponse{Messages: msgs}
+}
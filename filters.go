@@ -24,6 +24,9 @@ type filtersCreateCmd struct {
 	Important     bool     `help:"Mark important (add IMPORTANT label)"`
 	Trash         bool     `help:"Move to trash (add TRASH label)"`
 	Forward       string   `help:"Forward to this email address"`
+	ApplyExisting bool     `help:"Also apply the filter to existing messages that match" name:"apply-existing"`
+
+	Existing forwardExistingFlags `embed:""`
 }
 
 // filterOutput represents a Gmail filter for JSON output, with label IDs
@@ -257,21 +260,50 @@ func runFiltersCreate(ctx context.Context, conn *gwcli.CmdG, c filtersCreateCmd,
 	if len(action.AddLabelIds) == 0 && len(action.RemoveLabelIds) == 0 && action.Forward == "" {
 		return fmt.Errorf("at least one action is required (--add-label, --remove-label, --archive, --mark-read, --star, --important, --trash, --forward)")
 	}
+	if c.Existing.ForwardExisting && !c.ApplyExisting {
+		return fmt.Errorf("--forward-existing requires --apply-existing")
+	}
+	if err := c.Existing.check(); err != nil {
+		return err
+	}
 
 	out.writeVerbose("Creating filter...")
-	created, err := svc.Users.Settings.Filters.Create("me", &gmail.Filter{
+	created, err := createFilter(ctx, svc, &gmail.Filter{
 		Criteria: criteria,
 		Action:   action,
-	}).Context(ctx).Do()
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create filter: %w", err)
+		return err
 	}
 
-	if out.json {
-		return out.writeJSON(toFilterOutput(created, idToName))
+	if !c.ApplyExisting {
+		if out.json {
+			return out.writeJSON(toFilterOutput(created, idToName))
+		}
+		out.writeMessage(fmt.Sprintf("Created filter %s", created.Id))
+		return nil
 	}
 
-	out.writeMessage(fmt.Sprintf("Created filter %s", created.Id))
+	applied, err := applyFilterToExisting(ctx, conn, created, c.Existing, out)
+	if err != nil {
+		return fmt.Errorf("created filter %s but failed to apply it to existing messages: %w", created.Id, err)
+	}
+	if out.json {
+		if err := out.writeJSON(map[string]interface{}{
+			"filter":  toFilterOutput(created, idToName),
+			"applied": applied,
+		}); err != nil {
+			return err
+		}
+	} else {
+		out.writeMessage(fmt.Sprintf("Created filter %s", created.Id))
+		if err := writeFilterRun(applied, out); err != nil {
+			return err
+		}
+	}
+	if len(applied.Failed) > 0 {
+		return fmt.Errorf("%d actions on %d matched messages failed", len(applied.Failed), applied.Matched)
+	}
 	return nil
 }
 
@@ -290,8 +322,8 @@ func runFiltersDelete(ctx context.Context, conn *gwcli.CmdG, filterID string, fo
 	}
 
 	out.writeVerbose("Deleting filter %s...", filterID)
-	if err := svc.Users.Settings.Filters.Delete("me", filterID).Context(ctx).Do(); err != nil {
-		return fmt.Errorf("failed to delete filter: %w", err)
+	if err := deleteFilter(ctx, svc, filterID); err != nil {
+		return err
 	}
 
 	if out.json {
@@ -302,6 +334,23 @@ func runFiltersDelete(ctx context.Context, conn *gwcli.CmdG, filterID string, fo
 	return nil
 }
 
+// createFilter creates a Gmail filter.
+func createFilter(ctx context.Context, svc *gmail.Service, f *gmail.Filter) (*gmail.Filter, error) {
+	created, err := svc.Users.Settings.Filters.Create("me", f).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filter: %w", err)
+	}
+	return created, nil
+}
+
+// deleteFilter deletes a Gmail filter.
+func deleteFilter(ctx context.Context, svc *gmail.Service, filterID string) error {
+	if err := svc.Users.Settings.Filters.Delete("me", filterID).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to delete filter: %w", err)
+	}
+	return nil
+}
+
 func dedupe(in []string) []string {
 	if len(in) == 0 {
 		return nil
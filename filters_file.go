@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// filterSpec is the account-independent form of a filter used by filters
+// export and import. Labels are referenced by name (or system label ID), so
+// a spec can be applied to another account.
+type filterSpec struct {
+	From           string   `json:"from,omitempty" yaml:"from,omitempty"`
+	To             string   `json:"to,omitempty" yaml:"to,omitempty"`
+	Subject        string   `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Query          string   `json:"query,omitempty" yaml:"query,omitempty"`
+	NegatedQuery   string   `json:"negatedQuery,omitempty" yaml:"negatedQuery,omitempty"`
+	HasAttachment  bool     `json:"hasAttachment,omitempty" yaml:"hasAttachment,omitempty"`
+	ExcludeChats   bool     `json:"excludeChats,omitempty" yaml:"excludeChats,omitempty"`
+	Size           int64    `json:"size,omitempty" yaml:"size,omitempty"`
+	SizeComparison string   `json:"sizeComparison,omitempty" yaml:"sizeComparison,omitempty"`
+	AddLabels      []string `json:"addLabels,omitempty" yaml:"addLabels,omitempty"`
+	RemoveLabels   []string `json:"removeLabels,omitempty" yaml:"removeLabels,omitempty"`
+	Forward        string   `json:"forward,omitempty" yaml:"forward,omitempty"`
+}
+
+// toFilterSpec converts a Gmail filter to a filterSpec, naming its labels.
+func toFilterSpec(f *gmail.Filter, idToName map[string]string) filterSpec {
+	var s filterSpec
+	if c := f.Criteria; c != nil {
+		s.From = c.From
+		s.To = c.To
+		s.Subject = c.Subject
+		s.Query = c.Query
+		s.NegatedQuery = c.NegatedQuery
+		s.HasAttachment = c.HasAttachment
+		s.ExcludeChats = c.ExcludeChats
+		s.Size = c.Size
+		s.SizeComparison = c.SizeComparison
+	}
+	if a := f.Action; a != nil {
+		s.AddLabels = namesFor(a.AddLabelIds, idToName)
+		s.RemoveLabels = namesFor(a.RemoveLabelIds, idToName)
+		s.Forward = a.Forward
+	}
+	return s
+}
+
+// describe summarizes the criteria of a filter for text output.
+func (s filterSpec) describe() string {
+	var parts []string
+	add := func(key, value string) {
+		if value != "" {
+			parts = append(parts, key+":"+value)
+		}
+	}
+	add("from", s.From)
+	add("to", s.To)
+	add("subject", s.Subject)
+	add("query", s.Query)
+	add("-query", s.NegatedQuery)
+	if s.HasAttachment {
+		parts = append(parts, "has:attachment")
+	}
+	if s.Size > 0 {
+		add("size", s.SizeComparison+" "+strconv.FormatInt(s.Size, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+// toGmailFilter resolves the labels of s to IDs and builds a Gmail filter.
+// Labels that do not exist are returned in missing. With create set they
+// are created; otherwise they are left in the filter as "missing:NAME"
+// placeholders, which match no existing filter.
+func (s filterSpec) toGmailFilter(ctx context.Context, conn *gwcli.CmdG, create bool, out *outputWriter) (f *gmail.Filter, missing []string, err error) {
+	criteria := &gmail.FilterCriteria{
+		From:           s.From,
+		To:             s.To,
+		Subject:        s.Subject,
+		Query:          s.Query,
+		NegatedQuery:   s.NegatedQuery,
+		HasAttachment:  s.HasAttachment,
+		ExcludeChats:   s.ExcludeChats,
+		Size:           s.Size,
+		SizeComparison: s.SizeComparison,
+	}
+	if s.describe() == "" && !s.ExcludeChats {
+		return nil, nil, fmt.Errorf("filter has no match criteria")
+	}
+
+	resolve := func(names []string) ([]string, error) {
+		var ids []string
+		for _, name := range names {
+			id, err := resolveLabelID(conn, name)
+			if err != nil {
+				if !create {
+					missing = append(missing, name)
+					id = "missing:" + strings.ToLower(name)
+				} else {
+					var newLabels []string
+					id, newLabels, err = ensureLabel(ctx, conn, name, out)
+					missing = append(missing, newLabels...)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			ids = append(ids, id)
+		}
+		return dedupe(ids), nil
+	}
+	action := &gmail.FilterAction{Forward: s.Forward}
+	if action.AddLabelIds, err = resolve(s.AddLabels); err != nil {
+		return nil, missing, err
+	}
+	if action.RemoveLabelIds, err = resolve(s.RemoveLabels); err != nil {
+		return nil, missing, err
+	}
+	if len(action.AddLabelIds) == 0 && len(action.RemoveLabelIds) == 0 && action.Forward == "" {
+		return nil, missing, fmt.Errorf("filter has no actions")
+	}
+	return &gmail.Filter{Criteria: criteria, Action: action}, missing, nil
+}
+
+// normalizeFilter returns a copy of f in a canonical form for comparison:
+// whitespace trimmed and collapsed, addresses lowercased, label IDs
+// deduplicated and sorted. Queries keep their case because Gmail search
+// operators such as OR are case-sensitive.
+func normalizeFilter(f *gmail.Filter) *gmail.Filter {
+	var c gmail.FilterCriteria
+	if f.Criteria != nil {
+		c = *f.Criteria
+	}
+	var a gmail.FilterAction
+	if f.Action != nil {
+		a = *f.Action
+	}
+	clean := func(s string) string { return strings.Join(strings.Fields(s), " ") }
+	c.From = strings.ToLower(clean(c.From))
+	c.To = strings.ToLower(clean(c.To))
+	c.Subject = clean(c.Subject)
+	c.Query = clean(c.Query)
+	c.NegatedQuery = clean(c.NegatedQuery)
+	if c.Size == 0 {
+		c.SizeComparison = ""
+	} else if c.SizeComparison == "" {
+		c.SizeComparison = "larger"
+	}
+	sorted := func(ids []string) []string {
+		ids = dedupe(ids)
+		sort.Strings(ids)
+		return ids
+	}
+	a.AddLabelIds = sorted(a.AddLabelIds)
+	a.RemoveLabelIds = sorted(a.RemoveLabelIds)
+	a.Forward = strings.ToLower(strings.TrimSpace(a.Forward))
+	return &gmail.Filter{Id: f.Id, Criteria: &c, Action: &a}
+}
+
+// filterKey identifies a filter by its normalized criteria and actions,
+// ignoring its ID.
+func filterKey(f *gmail.Filter) string {
+	n := normalizeFilter(f)
+	c, a := n.Criteria, n.Action
+	key, _ := json.Marshal([]interface{}{
+		c.From, c.To, c.Subject, c.Query, c.NegatedQuery,
+		c.HasAttachment, c.ExcludeChats, c.Size, c.SizeComparison,
+		a.AddLabelIds, a.RemoveLabelIds, a.Forward,
+	})
+	return string(key)
+}
+
+const (
+	atomNamespace = "http://www.w3.org/2005/Atom"
+	appsNamespace = "http://schemas.google.com/apps/2006"
+)
+
+// mailFilters is the Atom feed of the Gmail web UI's filter export
+// (mailFilters.xml).
+type mailFilters struct {
+	XMLName   xml.Name           `xml:"feed"`
+	Xmlns     string             `xml:"xmlns,attr"`
+	XmlnsApps string             `xml:"xmlns:apps,attr"`
+	Title     string             `xml:"title"`
+	ID        string             `xml:"id"`
+	Updated   string             `xml:"updated"`
+	Author    *mailFiltersAuthor `xml:"author,omitempty"`
+	Entries   []mailFilterEntry  `xml:"entry"`
+}
+
+type mailFiltersAuthor struct {
+	Name  string `xml:"name,omitempty"`
+	Email string `xml:"email"`
+}
+
+type mailFilterEntry struct {
+	Category struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Title      string               `xml:"title"`
+	ID         string               `xml:"id"`
+	Updated    string               `xml:"updated"`
+	Content    string               `xml:"content"`
+	Properties []mailFilterProperty `xml:"apps:property"`
+}
+
+type mailFilterProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// mailFiltersIn decodes mailFilters.xml. Properties are matched by local
+// name because the decoder resolves the apps: prefix to its namespace.
+type mailFiltersIn struct {
+	Entries []struct {
+		Properties []mailFilterProperty `xml:"property"`
+	} `xml:"entry"`
+}
+
+// categoryLabels maps category label IDs to mailFilters.xml smart labels.
+var categoryLabels = map[string]string{
+	"CATEGORY_PERSONAL":   "^smartlabel_personal",
+	"CATEGORY_SOCIAL":     "^smartlabel_social",
+	"CATEGORY_PROMOTIONS": "^smartlabel_promo",
+	"CATEGORY_UPDATES":    "^smartlabel_notification",
+	"CATEGORY_FORUMS":     "^smartlabel_group",
+}
+
+// sizeUnits are the mailFilters.xml size units, largest first.
+var sizeUnits = []struct {
+	unit  string
+	bytes int64
+}{
+	{"s_smb", 1 << 20},
+	{"s_skb", 1 << 10},
+	{"s_sb", 1},
+}
+
+// filterXMLProperties converts a Gmail filter to mailFilters.xml
+// properties. Label removals the format cannot express are returned as
+// warnings.
+func filterXMLProperties(f *gmail.Filter, idToName map[string]string) ([]mailFilterProperty, []string) {
+	var props []mailFilterProperty
+	var warnings []string
+	add := func(name, value string) {
+		if value != "" {
+			props = append(props, mailFilterProperty{Name: name, Value: value})
+		}
+	}
+	if c := f.Criteria; c != nil {
+		add("from", c.From)
+		add("to", c.To)
+		add("subject", c.Subject)
+		add("hasTheWord", c.Query)
+		add("doesNotHaveTheWord", c.NegatedQuery)
+		if c.HasAttachment {
+			add("hasAttachment", "true")
+		}
+		if c.ExcludeChats {
+			add("excludeChats", "true")
+		}
+		if c.Size > 0 {
+			for _, u := range sizeUnits {
+				if c.Size%u.bytes == 0 {
+					add("size", strconv.FormatInt(c.Size/u.bytes, 10))
+					add("sizeUnit", u.unit)
+					break
+				}
+			}
+			op := "s_sl"
+			if c.SizeComparison == "smaller" {
+				op = "s_ss"
+			}
+			add("sizeOperator", op)
+		}
+	}
+	if a := f.Action; a != nil {
+		for _, id := range a.AddLabelIds {
+			switch id {
+			case "STARRED":
+				add("shouldStar", "true")
+			case "IMPORTANT":
+				add("shouldAlwaysMarkAsImportant", "true")
+			case "TRASH":
+				add("shouldTrash", "true")
+			default:
+				if smart, ok := categoryLabels[id]; ok {
+					add("smartLabelToApply", smart)
+				} else {
+					add("label", namesFor([]string{id}, idToName)[0])
+				}
+			}
+		}
+		for _, id := range a.RemoveLabelIds {
+			switch id {
+			case "INBOX":
+				add("shouldArchive", "true")
+			case "UNREAD":
+				add("shouldMarkAsRead", "true")
+			case "IMPORTANT":
+				add("shouldNeverMarkAsImportant", "true")
+			case "SPAM":
+				add("shouldNeverSpam", "true")
+			default:
+				warnings = append(warnings, fmt.Sprintf("filter %s removes label %s, which mailFilters.xml cannot express; skipped", f.Id, namesFor([]string{id}, idToName)[0]))
+			}
+		}
+		add("forwardTo", a.Forward)
+	}
+	return props, warnings
+}
+
+// specFromXMLProperties converts mailFilters.xml properties to a
+// filterSpec. Unknown properties are returned as warnings.
+func specFromXMLProperties(props []mailFilterProperty) (filterSpec, []string) {
+	var s filterSpec
+	var warnings []string
+	var size int64
+	unit := int64(1)
+	for _, p := range props {
+		switch p.Name {
+		case "from":
+			s.From = p.Value
+		case "to":
+			s.To = p.Value
+		case "subject":
+			s.Subject = p.Value
+		case "hasTheWord":
+			s.Query = p.Value
+		case "doesNotHaveTheWord":
+			s.NegatedQuery = p.Value
+		case "hasAttachment":
+			s.HasAttachment = p.Value == "true"
+		case "excludeChats":
+			s.ExcludeChats = p.Value == "true"
+		case "size":
+			n, err := strconv.ParseInt(p.Value, 10, 64)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("invalid size %q ignored", p.Value))
+			}
+			size = n
+		case "sizeUnit":
+			for _, u := range sizeUnits {
+				if u.unit == p.Value {
+					unit = u.bytes
+				}
+			}
+		case "sizeOperator":
+			s.SizeComparison = "larger"
+			if p.Value == "s_ss" {
+				s.SizeComparison = "smaller"
+			}
+		case "label":
+			s.AddLabels = append(s.AddLabels, p.Value)
+		case "shouldStar":
+			s.AddLabels = append(s.AddLabels, "STARRED")
+		case "shouldAlwaysMarkAsImportant":
+			s.AddLabels = append(s.AddLabels, "IMPORTANT")
+		case "shouldTrash":
+			s.AddLabels = append(s.AddLabels, "TRASH")
+		case "smartLabelToApply":
+			found := false
+			for id, smart := range categoryLabels {
+				if smart == p.Value {
+					s.AddLabels = append(s.AddLabels, id)
+					found = true
+				}
+			}
+			if !found {
+				warnings = append(warnings, fmt.Sprintf("unknown smart label %q ignored", p.Value))
+			}
+		case "shouldArchive":
+			s.RemoveLabels = append(s.RemoveLabels, "INBOX")
+		case "shouldMarkAsRead":
+			s.RemoveLabels = append(s.RemoveLabels, "UNREAD")
+		case "shouldNeverMarkAsImportant":
+			s.RemoveLabels = append(s.RemoveLabels, "IMPORTANT")
+		case "shouldNeverSpam":
+			s.RemoveLabels = append(s.RemoveLabels, "SPAM")
+		case "forwardTo":
+			s.Forward = p.Value
+		default:
+			warnings = append(warnings, fmt.Sprintf("unsupported property %s=%q ignored", p.Name, p.Value))
+		}
+	}
+	if size > 0 {
+		s.Size = size * unit
+		if s.SizeComparison == "" {
+			s.SizeComparison = "larger"
+		}
+	}
+	return s, warnings
+}
+
+// marshalMailFilters renders filters as mailFilters.xml.
+func marshalMailFilters(filters []*gmail.Filter, idToName map[string]string, author string) ([]byte, []string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	feed := mailFilters{
+		Xmlns:     atomNamespace,
+		XmlnsApps: appsNamespace,
+		Title:     "Mail Filters",
+		ID:        "tag:mail.google.com,2008:filters:" + strings.Join(filterIDs(filters), ","),
+		Updated:   now,
+	}
+	if author != "" {
+		feed.Author = &mailFiltersAuthor{Email: author}
+	}
+	var warnings []string
+	for _, f := range filters {
+		props, w := filterXMLProperties(f, idToName)
+		warnings = append(warnings, w...)
+		e := mailFilterEntry{
+			Title:      "Mail Filter",
+			ID:         "tag:mail.google.com,2008:filter:" + f.Id,
+			Updated:    now,
+			Properties: props,
+		}
+		e.Category.Term = "filter"
+		feed.Entries = append(feed.Entries, e)
+	}
+	data, _ := xml.MarshalIndent(feed, "", "\t")
+	return append([]byte(xml.Header), append(data, '\n')...), warnings
+}
+
+func filterIDs(filters []*gmail.Filter) []string {
+	ids := make([]string, len(filters))
+	for i, f := range filters {
+		ids[i] = f.Id
+	}
+	return ids
+}
+
+// parseFilterFile reads filter specs from mailFilters.xml, JSON or YAML.
+// The format is taken from the file extension, or sniffed from the content
+// for other names.
+func parseFilterFile(path string) ([]filterSpec, []string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	if format != "xml" && format != "json" && format != "yaml" {
+		switch trimmed := bytes.TrimSpace(data); {
+		case bytes.HasPrefix(trimmed, []byte("<")):
+			format = "xml"
+		case bytes.HasPrefix(trimmed, []byte("[")):
+			format = "json"
+		default:
+			format = "yaml"
+		}
+	}
+
+	var specs []filterSpec
+	var warnings []string
+	switch format {
+	case "xml":
+		var feed mailFiltersIn
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s as mailFilters.xml: %w", path, err)
+		}
+		for i, e := range feed.Entries {
+			s, w := specFromXMLProperties(e.Properties)
+			for _, msg := range w {
+				warnings = append(warnings, fmt.Sprintf("filter %d: %s", i+1, msg))
+			}
+			specs = append(specs, s)
+		}
+	case "json":
+		if err := json.Unmarshal(data, &specs); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s as JSON: %w", path, err)
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(data, &specs); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s as YAML: %w", path, err)
+		}
+	}
+	return specs, warnings, nil
+}
+
+// listFilters fetches all filters and the label ID to name map.
+func listFilters(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) ([]*gmail.Filter, map[string]string, error) {
+	svc := conn.GmailService()
+	if svc == nil {
+		return nil, nil, fmt.Errorf("gmail service not initialized")
+	}
+	idToName, err := labelIDToName(ctx, conn, out)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := svc.Users.Settings.Filters.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list filters: %w", err)
+	}
+	return resp.Filter, idToName, nil
+}
+
+// runFiltersExport writes all filters as mailFilters.xml, JSON or YAML.
+func runFiltersExport(ctx context.Context, conn *gwcli.CmdG, format, outPath string, out *outputWriter) error {
+	out.writeVerbose("Fetching filters...")
+	filters, idToName, err := listFilters(ctx, conn, out)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch format {
+	case "xml":
+		author := ""
+		if profile, err := conn.GetProfile(ctx); err == nil {
+			author = profile.EmailAddress
+		}
+		var warnings []string
+		data, warnings = marshalMailFilters(filters, idToName, author)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	case "json", "yaml":
+		specs := make([]filterSpec, len(filters))
+		for i, f := range filters {
+			specs[i] = toFilterSpec(f, idToName)
+		}
+		if format == "json" {
+			data, err = json.MarshalIndent(specs, "", "  ")
+			data = append(data, '\n')
+		} else {
+			data, err = yaml.Marshal(specs)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode filters: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported format %q (use xml, json or yaml)", format)
+	}
+
+	if outPath == "" || outPath == "-" {
+		_, err := out.writer.Write(data)
+		return err
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outPath, err)
+	}
+	out.writeMessage(fmt.Sprintf("Exported %d filters to %s", len(filters), outPath))
+	return nil
+}
+
+// filterImportResult is the outcome of importing one filter.
+type filterImportResult struct {
+	Status string     `json:"status"` // created, exists or failed
+	ID     string     `json:"id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	Filter filterSpec `json:"filter"`
+}
+
+// filterImportOutput is JSON output for filters import.
+type filterImportOutput struct {
+	Filters       []filterImportResult `json:"filters"`
+	LabelsCreated []string             `json:"labelsCreated"`
+}
+
+// runFiltersImport creates the filters in a mailFilters.xml, JSON or YAML
+// file, creating missing labels. Filters that already exist are skipped.
+func runFiltersImport(ctx context.Context, conn *gwcli.CmdG, path string, out *outputWriter) error {
+	specs, warnings, err := parseFilterFile(path)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("no filters found in %s", path)
+	}
+
+	existing, _, err := listFilters(ctx, conn, out)
+	if err != nil {
+		return err
+	}
+	exists := map[string]string{}
+	for _, f := range existing {
+		exists[filterKey(f)] = f.Id
+	}
+
+	svc := conn.GmailService()
+	res := filterImportOutput{Filters: []filterImportResult{}, LabelsCreated: []string{}}
+	failed := 0
+	for _, spec := range specs {
+		r := filterImportResult{Filter: spec}
+		f, created, err := spec.toGmailFilter(ctx, conn, true, out)
+		res.LabelsCreated = append(res.LabelsCreated, created...)
+		if err == nil {
+			if id, ok := exists[filterKey(f)]; ok {
+				r.Status, r.ID = "exists", id
+			} else {
+				out.writeVerbose("Creating filter %s...", spec.describe())
+				f, err = createFilter(ctx, svc, f)
+				if err == nil {
+					r.Status, r.ID = "created", f.Id
+					exists[filterKey(f)] = f.Id
+				}
+			}
+		}
+		if err != nil {
+			r.Status, r.Error = "failed", err.Error()
+			failed++
+		}
+		res.Filters = append(res.Filters, r)
+	}
+
+	if out.json {
+		if err := out.writeJSON(res); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range res.LabelsCreated {
+			out.writeMessage(fmt.Sprintf("Created label %s", name))
+		}
+		headers := []string{"STATUS", "ID", "CRITERIA", "ERROR"}
+		rows := make([][]string, len(res.Filters))
+		for i, r := range res.Filters {
+			rows[i] = []string{r.Status, r.ID, r.Filter.describe(), r.Error}
+		}
+		if err := out.writeTable(headers, rows); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d filters failed to import", failed, len(specs))
+	}
+	return nil
+}
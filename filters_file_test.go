@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// fakeFilterAPI is an in-memory Gmail filters API, with the labels the
+// filters refer to. It records created and deleted filters.
+type fakeFilterAPI struct {
+	t       *testing.T
+	labels  []*gmail.Label
+	filters []*gmail.Filter
+	created []*gmail.Filter
+	deleted []string
+}
+
+func newFakeFilterAPI(t *testing.T, filters []*gmail.Filter, labels ...string) *fakeFilterAPI {
+	f := &fakeFilterAPI{t: t, filters: filters}
+	for _, name := range labels {
+		f.addLabel(name)
+	}
+	return f
+}
+
+func (f *fakeFilterAPI) addLabel(name string) *gmail.Label {
+	l := &gmail.Label{Id: fmt.Sprintf("Label_%d", len(f.labels)+1), Name: name, Type: "user"}
+	f.labels = append(f.labels, l)
+	return l
+}
+
+func (f *fakeFilterAPI) labelNames() []string {
+	var ret []string
+	for _, l := range f.labels {
+		ret = append(ret, l.Name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (f *fakeFilterAPI) conn() *gwcli.CmdG {
+	return newFakeGmail(f.t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "labels" && req.Method == http.MethodGet:
+			return gmail.ListLabelsResponse{Labels: f.labels}
+		case path == "labels" && req.Method == http.MethodPost:
+			var l gmail.Label
+			decodeRequest(f.t, req, &l)
+			return f.addLabel(l.Name)
+		case path == "settings/filters" && req.Method == http.MethodGet:
+			return gmail.ListFiltersResponse{Filter: f.filters}
+		case path == "settings/filters" && req.Method == http.MethodPost:
+			var filter gmail.Filter
+			decodeRequest(f.t, req, &filter)
+			filter.Id = "new"
+			f.created = append(f.created, &filter)
+			return filter
+		case strings.HasPrefix(path, "settings/filters/") && req.Method == http.MethodDelete:
+			f.deleted = append(f.deleted, strings.TrimPrefix(path, "settings/filters/"))
+			return "{}"
+		}
+		return nil
+	})
+}
+
+// gmailExport is a filter export as written by the Gmail web UI.
+const gmailExport = `<?xml version='1.0' encoding='UTF-8'?><feed xmlns='http://www.w3.org/2005/Atom' xmlns:apps='http://schemas.google.com/apps/2006'>
+	<title>Mail Filters</title>
+	<id>tag:mail.google.com,2008:filters:z0000001</id>
+	<updated>2026-01-01T00:00:00Z</updated>
+	<author>
+		<name>Me</name>
+		<email>me@example.com</email>
+	</author>
+	<entry>
+		<category term='filter'></category>
+		<title>Mail Filter</title>
+		<id>tag:mail.google.com,2008:filter:z0000001</id>
+		<updated>2026-01-01T00:00:00Z</updated>
+		<content></content>
+		<apps:property name='from' value='billing@example.com'/>
+		<apps:property name='label' value='Receipts/2026'/>
+		<apps:property name='shouldArchive' value='true'/>
+		<apps:property name='shouldMarkAsRead' value='true'/>
+		<apps:property name='smartLabelToApply' value='^smartlabel_notification'/>
+		<apps:property name='sizeOperator' value='s_sl'/>
+		<apps:property name='sizeUnit' value='s_smb'/>
+		<apps:property name='size' value='5'/>
+	</entry>
+</feed>
+`
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseFilterFile_GmailExport(t *testing.T) {
+	// No extension: the format is sniffed from the content.
+	specs, warnings, err := parseFilterFile(writeTempFile(t, "filters", gmailExport))
+	if err != nil {
+		t.Fatalf("parseFilterFile() error = %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v", warnings)
+	}
+	want := []filterSpec{{
+		From:           "billing@example.com",
+		Size:           5 << 20,
+		SizeComparison: "larger",
+		AddLabels:      []string{"Receipts/2026", "CATEGORY_UPDATES"},
+		RemoveLabels:   []string{"INBOX", "UNREAD"},
+	}}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("specs = %+v, want %+v", specs, want)
+	}
+}
+
+func TestMarshalMailFilters_RoundTrip(t *testing.T) {
+	filters := []*gmail.Filter{{
+		Id:       "f1",
+		Criteria: &gmail.FilterCriteria{Query: "list:ci.example.com", Size: 1500, SizeComparison: "smaller"},
+		Action: &gmail.FilterAction{
+			AddLabelIds:    []string{"Label_1", "STARRED"},
+			RemoveLabelIds: []string{"INBOX", "Label_2"},
+			Forward:        "archive@example.com",
+		},
+	}}
+	idToName := map[string]string{"Label_1": "CI", "Label_2": "Todo"}
+
+	data, warnings := marshalMailFilters(filters, idToName, "me@example.com")
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Todo") {
+		t.Errorf("warnings = %v, want one about removing Todo", warnings)
+	}
+	if !bytes.Contains(data, []byte(`<apps:property name="hasTheWord" value="list:ci.example.com"></apps:property>`)) {
+		t.Errorf("unexpected XML:\n%s", data)
+	}
+
+	specs, _, err := parseFilterFile(writeTempFile(t, "mailFilters.xml", string(data)))
+	if err != nil {
+		t.Fatalf("parseFilterFile() error = %v", err)
+	}
+	want := []filterSpec{{
+		Query:          "list:ci.example.com",
+		Size:           1500,
+		SizeComparison: "smaller",
+		AddLabels:      []string{"CI", "STARRED"},
+		RemoveLabels:   []string{"INBOX"},
+		Forward:        "archive@example.com",
+	}}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("specs = %+v, want %+v", specs, want)
+	}
+}
+
+func TestRunFiltersImport(t *testing.T) {
+	existing := &gmail.Filter{
+		Id:       "existing",
+		Criteria: &gmail.FilterCriteria{From: "shop@example.com"},
+		Action:   &gmail.FilterAction{AddLabelIds: []string{"Label_1"}, RemoveLabelIds: []string{"INBOX"}},
+	}
+	api := newFakeFilterAPI(t, []*gmail.Filter{existing}, "Receipts")
+	path := writeTempFile(t, "filters.yaml", `
+- from: shop@example.com
+  addLabels: [receipts]
+  removeLabels: [INBOX]
+- subject: "[CI]"
+  addLabels: [Builds/Nightly]
+  removeLabels: [UNREAD]
+`)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runFiltersImport(context.Background(), api.conn(), path, out); err != nil {
+		t.Fatalf("runFiltersImport() error = %v", err)
+	}
+	var got filterImportOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got.Filters) != 2 || got.Filters[0].Status != "exists" || got.Filters[1].Status != "created" {
+		t.Fatalf("filters = %+v", got.Filters)
+	}
+	if strings.Join(got.LabelsCreated, ",") != "Builds,Builds/Nightly" {
+		t.Errorf("labelsCreated = %v", got.LabelsCreated)
+	}
+	if len(api.created) != 1 || api.created[0].Criteria.Subject != "[CI]" || api.created[0].Action.AddLabelIds[0] != "Label_3" {
+		t.Errorf("created = %+v", api.created)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// filtersTestCmd holds the flags for `gwcli filters test`.
+type filtersTestCmd struct {
+	FilterID      string `arg:"" optional:"" name:"filter-id" help:"ID of the filter to test (or give criteria flags)"`
+	From          string `help:"Match sender (display name or email)"`
+	To            string `help:"Match recipient (to/cc/bcc)"`
+	Subject       string `help:"Match subject phrase"`
+	Query         string `help:"Match raw Gmail search query"`
+	HasAttachment bool   `help:"Match only messages with attachments" name:"has-attachment"`
+	Limit         int    `help:"Max messages to list (0 = all)" default:"50"`
+	PageToken     string `help:"Resume from a previous page token" name:"page-token"`
+}
+
+// forwardExistingFlags control whether applying a filter to existing mail
+// also forwards it. Forwarding re-sends every match, so it is opt-in and
+// capped like other bulk commands.
+type forwardExistingFlags struct {
+	ForwardExisting bool `help:"Also forward existing matches to the filter's forwarding address" name:"forward-existing"`
+	Force           bool `help:"Confirm forwarding existing messages (required with --forward-existing)"`
+	Max             int  `help:"With --forward-existing, refuse to forward more than this many messages (0 = no limit)" default:"100"`
+}
+
+// check returns an error unless forwarding is off or confirmed.
+func (f forwardExistingFlags) check() error {
+	if f.ForwardExisting && !f.Force {
+		return fmt.Errorf("--force is required to forward existing messages")
+	}
+	return nil
+}
+
+// filtersRunCmd holds the flags for `gwcli filters run`.
+type filtersRunCmd struct {
+	FilterID string               `arg:"" name:"filter-id" help:"ID of the filter to run"`
+	Forward  forwardExistingFlags `embed:""`
+}
+
+// criteriaQuery turns filter criteria into the equivalent Gmail search
+// query. Like a filter, the search skips spam and trash.
+func criteriaQuery(c *gmail.FilterCriteria) string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	if c.From != "" {
+		parts = append(parts, "from:("+c.From+")")
+	}
+	if c.To != "" {
+		parts = append(parts, "to:("+c.To+")")
+	}
+	if c.Subject != "" {
+		parts = append(parts, "subject:("+c.Subject+")")
+	}
+	if c.Query != "" {
+		parts = append(parts, "("+c.Query+")")
+	}
+	if c.NegatedQuery != "" {
+		parts = append(parts, "-("+c.NegatedQuery+")")
+	}
+	if c.HasAttachment {
+		parts = append(parts, "has:attachment")
+	}
+	if c.ExcludeChats {
+		parts = append(parts, "-in:chats")
+	}
+	if c.Size > 0 {
+		op := "larger"
+		if c.SizeComparison == "smaller" {
+			op = "smaller"
+		}
+		parts = append(parts, op+":"+strconv.FormatInt(c.Size, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+// getFilter fetches a filter by ID.
+func getFilter(ctx context.Context, conn *gwcli.CmdG, filterID string) (*gmail.Filter, error) {
+	svc := conn.GmailService()
+	if svc == nil {
+		return nil, fmt.Errorf("gmail service not initialized")
+	}
+	f, err := svc.Users.Settings.Filters.Get("me", filterID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filter: %w", err)
+	}
+	return f, nil
+}
+
+// runFiltersTest lists the existing messages a filter (by ID or criteria
+// flags) would match.
+func runFiltersTest(ctx context.Context, conn *gwcli.CmdG, c filtersTestCmd, out *outputWriter) error {
+	criteria := &gmail.FilterCriteria{
+		From:          c.From,
+		To:            c.To,
+		Subject:       c.Subject,
+		Query:         c.Query,
+		HasAttachment: c.HasAttachment,
+	}
+	hasFlags := criteriaQuery(criteria) != ""
+	switch {
+	case c.FilterID != "" && hasFlags:
+		return fmt.Errorf("give either a filter ID or criteria flags, not both")
+	case c.FilterID != "":
+		f, err := getFilter(ctx, conn, c.FilterID)
+		if err != nil {
+			return err
+		}
+		criteria = f.Criteria
+	case !hasFlags:
+		return fmt.Errorf("a filter ID or at least one criterion (--from, --to, --subject, --query, --has-attachment) is required")
+	}
+
+	query := criteriaQuery(criteria)
+	if query == "" {
+		return fmt.Errorf("filter %s has no criteria to search for", c.FilterID)
+	}
+	return runMessagesSearch(ctx, conn, query, c.Limit, c.PageToken, out)
+}
+
+// filterRunOutput is JSON output for filters run.
+type filterRunOutput struct {
+	FilterID  string         `json:"filterId"`
+	Query     string         `json:"query"`
+	Matched   int            `json:"matched"`
+	Modified  int            `json:"modified"`
+	Forwarded int            `json:"forwarded"`
+	Failed    []batchFailure `json:"failed"`
+}
+
+// applyFilterToExisting applies a filter's label actions to the existing
+// messages matching its criteria, and its forward action if fwd asks to.
+func applyFilterToExisting(ctx context.Context, conn *gwcli.CmdG, f *gmail.Filter, fwd forwardExistingFlags, out *outputWriter) (*filterRunOutput, error) {
+	if err := fwd.check(); err != nil {
+		return nil, err
+	}
+	res := &filterRunOutput{FilterID: f.Id, Query: criteriaQuery(f.Criteria), Failed: []batchFailure{}}
+	if res.Query == "" {
+		return nil, fmt.Errorf("filter %s has no criteria to search for", f.Id)
+	}
+	action := f.Action
+	if action == nil {
+		action = &gmail.FilterAction{}
+	}
+
+	forward := action.Forward != "" && fwd.ForwardExisting
+	if action.Forward != "" && !forward {
+		fmt.Fprintf(os.Stderr, "Warning: existing messages are not forwarded to %s; add --forward-existing --force to forward them\n", action.Forward)
+	}
+	limit := 0
+	if forward {
+		limit = fwd.Max
+	}
+
+	out.writeVerbose("Searching with query: %s", res.Query)
+	ids, more, err := conn.ListMessageIDsN(ctx, "", res.Query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	if more {
+		return nil, fmt.Errorf("filter matches more than --max %d messages to forward; raise --max or forward fewer", fwd.Max)
+	}
+	res.Matched = len(ids)
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	if len(action.AddLabelIds) > 0 || len(action.RemoveLabelIds) > 0 {
+		out.writeVerbose("Modifying labels of %d messages...", len(ids))
+		bp := newBatchProcessor(len(ids), out.verbose)
+		bp.modify(ctx, conn, ids, action.AddLabelIds, action.RemoveLabelIds)
+		res.Modified = bp.succeeded()
+		res.Failed = append(res.Failed, bp.failed...)
+	}
+
+	if forward {
+		for _, id := range ids {
+			// Forwarded as a Gmail filter would: unchanged, without a
+			// signature.
+			if _, _, err := forwardMessage(ctx, conn, id, []string{action.Forward}, nil, nil, "", nil, senderOptions{}, out); err != nil {
+				res.Failed = append(res.Failed, batchFailure{ID: id, Error: err.Error()})
+				continue
+			}
+			res.Forwarded++
+		}
+	}
+	return res, nil
+}
+
+// writeFilterRun prints the result of applying a filter to existing mail.
+func writeFilterRun(res *filterRunOutput, out *outputWriter) error {
+	if out.json {
+		return out.writeJSON(res)
+	}
+	msg := fmt.Sprintf("Filter %s matched %d existing messages; relabeled %d", res.FilterID, res.Matched, res.Modified)
+	if res.Forwarded > 0 || len(res.Failed) > 0 {
+		msg += fmt.Sprintf(", forwarded %d", res.Forwarded)
+	}
+	out.writeMessage(msg)
+	for _, f := range res.Failed {
+		out.writeMessage(fmt.Sprintf("  failed: %s: %s", f.ID, f.Error))
+	}
+	return nil
+}
+
+// runFiltersRun applies an existing filter to the messages already in the
+// mailbox, which Gmail itself only does for new mail.
+func runFiltersRun(ctx context.Context, conn *gwcli.CmdG, c filtersRunCmd, out *outputWriter) error {
+	if c.FilterID == "" {
+		return fmt.Errorf("filter ID is required")
+	}
+	if err := c.Forward.check(); err != nil {
+		return err
+	}
+	f, err := getFilter(ctx, conn, c.FilterID)
+	if err != nil {
+		return err
+	}
+	res, err := applyFilterToExisting(ctx, conn, f, c.Forward, out)
+	if err != nil {
+		return err
+	}
+	if err := writeFilterRun(res, out); err != nil {
+		return err
+	}
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("%d actions on %d matched messages failed", len(res.Failed), res.Matched)
+	}
+	return nil
+}
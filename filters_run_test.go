@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// fakeFilterRunAPI serves one filter and the messages matching a search
+// query, and records batchModify calls and sent messages.
+type fakeFilterRunAPI struct {
+	t        *testing.T
+	filter   *gmail.Filter
+	matches  map[string][]string // search query -> message IDs
+	modified []gmail.BatchModifyMessagesRequest
+	sent     int
+}
+
+func (f *fakeFilterRunAPI) conn() *gwcli.CmdG {
+	return newFakeGmail(f.t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "settings/filters/"+f.filter.Id && req.Method == http.MethodGet:
+			return f.filter
+		case path == "messages" && req.Method == http.MethodGet:
+			return messageList(f.matches[req.URL.Query().Get("q")]...)
+		case path == "messages/batchModify":
+			var r gmail.BatchModifyMessagesRequest
+			decodeRequest(f.t, req, &r)
+			f.modified = append(f.modified, r)
+			return "{}"
+		case path == "messages/send":
+			f.sent++
+			return &gmail.Message{Id: fmt.Sprintf("S%d", f.sent)}
+		case strings.HasPrefix(path, "messages/") && req.Method == http.MethodGet:
+			return &gmail.Message{Id: strings.TrimPrefix(path, "messages/"), Payload: &gmail.MessagePart{
+				MimeType: "text/plain",
+				Headers: []*gmail.MessagePartHeader{
+					{Name: "From", Value: "shop@example.com"},
+					{Name: "Subject", Value: "Receipt"},
+				},
+				Body: &gmail.MessagePartBody{Data: "UmVjZWlwdA=="},
+			}}
+		}
+		return nil
+	})
+}
+
+func TestCriteriaQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria *gmail.FilterCriteria
+		want     string
+	}{
+		{"nil", nil, ""},
+		{"from", &gmail.FilterCriteria{From: "a@example.com OR b@example.com"}, "from:(a@example.com OR b@example.com)"},
+		{
+			"all",
+			&gmail.FilterCriteria{
+				To: "team@example.com", Subject: "weekly report", Query: "invoice OR receipt",
+				NegatedQuery: "draft", HasAttachment: true, Size: 1048576, SizeComparison: "smaller",
+			},
+			"to:(team@example.com) subject:(weekly report) (invoice OR receipt) -(draft) has:attachment smaller:1048576",
+		},
+		{"size defaults to larger", &gmail.FilterCriteria{Size: 10}, "larger:10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := criteriaQuery(tt.criteria); got != tt.want {
+				t.Errorf("criteriaQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFiltersRun(t *testing.T) {
+	api := &fakeFilterRunAPI{
+		t: t,
+		filter: &gmail.Filter{
+			Id:       "f1",
+			Criteria: &gmail.FilterCriteria{From: "shop@example.com"},
+			Action:   &gmail.FilterAction{AddLabelIds: []string{"Label_1"}, RemoveLabelIds: []string{"INBOX"}},
+		},
+		matches: map[string][]string{"from:(shop@example.com)": {"m1", "m2"}},
+	}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runFiltersRun(context.Background(), api.conn(), filtersRunCmd{FilterID: "f1"}, out); err != nil {
+		t.Fatalf("runFiltersRun() error = %v", err)
+	}
+	if len(api.modified) != 1 {
+		t.Fatalf("expected 1 batchModify call, got %d", len(api.modified))
+	}
+	m := api.modified[0]
+	if strings.Join(m.Ids, ",") != "m1,m2" || m.AddLabelIds[0] != "Label_1" || m.RemoveLabelIds[0] != "INBOX" {
+		t.Errorf("batchModify = %+v", m)
+	}
+	var got filterRunOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if got.Matched != 2 || got.Modified != 2 || got.Query != "from:(shop@example.com)" {
+		t.Errorf("output = %+v", got)
+	}
+}
+
+func forwardingFilterAPI(t *testing.T) *fakeFilterRunAPI {
+	return &fakeFilterRunAPI{
+		t: t,
+		filter: &gmail.Filter{
+			Id:       "f1",
+			Criteria: &gmail.FilterCriteria{From: "shop@example.com"},
+			Action:   &gmail.FilterAction{Forward: "archive@example.com"},
+		},
+		matches: map[string][]string{"from:(shop@example.com)": {"m1", "m2", "m3"}},
+	}
+}
+
+func TestRunFiltersRun_ForwardIsOptIn(t *testing.T) {
+	api := forwardingFilterAPI(t)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runFiltersRun(context.Background(), api.conn(), filtersRunCmd{FilterID: "f1"}, out); err != nil {
+		t.Fatalf("runFiltersRun() error = %v", err)
+	}
+	if api.sent != 0 {
+		t.Errorf("forwarded %d messages without --forward-existing", api.sent)
+	}
+
+	c := filtersRunCmd{FilterID: "f1", Forward: forwardExistingFlags{ForwardExisting: true, Max: 100}}
+	if err := runFiltersRun(context.Background(), api.conn(), c, out); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("without --force: error = %v", err)
+	}
+
+	c.Forward.Max = 2
+	c.Forward.Force = true
+	if err := runFiltersRun(context.Background(), api.conn(), c, out); err == nil || !strings.Contains(err.Error(), "more than --max 2") {
+		t.Errorf("over --max: error = %v", err)
+	}
+	if api.sent != 0 {
+		t.Errorf("forwarded %d messages despite the errors", api.sent)
+	}
+
+	c.Forward.Max = 100
+	if err := runFiltersRun(context.Background(), api.conn(), c, out); err != nil {
+		t.Fatalf("runFiltersRun() error = %v", err)
+	}
+	if api.sent != 3 {
+		t.Errorf("forwarded %d messages, want 3", api.sent)
+	}
+}
+
+func TestRunFiltersTest_NeedsFilterOrCriteria(t *testing.T) {
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runFiltersTest(context.Background(), nil, filtersTestCmd{Limit: 50}, out)
+	if err == nil || !strings.Contains(err.Error(), "is required") {
+		t.Errorf("error = %v", err)
+	}
+	err = runFiltersTest(context.Background(), nil, filtersTestCmd{FilterID: "f1", From: "x"}, out)
+	if err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Errorf("error = %v", err)
+	}
+}
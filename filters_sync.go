@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// filterChange is one step of a filter plan, and its outcome once applied.
+type filterChange struct {
+	Action        string     `json:"action"` // create or delete
+	ID            string     `json:"id,omitempty"`
+	Filter        filterSpec `json:"filter"`
+	MissingLabels []string   `json:"missingLabels,omitempty"`
+	Status        string     `json:"status,omitempty"` // created, deleted, skipped or failed
+	Error         string     `json:"error,omitempty"`
+}
+
+// filterPlanOutput is JSON output for filters plan and apply.
+type filterPlanOutput struct {
+	Changes   []filterChange `json:"changes"`
+	Create    int            `json:"create"`
+	Delete    int            `json:"delete"`
+	Unchanged int            `json:"unchanged"`
+	Prune     bool           `json:"prune"`
+}
+
+// describeActions summarizes the actions of a filter for text output.
+func (s filterSpec) describeActions() string {
+	var parts []string
+	for _, l := range s.AddLabels {
+		parts = append(parts, "+"+l)
+	}
+	for _, l := range s.RemoveLabels {
+		parts = append(parts, "-"+l)
+	}
+	if s.Forward != "" {
+		parts = append(parts, "forward:"+s.Forward)
+	}
+	return strings.Join(parts, " ")
+}
+
+// planFilters compares the filters in a file with the account's filters
+// after normalizing both. Filters only in the file are created; filters
+// only in the account are deleted.
+func planFilters(ctx context.Context, conn *gwcli.CmdG, path string, out *outputWriter) (*filterPlanOutput, error) {
+	specs, warnings, err := parseFilterFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	existing, idToName, err := listFilters(ctx, conn, out)
+	if err != nil {
+		return nil, err
+	}
+
+	existingKeys := map[string]bool{}
+	for _, e := range existing {
+		existingKeys[filterKey(e)] = true
+	}
+
+	plan := &filterPlanOutput{Changes: []filterChange{}}
+	wanted := map[string]bool{}
+	for i, spec := range specs {
+		f, missing, err := spec.toGmailFilter(ctx, conn, false, out)
+		if err != nil {
+			return nil, fmt.Errorf("filter %d in %s: %w", i+1, path, err)
+		}
+		key := filterKey(f)
+		if wanted[key] {
+			continue
+		}
+		wanted[key] = true
+		if existingKeys[key] {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, filterChange{
+			Action:        "create",
+			Filter:        spec,
+			MissingLabels: missing,
+		})
+		plan.Create++
+	}
+	for _, e := range existing {
+		if !wanted[filterKey(e)] {
+			plan.Changes = append(plan.Changes, filterChange{
+				Action: "delete",
+				ID:     e.Id,
+				Filter: toFilterSpec(e, idToName),
+			})
+			plan.Delete++
+		}
+	}
+	return plan, nil
+}
+
+// writeFilterPlan prints a plan or the result of applying it.
+func writeFilterPlan(plan *filterPlanOutput, applied bool, out *outputWriter) error {
+	if out.json {
+		return out.writeJSON(plan)
+	}
+	if len(plan.Changes) > 0 {
+		headers := []string{"ACTION", "ID", "CRITERIA", "ACTIONS"}
+		if applied {
+			headers = append(headers, "STATUS", "ERROR")
+		}
+		rows := make([][]string, len(plan.Changes))
+		for i, c := range plan.Changes {
+			actions := c.Filter.describeActions()
+			if len(c.MissingLabels) > 0 {
+				actions += " (creates label " + strings.Join(c.MissingLabels, ", ") + ")"
+			}
+			rows[i] = []string{c.Action, c.ID, c.Filter.describe(), actions}
+			if applied {
+				rows[i] = append(rows[i], c.Status, c.Error)
+			}
+		}
+		if err := out.writeTable(headers, rows); err != nil {
+			return err
+		}
+	}
+	summary := fmt.Sprintf("%d to create, %d to delete, %d unchanged", plan.Create, plan.Delete, plan.Unchanged)
+	if applied {
+		counts := map[string]int{}
+		for _, c := range plan.Changes {
+			counts[c.Status]++
+		}
+		summary = fmt.Sprintf("%d created, %d deleted, %d skipped, %d failed, %d unchanged",
+			counts["created"], counts["deleted"], counts["skipped"], counts["failed"], plan.Unchanged)
+	}
+	if plan.Delete > 0 && !plan.Prune {
+		summary += " (deletes need --prune)"
+	}
+	out.writeMessage(summary)
+	return nil
+}
+
+// runFiltersPlan shows the changes needed to make the account's filters
+// match a file.
+func runFiltersPlan(ctx context.Context, conn *gwcli.CmdG, path string, prune bool, out *outputWriter) error {
+	plan, err := planFilters(ctx, conn, path, out)
+	if err != nil {
+		return err
+	}
+	plan.Prune = prune
+	return writeFilterPlan(plan, false, out)
+}
+
+// runFiltersApply makes the account's filters match a file: it creates the
+// missing filters (and labels) and, with prune, deletes filters not in the
+// file. Like filters delete, deleting needs force. Creates run first so mail
+// is never left unfiltered.
+func runFiltersApply(ctx context.Context, conn *gwcli.CmdG, path string, prune, force bool, out *outputWriter) error {
+	if prune && !force {
+		return fmt.Errorf("refusing to delete filters with --prune without --force")
+	}
+	plan, err := planFilters(ctx, conn, path, out)
+	if err != nil {
+		return err
+	}
+	// An empty or misread file would otherwise delete every filter.
+	if prune && plan.Create+plan.Unchanged == 0 && plan.Delete > 0 {
+		return fmt.Errorf("%s has no filters; refusing to --prune all %d filters in the account", path, plan.Delete)
+	}
+	plan.Prune = prune
+	svc := conn.GmailService()
+
+	failed := 0
+	for i := range plan.Changes {
+		c := &plan.Changes[i]
+		if c.Action != "create" {
+			continue
+		}
+		var f *gmail.Filter
+		f, _, err = c.Filter.toGmailFilter(ctx, conn, true, out)
+		if err == nil {
+			out.writeVerbose("Creating filter %s...", c.Filter.describe())
+			f, err = createFilter(ctx, svc, f)
+		}
+		if err != nil {
+			c.Status, c.Error = "failed", err.Error()
+			failed++
+			continue
+		}
+		c.Status, c.ID = "created", f.Id
+	}
+	for i := range plan.Changes {
+		c := &plan.Changes[i]
+		if c.Action != "delete" {
+			continue
+		}
+		if !prune {
+			c.Status = "skipped"
+			continue
+		}
+		out.writeVerbose("Deleting filter %s...", c.ID)
+		if err := deleteFilter(ctx, svc, c.ID); err != nil {
+			c.Status, c.Error = "failed", err.Error()
+			failed++
+			continue
+		}
+		c.Status = "deleted"
+	}
+
+	if err := writeFilterPlan(plan, true, out); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d filter changes failed", failed, len(plan.Changes))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// syncFilters are the account's filters: the first matches filtersYAML
+// after normalization, the second is not in the file.
+var syncFilters = []*gmail.Filter{
+	{
+		Id:       "keep",
+		Criteria: &gmail.FilterCriteria{From: "Shop@Example.com", Query: "has:attachment  OR  invoice"},
+		Action:   &gmail.FilterAction{AddLabelIds: []string{"STARRED", "Label_1"}, RemoveLabelIds: []string{"INBOX"}},
+	},
+	{
+		Id:       "old",
+		Criteria: &gmail.FilterCriteria{Subject: "old"},
+		Action:   &gmail.FilterAction{AddLabelIds: []string{"TRASH"}},
+	},
+}
+
+const filtersYAML = `
+- from: shop@example.com
+  query: has:attachment OR invoice
+  addLabels: [receipts, Starred]
+  removeLabels: [INBOX]
+- subject: "[CI]"
+  addLabels: [CI]
+  removeLabels: [UNREAD]
+`
+
+func TestRunFiltersPlan(t *testing.T) {
+	api := newFakeFilterAPI(t, syncFilters, "Receipts")
+	path := writeTempFile(t, "filters.yaml", filtersYAML)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runFiltersPlan(context.Background(), api.conn(), path, false, out); err != nil {
+		t.Fatalf("runFiltersPlan() error = %v", err)
+	}
+	var plan filterPlanOutput
+	if err := json.Unmarshal(buf.Bytes(), &plan); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if plan.Create != 1 || plan.Delete != 1 || plan.Unchanged != 1 {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if c := plan.Changes[0]; c.Action != "create" || c.Filter.Subject != "[CI]" || strings.Join(c.MissingLabels, ",") != "CI" {
+		t.Errorf("create = %+v", c)
+	}
+	if c := plan.Changes[1]; c.Action != "delete" || c.ID != "old" {
+		t.Errorf("delete = %+v", c)
+	}
+	if len(api.created) != 0 || len(api.deleted) != 0 || len(api.labelNames()) != 1 {
+		t.Errorf("plan changed the account: created %v, deleted %v, labels %v", api.created, api.deleted, api.labelNames())
+	}
+}
+
+func TestRunFiltersApply(t *testing.T) {
+	for _, prune := range []bool{false, true} {
+		api := newFakeFilterAPI(t, syncFilters, "Receipts")
+		path := writeTempFile(t, "filters.yaml", filtersYAML)
+		var buf bytes.Buffer
+		out := &outputWriter{json: true, writer: &buf}
+
+		if err := runFiltersApply(context.Background(), api.conn(), path, prune, true, out); err != nil {
+			t.Fatalf("runFiltersApply(prune=%t) error = %v", prune, err)
+		}
+		if len(api.created) != 1 || api.created[0].Criteria.Subject != "[CI]" || api.created[0].Action.AddLabelIds[0] != "Label_2" {
+			t.Errorf("prune=%t: created = %+v", prune, api.created)
+		}
+		wantDeleted := ""
+		if prune {
+			wantDeleted = "old"
+		}
+		if got := strings.Join(api.deleted, ","); got != wantDeleted {
+			t.Errorf("prune=%t: deleted = %q, want %q", prune, got, wantDeleted)
+		}
+	}
+}
+
+func TestRunFiltersApply_PruneSafety(t *testing.T) {
+	api := newFakeFilterAPI(t, syncFilters, "Receipts")
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	path := writeTempFile(t, "filters.yaml", filtersYAML)
+	err := runFiltersApply(context.Background(), api.conn(), path, true, false, out)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("without --force: error = %v", err)
+	}
+
+	empty := writeTempFile(t, "empty.yaml", "")
+	err = runFiltersApply(context.Background(), api.conn(), empty, true, true, out)
+	if err == nil || !strings.Contains(err.Error(), "has no filters") {
+		t.Errorf("empty file: error = %v", err)
+	}
+	if len(api.created) != 0 || len(api.deleted) != 0 {
+		t.Errorf("filters changed: created %v, deleted %v", api.created, api.deleted)
+	}
+}
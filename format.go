@@ -19,16 +19,22 @@ const (
 
 // EmailFrontmatter represents the YAML frontmatter for email output
 type EmailFrontmatter struct {
+	DraftID   string   `yaml:"draft_id,omitempty"`
 	MessageID string   `yaml:"message_id"`
 	ThreadID  string   `yaml:"thread_id"`
 	From      string   `yaml:"from"`
 	To        string   `yaml:"to"`
 	Cc        string   `yaml:"cc,omitempty"`
+	Bcc       string   `yaml:"bcc,omitempty"`
 	Subject   string   `yaml:"subject"`
 	Date      string   `yaml:"date"`
 	Labels    []string `yaml:"labels,omitempty"`
 	Note      string   `yaml:"note,omitempty"` // For fallback messages
 
+	// Signature is the PGP/MIME or S/MIME verification result, if the
+	// message is signed or encrypted.
+	Signature *signatureInfo `yaml:"signature,omitempty"`
+
 	// DriveArtifacts are Google Drive docs/files linked from the body
 	// (e.g. Gemini/Meet "Notes by Gemini" chips). Not MIME attachments.
 	DriveArtifacts []driveArtifact `yaml:"drive_artifacts,omitempty"`
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// messageImportItem is the outcome for one message found in an input file.
+type messageImportItem struct {
+	File      string `json:"file"`
+	Index     int    `json:"index"`
+	MessageID string `json:"messageId,omitempty"` // RFC822 Message-ID header
+	ID        string `json:"id,omitempty"`        // Gmail message ID
+	Status    string `json:"status"`              // imported, duplicate, failed
+	Error     string `json:"error,omitempty"`
+}
+
+// messageImportResult is JSON output format for messages import.
+type messageImportResult struct {
+	Imported   int                 `json:"imported"`
+	Duplicates int                 `json:"duplicates"`
+	Failed     int                 `json:"failed"`
+	Messages   []messageImportItem `json:"messages"`
+}
+
+// messageImportOptions controls how messages are added to the mailbox.
+type messageImportOptions struct {
+	labelIDs   []string
+	markRead   bool
+	keepDate   bool
+	insert     bool
+	allowDupes bool
+}
+
+// isMbox reports whether the file content looks like an mbox archive.
+func isMbox(r *bufio.Reader) bool {
+	head, _ := r.Peek(5)
+	return string(head) == "From "
+}
+
+// readMbox calls fn with every message in an mboxrd/mboxo archive, with the
+// From_ separator removed and ">From " escaping undone.
+func readMbox(r *bufio.Reader, fn func(raw string) error) error {
+	var cur strings.Builder
+	started := false
+	prevBlank := true
+	flush := func(last bool) error {
+		if !started {
+			return nil
+		}
+		// The blank line before the next From_ line (or closing the file)
+		// belongs to the separator, not the message.
+		raw := cur.String()
+		if !last || strings.HasSuffix(raw, "\n\n") {
+			raw = strings.TrimSuffix(raw, "\n")
+		}
+		cur.Reset()
+		return fn(raw)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			if prevBlank && strings.HasPrefix(line, "From ") {
+				if err := flush(false); err != nil {
+					return err
+				}
+				started = true
+			} else if started {
+				trimmed := strings.TrimLeft(line, ">")
+				if len(trimmed) < len(line) && strings.HasPrefix(trimmed, "From ") {
+					line = line[1:]
+				}
+				cur.WriteString(line)
+			}
+			prevBlank = strings.TrimRight(line, "\r\n") == ""
+		}
+		if err == io.EOF {
+			return flush(true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rfc822MessageID returns the Message-ID header of a raw message.
+func rfc822MessageID(raw string) string {
+	m, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(m.Header.Get("Message-Id"))
+}
+
+// messageIDExists reports whether the mailbox already has a message with
+// the given Message-ID header.
+func messageIDExists(ctx context.Context, conn *gwcli.CmdG, messageID string) (bool, error) {
+	id := strings.Trim(messageID, "<>")
+	page, err := conn.ListMessagesN(ctx, "", "rfc822msgid:"+id, "", 1)
+	if err != nil {
+		return false, err
+	}
+	return len(page.Messages) > 0, nil
+}
+
+// importRaw uploads one RFC822 message with Import (or Insert) and returns
+// the new Gmail message ID.
+func importRaw(ctx context.Context, svc *gmail.Service, raw string, opts messageImportOptions) (string, error) {
+	labels := append([]string{}, opts.labelIDs...)
+	if !opts.markRead {
+		labels = append(labels, gwcli.Unread)
+	}
+	msg := &gmail.Message{LabelIds: dedupe(labels)}
+	dateSource := "receivedTime"
+	if opts.keepDate {
+		dateSource = "dateHeader"
+	}
+	media := googleapi.ContentType("message/rfc822")
+
+	var res *gmail.Message
+	var err error
+	if opts.insert {
+		res, err = svc.Users.Messages.Insert("me", msg).
+			InternalDateSource(dateSource).
+			Media(strings.NewReader(raw), media).
+			Context(ctx).
+			Do()
+	} else {
+		res, err = svc.Users.Messages.Import("me", msg).
+			InternalDateSource(dateSource).
+			Media(strings.NewReader(raw), media).
+			Context(ctx).
+			Do()
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.Id, nil
+}
+
+// runMessagesImport imports .eml files and mbox archives into the mailbox.
+// Messages whose Message-ID already exists are skipped unless allowDupes.
+func runMessagesImport(ctx context.Context, conn *gwcli.CmdG, files, labels []string, markRead, keepDate, insert, allowDupes bool, out *outputWriter) error {
+	if len(files) == 0 {
+		return fmt.Errorf("at least one file is required")
+	}
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	opts := messageImportOptions{markRead: markRead, keepDate: keepDate, insert: insert, allowDupes: allowDupes}
+	if len(labels) > 0 {
+		if err := conn.LoadLabels(ctx, out.verbose); err != nil {
+			return fmt.Errorf("failed to load labels: %w", err)
+		}
+		for _, l := range labels {
+			id, err := resolveLabelID(conn, l)
+			if err != nil {
+				return err
+			}
+			opts.labelIDs = append(opts.labelIDs, id)
+		}
+	}
+
+	result := messageImportResult{Messages: []messageImportItem{}}
+	seen := map[string]bool{}
+
+	importOne := func(file string, index int, raw string) {
+		item := messageImportItem{File: file, Index: index, MessageID: rfc822MessageID(raw)}
+		defer func() { result.Messages = append(result.Messages, item) }()
+
+		if item.MessageID != "" && !opts.allowDupes {
+			dup := seen[item.MessageID]
+			if !dup {
+				exists, err := messageIDExists(ctx, conn, item.MessageID)
+				if err != nil {
+					item.Status, item.Error = "failed", fmt.Sprintf("duplicate check: %v", err)
+					result.Failed++
+					return
+				}
+				dup = exists
+			}
+			if dup {
+				out.writeVerbose("Skipping duplicate %s", item.MessageID)
+				item.Status = "duplicate"
+				result.Duplicates++
+				return
+			}
+		}
+
+		id, err := importRaw(ctx, svc, raw, opts)
+		if err != nil {
+			item.Status, item.Error = "failed", err.Error()
+			result.Failed++
+			return
+		}
+		out.writeVerbose("Imported %s from %s as %s", item.MessageID, file, id)
+		if item.MessageID != "" {
+			seen[item.MessageID] = true
+		}
+		item.ID, item.Status = id, "imported"
+		result.Imported++
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file, err)
+		}
+		r := bufio.NewReader(f)
+		name := filepath.Base(file)
+		if isMbox(r) {
+			index := 0
+			err = readMbox(r, func(raw string) error {
+				importOne(name, index, raw)
+				index++
+				return ctx.Err()
+			})
+		} else {
+			var data []byte
+			if data, err = io.ReadAll(r); err == nil {
+				importOne(name, 0, string(data))
+			}
+		}
+		f.Close()
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+	}
+
+	if out.json {
+		if err := out.writeJSON(result); err != nil {
+			return err
+		}
+	} else {
+		headers := []string{"FILE", "INDEX", "MESSAGE-ID", "STATUS", "ID"}
+		rows := make([][]string, len(result.Messages))
+		for i, m := range result.Messages {
+			status := m.Status
+			if m.Error != "" {
+				status += ": " + m.Error
+			}
+			rows[i] = []string{m.File, fmt.Sprintf("%d", m.Index), truncateString(m.MessageID, 50), status, m.ID}
+		}
+		if err := out.writeTable(headers, rows); err != nil {
+			return err
+		}
+		out.writeMessage(fmt.Sprintf("Imported %d, skipped %d duplicates, %d failed", result.Imported, result.Duplicates, result.Failed))
+	}
+
+	if result.Failed > 0 {
+		return fmt.Errorf("%d messages failed to import", result.Failed)
+	}
+	return nil
+}
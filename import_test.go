@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadMbox(t *testing.T) {
+	in := "From a@example.com Thu Jan  1 00:00:00 1970\nSubject: one\n\n>From the start\n>>From deeper\n\n" +
+		"From b@example.com Thu Jan  1 00:00:00 1970\nSubject: two\n\nbody\n"
+	var got []string
+	if err := readMbox(bufio.NewReader(strings.NewReader(in)), func(raw string) error {
+		got = append(got, raw)
+		return nil
+	}); err != nil {
+		t.Fatalf("readMbox() error = %v", err)
+	}
+	want := []string{
+		"Subject: one\n\nFrom the start\n>From deeper\n",
+		"Subject: two\n\nbody\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d: %q", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRunMessagesImport_DedupesByMessageID(t *testing.T) {
+	dir := t.TempDir()
+	mbox := "From x Thu Jan  1 00:00:00 1970\nMessage-ID: <old@example.com>\nSubject: old\n\nalready there\n\n" +
+		"From x Thu Jan  1 00:00:00 1970\nMessage-ID: <new@example.com>\nSubject: new\n\nfresh\n\n" +
+		"From x Thu Jan  1 00:00:00 1970\nMessage-ID: <new@example.com>\nSubject: new again\n\nrepeat\n"
+	eml := "Message-ID: <eml@example.com>\nSubject: eml\n\nsingle\n"
+	mboxPath := filepath.Join(dir, "legacy.mbox")
+	emlPath := filepath.Join(dir, "one.eml")
+	if err := os.WriteFile(mboxPath, []byte(mbox), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(emlPath, []byte(eml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads []string
+	var dateSources []string
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch path {
+		case "labels":
+			return `{"labels":[{"id":"Label_9","name":"Legacy","type":"user"}]}`
+		case "messages":
+			if req.URL.Query().Get("q") == "rfc822msgid:old@example.com" {
+				return `{"messages":[{"id":"G0","threadId":"G0"}]}`
+			}
+			return `{}`
+		case "messages/import":
+			data, _ := io.ReadAll(req.Body)
+			uploads = append(uploads, string(data))
+			dateSources = append(dateSources, req.URL.Query().Get("internalDateSource"))
+			return fmt.Sprintf(`{"id":"G%d"}`, len(uploads))
+		}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesImport(context.Background(), conn, []string{mboxPath, emlPath}, []string{"Legacy"}, true, true, false, false, out); err != nil {
+		t.Fatalf("runMessagesImport() error = %v", err)
+	}
+
+	var res messageImportResult
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res.Imported != 2 || res.Duplicates != 2 || res.Failed != 0 {
+		t.Errorf("imported=%d duplicates=%d failed=%d, want 2/2/0", res.Imported, res.Duplicates, res.Failed)
+	}
+	var statuses []string
+	for _, m := range res.Messages {
+		statuses = append(statuses, m.Status)
+	}
+	if strings.Join(statuses, ",") != "duplicate,imported,duplicate,imported" {
+		t.Errorf("statuses = %v", statuses)
+	}
+
+	if len(uploads) != 2 {
+		t.Fatalf("expected 2 uploads, got %d", len(uploads))
+	}
+	if !strings.Contains(uploads[0], "Subject: new") || !strings.Contains(uploads[0], `"labelIds":["Label_9"]`) {
+		t.Errorf("unexpected upload: %s", uploads[0])
+	}
+	if strings.Contains(uploads[0], "UNREAD") {
+		t.Error("--mark-read should not add UNREAD")
+	}
+	if dateSources[0] != "dateHeader" {
+		t.Errorf("internalDateSource = %q, want dateHeader", dateSources[0])
+	}
+}
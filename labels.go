@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
 )
 
 // labelListOutput is JSON output for labels
@@ -17,6 +19,63 @@ type labelListOutput struct {
 	MessageListView string `json:"messageListVisibility,omitempty"`
 	LabelListView   string `json:"labelListVisibility,omitempty"`
 	Color           string `json:"color,omitempty"`
+	TextColor       string `json:"textColor,omitempty"`
+}
+
+// toLabelOutput converts a Gmail label for JSON output.
+func toLabelOutput(l *gmail.Label) labelListOutput {
+	lo := labelListOutput{
+		ID:              l.Id,
+		Name:            l.Name,
+		Type:            l.Type,
+		MessageListView: l.MessageListVisibility,
+		LabelListView:   l.LabelListVisibility,
+	}
+	if l.Color != nil {
+		lo.Color = l.Color.BackgroundColor
+		lo.TextColor = l.Color.TextColor
+	}
+	return lo
+}
+
+// findLabel resolves a label name (case-insensitive) or ID.
+func findLabel(ctx context.Context, conn *gwcli.CmdG, nameOrID string) (*gwcli.Label, error) {
+	if err := conn.LoadLabels(ctx, false); err != nil {
+		return nil, fmt.Errorf("failed to load labels: %w", err)
+	}
+	for _, l := range conn.Labels() {
+		if strings.EqualFold(l.Label, nameOrID) || l.ID == nameOrID {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("label %q not found (use a label name or ID; see 'gwcli labels list')", nameOrID)
+}
+
+// findUserLabel is findLabel for commands that change the label itself,
+// which Gmail only allows for user labels.
+func findUserLabel(ctx context.Context, conn *gwcli.CmdG, nameOrID string) (*gwcli.Label, error) {
+	l, err := findLabel(ctx, conn, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	if l.Response == nil || l.Response.Type == "system" {
+		return nil, fmt.Errorf("%s is a system label and cannot be changed", l.Label)
+	}
+	return l, nil
+}
+
+// labelColor validates a background and text color against the Gmail
+// label palette.
+func labelColor(bg, fg string) (*gmail.LabelColor, error) {
+	for _, c := range []string{bg, fg} {
+		if !gwcli.IsLabelColor(c) {
+			return nil, fmt.Errorf("%q is not a Gmail label color; use one of: %s", c, strings.Join(gwcli.LabelColors(), " "))
+		}
+	}
+	return &gmail.LabelColor{
+		BackgroundColor: strings.ToLower(bg),
+		TextColor:       strings.ToLower(fg),
+	}, nil
 }
 
 func runLabelsList(ctx context.Context, conn *gwcli.CmdG, systemOnly, userOnly bool, out *outputWriter) error {
@@ -47,16 +106,9 @@ func runLabelsList(ctx context.Context, conn *gwcli.CmdG, systemOnly, userOnly b
 	if out.json {
 		output := make([]labelListOutput, len(filtered))
 		for i, l := range filtered {
-			output[i] = labelListOutput{
-				ID:              l.ID,
-				Name:            l.Label,
-				Type:            l.Response.Type,
-				MessageListView: l.Response.MessageListVisibility,
-				LabelListView:   l.Response.LabelListVisibility,
-			}
-			if l.Response.Color != nil {
-				output[i].Color = l.Response.Color.BackgroundColor
-			}
+			output[i] = toLabelOutput(l.Response)
+			// System labels are listed under their friendly names.
+			output[i].Name = l.Label
 		}
 		return out.writeJSON(output)
 	}
@@ -75,20 +127,35 @@ func runLabelsList(ctx context.Context, conn *gwcli.CmdG, systemOnly, userOnly b
 	return out.writeTable(headers, rows)
 }
 
-func runLabelsApply(ctx context.Context, conn *gwcli.CmdG, labelID, messageID string, stdin bool, verbose bool, out *outputWriter) error {
-	var ids []string
-	var err error
+func runLabelsApply(ctx context.Context, conn *gwcli.CmdG, labelID, messageID string, sel bulkFlags, verbose bool, out *outputWriter) error {
+	ids, err := sel.messageIDs(ctx, conn, messageID, "--message", out)
+	if err != nil {
+		return err
+	}
 
-	if stdin {
-		ids, err = readIDsFromStdin()
-		if err != nil {
-			return err
-		}
-	} else {
-		if messageID == "" {
-			return fmt.Errorf("either provide --message or use --stdin")
-		}
-		ids = []string{messageID}
+	out.writeVerbose("Loading labels from config...")
+	if err := conn.LoadLabels(ctx, out.verbose); err != nil {
+		return fmt.Errorf("failed to load labels: %w", err)
+	}
+
+	resolvedID, err := resolveLabelID(conn, labelID)
+	if err != nil {
+		return err
+	}
+	out.writeVerbose("Resolved label '%s' to ID '%s'", labelID, resolvedID)
+
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, "apply", fmt.Sprintf("apply label %s to %s messages", labelID, sel.matched(ids)), out)
+	}
+	bp := newBatchProcessor(len(ids), verbose)
+	bp.modify(ctx, conn, ids, []string{resolvedID}, nil)
+	return bp.finish(out, "applied", "Label applied")
+}
+
+func runLabelsRemove(ctx context.Context, conn *gwcli.CmdG, labelID, messageID string, sel bulkFlags, verbose bool, out *outputWriter) error {
+	ids, err := sel.messageIDs(ctx, conn, messageID, "--message", out)
+	if err != nil {
+		return err
 	}
 
 	out.writeVerbose("Loading labels from config...")
@@ -96,109 +163,580 @@ func runLabelsApply(ctx context.Context, conn *gwcli.CmdG, labelID, messageID st
 		return fmt.Errorf("failed to load labels: %w", err)
 	}
 
-	labels := conn.Labels()
-	out.writeVerbose("Loaded %d labels, resolving '%s'...", len(labels), labelID)
+	resolvedID, err := resolveLabelID(conn, labelID)
+	if err != nil {
+		return err
+	}
+	out.writeVerbose("Resolved label '%s' to ID '%s'", labelID, resolvedID)
 
-	resolvedID := labelID
-	found := false
-	for _, l := range labels {
-		if strings.EqualFold(l.Label, labelID) || l.ID == labelID {
-			resolvedID = l.ID
-			found = true
-			out.writeVerbose("Resolved label '%s' to ID '%s'", labelID, resolvedID)
-			break
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, "remove", fmt.Sprintf("remove label %s from %s messages", labelID, sel.matched(ids)), out)
+	}
+	bp := newBatchProcessor(len(ids), verbose)
+	bp.modify(ctx, conn, ids, nil, []string{resolvedID})
+	return bp.finish(out, "removed", "Label removed")
+}
+
+// runLabelsCreate creates a label. Nested labels are named "Parent/Child";
+// missing parents are created first so Gmail shows the nesting.
+func runLabelsCreate(ctx context.Context, conn *gwcli.CmdG, name, parent, bg, fg string, hide bool, out *outputWriter) error {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return fmt.Errorf("label name is required")
+	}
+	if parent != "" {
+		p, err := findUserLabel(ctx, conn, parent)
+		if err != nil {
+			return err
 		}
+		name = p.Label + "/" + name
 	}
 
-	if !found {
-		out.writeVerbose("Label '%s' not found. Available labels:", labelID)
-		for _, l := range labels {
-			out.writeVerbose("  - %s (ID: %s)", l.Label, l.ID)
+	label := &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}
+	if hide {
+		label.LabelListVisibility = "labelHide"
+	}
+	if bg != "" {
+		color, err := labelColor(bg, fg)
+		if err != nil {
+			return err
 		}
-		fmt.Fprintf(os.Stderr, "Warning: label '%s' not found\n", labelID)
+		label.Color = color
 	}
 
-	// Batch operation
-	bp := newBatchProcessor(len(ids), verbose)
-	err = bp.process(ctx, ids, func(ctx context.Context, id string) error {
-		return conn.BatchLabel(ctx, []string{id}, resolvedID)
+	if _, err := findLabel(ctx, conn, name); err == nil {
+		return fmt.Errorf("label %q already exists", name)
+	}
+	if _, err := createParentLabels(ctx, conn, name, out); err != nil {
+		return err
+	}
+
+	out.writeVerbose("Creating label %s...", name)
+	created, err := conn.CreateLabel(ctx, label)
+	if err != nil {
+		return fmt.Errorf("failed to create label: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(toLabelOutput(created))
+	}
+	out.writeMessage(fmt.Sprintf("Created label %s (%s)", created.Name, created.Id))
+	return nil
+}
+
+// createParentLabels creates the missing ancestors of a nested label name
+// ("A" and "A/B" for "A/B/C") and returns the names it created.
+func createParentLabels(ctx context.Context, conn *gwcli.CmdG, name string, out *outputWriter) ([]string, error) {
+	var created []string
+	segments := strings.Split(name, "/")
+	for i := 1; i < len(segments); i++ {
+		ancestor := strings.Join(segments[:i], "/")
+		if _, err := findLabel(ctx, conn, ancestor); err == nil {
+			continue
+		}
+		out.writeVerbose("Creating parent label %s...", ancestor)
+		if _, err := conn.CreateLabel(ctx, &gmail.Label{Name: ancestor}); err != nil {
+			return created, fmt.Errorf("failed to create parent label: %w", err)
+		}
+		created = append(created, ancestor)
+	}
+	return created, nil
+}
+
+// ensureLabel returns the ID of the label named nameOrID, creating it and
+// any missing parents if it does not exist. created lists the new labels.
+func ensureLabel(ctx context.Context, conn *gwcli.CmdG, nameOrID string, out *outputWriter) (id string, created []string, err error) {
+	if l, err := findLabel(ctx, conn, nameOrID); err == nil {
+		return l.ID, nil, nil
+	}
+	name := strings.Trim(nameOrID, "/")
+	if created, err = createParentLabels(ctx, conn, name, out); err != nil {
+		return "", created, err
+	}
+	out.writeVerbose("Creating label %s...", name)
+	l, err := conn.CreateLabel(ctx, &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
 	})
+	if err != nil {
+		return "", created, fmt.Errorf("failed to create label %s: %w", name, err)
+	}
+	return l.Id, append(created, name), nil
+}
+
+// runLabelsRename renames a label together with its sub-labels.
+func runLabelsRename(ctx context.Context, conn *gwcli.CmdG, oldName, newName string, out *outputWriter) error {
+	newName = strings.Trim(newName, "/")
+	if newName == "" {
+		return fmt.Errorf("new label name is required")
+	}
+	l, err := findUserLabel(ctx, conn, oldName)
+	if err != nil {
+		return err
+	}
+	if l.Label == newName {
+		return fmt.Errorf("label is already named %q", newName)
+	}
+
+	// Collect the label and its sub-labels before renaming, since each
+	// rename refreshes the label cache.
+	type rename struct{ id, from, to string }
+	renames := []rename{{l.ID, l.Label, newName}}
+	prefix := l.Label + "/"
+	for _, sub := range conn.Labels() {
+		if strings.HasPrefix(sub.Label, prefix) {
+			renames = append(renames, rename{sub.ID, sub.Label, newName + "/" + strings.TrimPrefix(sub.Label, prefix)})
+		}
+	}
+
+	var renamed []labelListOutput
+	for i, r := range renames {
+		out.writeVerbose("Renaming %s to %s...", r.from, r.to)
+		updated, err := conn.UpdateLabel(ctx, r.id, &gmail.Label{Name: r.to})
+		if err != nil {
+			// There is no transaction to roll back, so say exactly where
+			// the rename stopped.
+			done := "none"
+			if i > 0 {
+				var ds []string
+				for _, d := range renames[:i] {
+					ds = append(ds, d.from+" -> "+d.to)
+				}
+				done = strings.Join(ds, ", ")
+			}
+			var left []string
+			for _, l := range renames[i:] {
+				left = append(left, l.from)
+			}
+			return fmt.Errorf("failed to rename label %s: %w (renamed: %s; not renamed: %s)",
+				r.from, err, done, strings.Join(left, ", "))
+		}
+		renamed = append(renamed, toLabelOutput(updated))
+	}
 
 	if out.json {
-		return out.writeJSON(map[string]int{
-			"applied": bp.processed - len(bp.errors),
-			"errors":  len(bp.errors),
-		})
+		return out.writeJSON(renamed)
+	}
+	for _, r := range renames {
+		out.writeMessage(fmt.Sprintf("Renamed %s to %s", r.from, r.to))
+	}
+	return nil
+}
+
+// runLabelsDelete deletes a label. Messages keep their other labels, and
+// sub-labels are left in place.
+func runLabelsDelete(ctx context.Context, conn *gwcli.CmdG, nameOrID string, force bool, out *outputWriter) error {
+	if !force {
+		return fmt.Errorf("refusing to delete label %s without --force", nameOrID)
+	}
+	l, err := findUserLabel(ctx, conn, nameOrID)
+	if err != nil {
+		return err
+	}
+
+	out.writeVerbose("Deleting label %s...", l.Label)
+	if err := conn.DeleteLabel(ctx, l.ID); err != nil {
+		return fmt.Errorf("failed to delete label: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]string{"deleted": l.ID, "name": l.Label})
+	}
+	out.writeMessage(fmt.Sprintf("Deleted label %s", l.Label))
+	return nil
+}
+
+// runLabelsColor sets or clears a label's color.
+func runLabelsColor(ctx context.Context, conn *gwcli.CmdG, nameOrID, bg, fg string, clear, palette bool, out *outputWriter) error {
+	if palette {
+		colors := gwcli.LabelColors()
+		if out.json {
+			return out.writeJSON(colors)
+		}
+		out.writeMessage(strings.Join(colors, "\n"))
+		return nil
+	}
+	if nameOrID == "" {
+		return fmt.Errorf("label is required")
+	}
+	if clear == (bg != "") {
+		return fmt.Errorf("use exactly one of --bg or --clear")
+	}
+	l, err := findUserLabel(ctx, conn, nameOrID)
+	if err != nil {
+		return err
+	}
+
+	patch := &gmail.Label{}
+	if clear {
+		// An empty color object resets the label to the default color.
+		patch.Color = &gmail.LabelColor{}
+		patch.ForceSendFields = []string{"Color"}
+	} else if patch.Color, err = labelColor(bg, fg); err != nil {
+		return err
 	}
 
-	if len(ids) == 1 {
-		out.writeMessage("Label applied")
+	out.writeVerbose("Updating color of %s...", l.Label)
+	updated, err := conn.UpdateLabel(ctx, l.ID, patch)
+	if err != nil {
+		return fmt.Errorf("failed to update label color: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(toLabelOutput(updated))
+	}
+	if clear {
+		out.writeMessage(fmt.Sprintf("Cleared color of %s", updated.Name))
 	} else {
-		bp.report(os.Stdout)
+		out.writeMessage(fmt.Sprintf("Set color of %s to %s on %s", updated.Name, fg, bg))
+	}
+	return nil
+}
+
+// labelsMergeOutput is JSON output for labels merge.
+type labelsMergeOutput struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Relabeled   int    `json:"relabeled"`
+	Deleted     bool   `json:"deleted"`
+}
+
+// runLabelsMerge moves every message from src to dst, then deletes src.
+// Messages in spam and trash are moved too, or deleting src would
+// silently drop the label from them.
+func runLabelsMerge(ctx context.Context, conn *gwcli.CmdG, srcName, dstName string, force bool, out *outputWriter) error {
+	if !force {
+		return fmt.Errorf("refusing to merge and delete label %s without --force", srcName)
+	}
+	src, err := findUserLabel(ctx, conn, srcName)
+	if err != nil {
+		return err
+	}
+	dst, err := findLabel(ctx, conn, dstName)
+	if err != nil {
+		return err
+	}
+	if src.ID == dst.ID {
+		return fmt.Errorf("source and destination are the same label")
+	}
+
+	out.writeVerbose("Listing messages in %s...", src.Label)
+	ids, err := conn.ListMessageIDs(ctx, src.ID, "in:anywhere")
+	if err != nil {
+		return fmt.Errorf("failed to list messages in %s: %w", src.Label, err)
+	}
+	out.writeVerbose("Relabeling %d messages from %s to %s...", len(ids), src.Label, dst.Label)
+	bp := newBatchProcessor(len(ids), out.verbose)
+	bp.modify(ctx, conn, ids, []string{dst.ID}, []string{src.ID})
+	if err := bp.err(); err != nil {
+		return fmt.Errorf("failed to relabel messages, not deleting %s: %w", src.Label, err)
 	}
-	return err
+	if err := conn.DeleteLabel(ctx, src.ID); err != nil {
+		return fmt.Errorf("relabeled %d messages but failed to delete %s: %w", len(ids), src.Label, err)
+	}
+
+	res := labelsMergeOutput{Source: src.Label, Destination: dst.Label, Relabeled: len(ids), Deleted: true}
+	if out.json {
+		return out.writeJSON(res)
+	}
+	out.writeMessage(fmt.Sprintf("Moved %d messages from %s to %s and deleted %s", len(ids), src.Label, dst.Label, src.Label))
+	return nil
 }
 
-func runLabelsRemove(ctx context.Context, conn *gwcli.CmdG, labelID, messageID string, stdin bool, verbose bool, out *outputWriter) error {
-	var ids []string
-	var err error
+// labelCounts are the message and thread counts of a label.
+type labelCounts struct {
+	MessagesTotal  int64 `json:"messagesTotal"`
+	MessagesUnread int64 `json:"messagesUnread"`
+	ThreadsTotal   int64 `json:"threadsTotal"`
+	ThreadsUnread  int64 `json:"threadsUnread"`
+}
 
-	if stdin {
-		ids, err = readIDsFromStdin()
+func (c *labelCounts) add(o labelCounts) {
+	c.MessagesTotal += o.MessagesTotal
+	c.MessagesUnread += o.MessagesUnread
+	c.ThreadsTotal += o.ThreadsTotal
+	c.ThreadsUnread += o.ThreadsUnread
+}
+
+// labelStatsOutput is JSON output for labels stats.
+type labelStatsOutput struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	labelCounts
+}
+
+// loadLabelCounts fetches the counts of labels concurrently, keyed by
+// label ID. Labels.List does not return counts, so each label needs a Get.
+func loadLabelCounts(ctx context.Context, conn *gwcli.CmdG, labels []*gwcli.Label, out *outputWriter) (map[string]labelCounts, error) {
+	const conc = 10
+	counts := make([]labelCounts, len(labels))
+	errs := make([]error, len(labels))
+	sem := make(chan struct{}, conc)
+	var wg sync.WaitGroup
+	for i, l := range labels {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			gl, err := conn.GetLabel(ctx, id)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			counts[i] = labelCounts{
+				MessagesTotal:  gl.MessagesTotal,
+				MessagesUnread: gl.MessagesUnread,
+				ThreadsTotal:   gl.ThreadsTotal,
+				ThreadsUnread:  gl.ThreadsUnread,
+			}
+		}(i, l.ID)
+	}
+	wg.Wait()
+
+	ret := make(map[string]labelCounts, len(labels))
+	for i, l := range labels {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to get counts for %s: %w", l.Label, errs[i])
+		}
+		ret[l.ID] = counts[i]
+	}
+	out.writeVerbose("Loaded counts for %d labels", len(labels))
+	return ret, nil
+}
+
+// selectLabels returns the labels named by patterns, in list order. A
+// pattern is a label name or ID, or "Parent/*" for every label below
+// Parent. Without patterns all labels are returned.
+func selectLabels(ctx context.Context, conn *gwcli.CmdG, patterns []string, systemOnly, userOnly bool) ([]*gwcli.Label, error) {
+	if err := conn.LoadLabels(ctx, false); err != nil {
+		return nil, fmt.Errorf("failed to load labels: %w", err)
+	}
+	want := map[string]bool{}
+	var prefixes []string
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok {
+			prefixes = append(prefixes, strings.ToLower(prefix)+"/")
+			continue
+		}
+		l, err := findLabel(ctx, conn, p)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	} else {
-		if messageID == "" {
-			return fmt.Errorf("either provide --message or use --stdin")
+		want[l.ID] = true
+	}
+
+	var ret []*gwcli.Label
+	for _, l := range conn.Labels() {
+		if l.Response == nil {
+			continue
+		}
+		isSystem := l.Response.Type == "system"
+		if (systemOnly && !isSystem) || (userOnly && isSystem) {
+			continue
+		}
+		match := len(patterns) == 0 || want[l.ID]
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.ToLower(l.Label), prefix) {
+				match = true
+			}
+		}
+		if match {
+			ret = append(ret, l)
 		}
-		ids = []string{messageID}
 	}
+	return ret, nil
+}
 
-	out.writeVerbose("Loading labels from config...")
-	if err := conn.LoadLabels(ctx, out.verbose); err != nil {
-		return fmt.Errorf("failed to load labels: %w", err)
+// runLabelsStats reports message and thread counts per label.
+func runLabelsStats(ctx context.Context, conn *gwcli.CmdG, patterns []string, systemOnly, userOnly bool, out *outputWriter) error {
+	labels, err := selectLabels(ctx, conn, patterns, systemOnly, userOnly)
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return out.WriteEmptyList("No labels found")
+	}
+	counts, err := loadLabelCounts(ctx, conn, labels, out)
+	if err != nil {
+		return err
 	}
 
-	labels := conn.Labels()
-	out.writeVerbose("Loaded %d labels, resolving '%s'...", len(labels), labelID)
+	if out.json {
+		output := make([]labelStatsOutput, len(labels))
+		for i, l := range labels {
+			output[i] = labelStatsOutput{ID: l.ID, Name: l.Label, Type: l.Response.Type, labelCounts: counts[l.ID]}
+		}
+		return out.writeJSON(output)
+	}
+
+	headers := []string{"NAME", "MESSAGES", "UNREAD", "THREADS", "UNREAD THREADS"}
+	rows := make([][]string, len(labels))
+	for i, l := range labels {
+		c := counts[l.ID]
+		rows[i] = []string{
+			l.Label,
+			fmt.Sprint(c.MessagesTotal),
+			fmt.Sprint(c.MessagesUnread),
+			fmt.Sprint(c.ThreadsTotal),
+			fmt.Sprint(c.ThreadsUnread),
+		}
+	}
+	return out.writeTable(headers, rows)
+}
+
+// labelTreeNode is a label in the labels tree. Its own counts are inline;
+// Total adds up the counts of the label and all labels below it. A parent
+// that exists only as a prefix of its sub-labels has no ID.
+type labelTreeNode struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	ID   string `json:"id,omitempty"`
+	labelCounts
+	Total    labelCounts      `json:"total"`
+	Children []*labelTreeNode `json:"children,omitempty"`
+}
 
-	resolvedID := labelID
-	found := false
+// buildLabelTree arranges user labels into a hierarchy by their "/"
+// separated names. It returns the top-level nodes and every node by
+// lowercased path.
+func buildLabelTree(labels []*gwcli.Label) ([]*labelTreeNode, map[string]*labelTreeNode) {
+	nodes := map[string]*labelTreeNode{}
+	var roots []*labelTreeNode
+	var node func(path string) *labelTreeNode
+	node = func(path string) *labelTreeNode {
+		if n, ok := nodes[strings.ToLower(path)]; ok {
+			return n
+		}
+		n := &labelTreeNode{Name: path, Path: path}
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			n.Name = path[i+1:]
+			parent := node(path[:i])
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+		nodes[strings.ToLower(path)] = n
+		return n
+	}
 	for _, l := range labels {
-		if strings.EqualFold(l.Label, labelID) || l.ID == labelID {
-			resolvedID = l.ID
-			found = true
-			out.writeVerbose("Resolved label '%s' to ID '%s'", labelID, resolvedID)
-			break
+		node(l.Label).ID = l.ID
+	}
+
+	var sortNodes func([]*labelTreeNode)
+	sortNodes = func(ns []*labelTreeNode) {
+		sort.Slice(ns, func(i, j int) bool {
+			return strings.ToLower(ns[i].Name) < strings.ToLower(ns[j].Name)
+		})
+		for _, n := range ns {
+			sortNodes(n.Children)
 		}
 	}
+	sortNodes(roots)
+	return roots, nodes
+}
 
-	if !found {
-		out.writeVerbose("Label '%s' not found. Available labels:", labelID)
-		for _, l := range labels {
-			out.writeVerbose("  - %s (ID: %s)", l.Label, l.ID)
+// rollUp fills in Total for n and its descendants.
+func (n *labelTreeNode) rollUp(counts map[string]labelCounts) {
+	n.labelCounts = counts[n.ID]
+	n.Total = n.labelCounts
+	for _, c := range n.Children {
+		c.rollUp(counts)
+		n.Total.add(c.Total)
+	}
+}
+
+// ids returns the label IDs of n and its descendants.
+func (n *labelTreeNode) ids() []string {
+	var ret []string
+	if n.ID != "" {
+		ret = append(ret, n.ID)
+	}
+	for _, c := range n.Children {
+		ret = append(ret, c.ids()...)
+	}
+	return ret
+}
+
+// runLabelsTree shows user labels as a hierarchy with rolled-up counts,
+// optionally only the subtree under root.
+func runLabelsTree(ctx context.Context, conn *gwcli.CmdG, root string, out *outputWriter) error {
+	labels, err := selectLabels(ctx, conn, nil, false, true)
+	if err != nil {
+		return err
+	}
+	roots, nodes := buildLabelTree(labels)
+	if root != "" {
+		n, ok := nodes[strings.ToLower(strings.TrimSuffix(root, "/"))]
+		if !ok {
+			// Allow the root to be given by ID as well.
+			l, err := findLabel(ctx, conn, root)
+			if err != nil {
+				return err
+			}
+			if n, ok = nodes[strings.ToLower(l.Label)]; !ok {
+				return fmt.Errorf("%s is a system label and has no sub-labels", l.Label)
+			}
 		}
-		fmt.Fprintf(os.Stderr, "Warning: label '%s' not found\n", labelID)
+		roots = []*labelTreeNode{n}
+	}
+	if len(roots) == 0 {
+		return out.WriteEmptyList("No user labels found")
 	}
 
-	// Batch operation
-	bp := newBatchProcessor(len(ids), verbose)
-	err = bp.process(ctx, ids, func(ctx context.Context, id string) error {
-		return conn.BatchUnlabel(ctx, []string{id}, resolvedID)
-	})
+	// Only fetch counts for the labels shown.
+	want := map[string]bool{}
+	for _, n := range roots {
+		for _, id := range n.ids() {
+			want[id] = true
+		}
+	}
+	var shown []*gwcli.Label
+	for _, l := range labels {
+		if want[l.ID] {
+			shown = append(shown, l)
+		}
+	}
+	counts, err := loadLabelCounts(ctx, conn, shown, out)
+	if err != nil {
+		return err
+	}
+	for _, n := range roots {
+		n.rollUp(counts)
+	}
 
 	if out.json {
-		return out.writeJSON(map[string]int{
-			"removed": bp.processed - len(bp.errors),
-			"errors":  len(bp.errors),
-		})
+		return out.writeJSON(roots)
 	}
 
-	if len(ids) == 1 {
-		out.writeMessage("Label removed")
-	} else {
-		bp.report(os.Stdout)
+	headers := []string{"LABEL", "MESSAGES", "UNREAD", "TOTAL", "TOTAL UNREAD"}
+	var rows [][]string
+	var walk func(n *labelTreeNode, depth int)
+	walk = func(n *labelTreeNode, depth int) {
+		name := strings.Repeat("  ", depth) + n.Name
+		if depth == 0 {
+			name = n.Path
+		}
+		rows = append(rows, []string{
+			name,
+			fmt.Sprint(n.MessagesTotal),
+			fmt.Sprint(n.MessagesUnread),
+			fmt.Sprint(n.Total.MessagesTotal),
+			fmt.Sprint(n.Total.MessagesUnread),
+		})
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	for _, n := range roots {
+		walk(n, 0)
 	}
-	return err
+	return out.writeTable(headers, rows)
 }
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// fakeLabelAPI is an in-memory Gmail labels API with a fixed set of
+// messages per label.
+type fakeLabelAPI struct {
+	t        *testing.T
+	labels   map[string]*gmail.Label
+	messages map[string][]string // label ID -> message IDs
+	nextID   int
+	counts   map[string][2]int64 // label ID -> messages total, unread
+	modified []gmail.BatchModifyMessagesRequest
+	deleted  []string
+	queries  []string // messages.list queries
+	failName string   // rename to this name fails
+}
+
+func newFakeLabelAPI(t *testing.T, names ...string) *fakeLabelAPI {
+	f := &fakeLabelAPI{t: t, labels: map[string]*gmail.Label{}, messages: map[string][]string{}, counts: map[string][2]int64{}}
+	for _, n := range names {
+		f.add(n)
+	}
+	return f
+}
+
+func (f *fakeLabelAPI) add(name string) *gmail.Label {
+	f.nextID++
+	l := &gmail.Label{Id: fmt.Sprintf("Label_%d", f.nextID), Name: name, Type: "user"}
+	f.labels[l.Id] = l
+	return l
+}
+
+func (f *fakeLabelAPI) names() []string {
+	var ret []string
+	for _, l := range f.labels {
+		ret = append(ret, l.Name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (f *fakeLabelAPI) conn() *gwcli.CmdG {
+	return newFakeGmail(f.t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "labels" && req.Method == http.MethodGet:
+			var ls []*gmail.Label
+			for _, l := range f.labels {
+				ls = append(ls, l)
+			}
+			return gmail.ListLabelsResponse{Labels: ls}
+		case path == "labels" && req.Method == http.MethodPost:
+			var l gmail.Label
+			decodeRequest(f.t, req, &l)
+			created := f.add(l.Name)
+			created.Color = l.Color
+			return created
+		case strings.HasPrefix(path, "labels/") && req.Method == http.MethodGet:
+			l := *f.labels[strings.TrimPrefix(path, "labels/")]
+			c := f.counts[l.Id]
+			l.MessagesTotal, l.MessagesUnread = c[0], c[1]
+			l.ThreadsTotal, l.ThreadsUnread = c[0], c[1]
+			return l
+		case strings.HasPrefix(path, "labels/") && req.Method == http.MethodPatch:
+			var patch gmail.Label
+			decodeRequest(f.t, req, &patch)
+			if patch.Name != "" && patch.Name == f.failName {
+				return fakeError{code: http.StatusBadRequest, message: "Invalid label name"}
+			}
+			l := f.labels[strings.TrimPrefix(path, "labels/")]
+			if patch.Name != "" {
+				l.Name = patch.Name
+			}
+			if patch.Color != nil {
+				l.Color = patch.Color
+			}
+			return l
+		case strings.HasPrefix(path, "labels/") && req.Method == http.MethodDelete:
+			id := strings.TrimPrefix(path, "labels/")
+			delete(f.labels, id)
+			f.deleted = append(f.deleted, id)
+			return "{}"
+		case path == "messages" && req.Method == http.MethodGet:
+			f.queries = append(f.queries, req.URL.Query().Get("q"))
+			return messageList(f.messages[req.URL.Query().Get("labelIds")]...)
+		case path == "messages/batchModify":
+			var r gmail.BatchModifyMessagesRequest
+			decodeRequest(f.t, req, &r)
+			f.modified = append(f.modified, r)
+			return "{}"
+		}
+		return nil
+	})
+}
+
+func TestRunLabelsApply_UnknownLabel(t *testing.T) {
+	api := newFakeLabelAPI(t, "Work")
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runLabelsApply(context.Background(), api.conn(), "Wrok", "m1", bulkFlags{}, false, out)
+	if err == nil || !strings.Contains(err.Error(), `label "Wrok" not found`) {
+		t.Errorf("error = %v", err)
+	}
+	if len(api.modified) != 0 {
+		t.Errorf("messages were modified: %+v", api.modified)
+	}
+}
+
+func TestRunLabelsCreate_CreatesMissingParents(t *testing.T) {
+	api := newFakeLabelAPI(t, "Clients")
+	conn := api.conn()
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runLabelsCreate(context.Background(), conn, "Acme/Invoices", "Clients", "#fb4c2f", "#ffffff", false, out); err != nil {
+		t.Fatalf("runLabelsCreate() error = %v", err)
+	}
+	want := []string{"Clients", "Clients/Acme", "Clients/Acme/Invoices"}
+	if got := api.names(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+	var got labelListOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if got.Name != "Clients/Acme/Invoices" || got.Color != "#fb4c2f" || got.TextColor != "#ffffff" {
+		t.Errorf("output = %+v", got)
+	}
+
+	// The label cache was refreshed, so the new label resolves.
+	if _, err := findLabel(context.Background(), conn, "clients/acme/invoices"); err != nil {
+		t.Errorf("new label not in cache: %v", err)
+	}
+}
+
+func TestRunLabelsCreate_InvalidColor(t *testing.T) {
+	api := newFakeLabelAPI(t)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runLabelsCreate(context.Background(), api.conn(), "Todo", "", "#123456", "#000000", false, out)
+	if err == nil || !strings.Contains(err.Error(), "not a Gmail label color") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestLabelColor_FullPalette(t *testing.T) {
+	// Palette colors gwcli cannot render in a terminal are still valid.
+	for _, c := range []string{"#fb4c2f", "#B6CFF5", "#0d3472", "#ff7537"} {
+		if _, err := labelColor(c, "#000000"); err != nil {
+			t.Errorf("labelColor(%q) error = %v", c, err)
+		}
+	}
+	if _, err := labelColor("#123456", "#000000"); err == nil {
+		t.Error("expected #123456 to be rejected")
+	}
+}
+
+func TestRunLabelsRename_MovesSubLabels(t *testing.T) {
+	api := newFakeLabelAPI(t, "Work", "Work/Projects", "Work/Projects/X", "Workshop")
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runLabelsRename(context.Background(), api.conn(), "Work", "Job", out); err != nil {
+		t.Fatalf("runLabelsRename() error = %v", err)
+	}
+	want := []string{"Job", "Job/Projects", "Job/Projects/X", "Workshop"}
+	if got := api.names(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+}
+
+func TestRunLabelsRename_PartialFailure(t *testing.T) {
+	api := newFakeLabelAPI(t, "Work", "Work/A", "Work/B")
+	api.failName = "Job/A"
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	err := runLabelsRename(context.Background(), api.conn(), "Work", "Job", out)
+	if err == nil {
+		t.Fatal("runLabelsRename() succeeded, want an error")
+	}
+	for _, want := range []string{"failed to rename label Work/A", "renamed: Work -> Job;", "not renamed: Work/A, Work/B)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestRunLabelsMerge(t *testing.T) {
+	api := newFakeLabelAPI(t, "Receipts", "Invoices")
+	api.messages["Label_1"] = []string{"m1", "m2"}
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runLabelsMerge(context.Background(), api.conn(), "Receipts", "invoices", true, out); err != nil {
+		t.Fatalf("runLabelsMerge() error = %v", err)
+	}
+	if len(api.queries) != 1 || api.queries[0] != "in:anywhere" {
+		t.Errorf("list queries = %q, want spam and trash included", api.queries)
+	}
+	if len(api.modified) != 1 {
+		t.Fatalf("expected 1 batchModify call, got %d", len(api.modified))
+	}
+	m := api.modified[0]
+	if strings.Join(m.Ids, ",") != "m1,m2" || m.AddLabelIds[0] != "Label_2" || m.RemoveLabelIds[0] != "Label_1" {
+		t.Errorf("batchModify = %+v", m)
+	}
+	if len(api.deleted) != 1 || api.deleted[0] != "Label_1" {
+		t.Errorf("deleted = %v, want [Label_1]", api.deleted)
+	}
+}
+
+func TestRunLabelsMerge_RequiresForce(t *testing.T) {
+	api := newFakeLabelAPI(t, "Receipts", "Invoices")
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	err := runLabelsMerge(context.Background(), api.conn(), "Receipts", "Invoices", false, out)
+	if err == nil || !strings.Contains(err.Error(), "without --force") {
+		t.Errorf("error = %v, want a --force error", err)
+	}
+	if len(api.labels) != 2 || len(api.modified) != 0 {
+		t.Errorf("labels changed without --force: %v, %d modifications", api.names(), len(api.modified))
+	}
+}
+
+func TestRunLabelsDelete_SystemLabel(t *testing.T) {
+	api := newFakeLabelAPI(t)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runLabelsDelete(context.Background(), api.conn(), "INBOX", true, out)
+	if err == nil || !strings.Contains(err.Error(), "system label") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestRunLabelsStats_Prefix(t *testing.T) {
+	api := newFakeLabelAPI(t, "support", "support/billing", "support/bugs", "sales")
+	api.counts["Label_2"] = [2]int64{10, 3}
+	api.counts["Label_3"] = [2]int64{4, 1}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runLabelsStats(context.Background(), api.conn(), []string{"support/*"}, false, false, out); err != nil {
+		t.Fatalf("runLabelsStats() error = %v", err)
+	}
+	var got []labelStatsOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d labels, want support/billing and support/bugs: %+v", len(got), got)
+	}
+	for _, s := range got {
+		want := api.counts[s.ID]
+		if s.MessagesTotal != want[0] || s.MessagesUnread != want[1] || s.ThreadsTotal != want[0] {
+			t.Errorf("%s counts = %+v, want %v", s.Name, s.labelCounts, want)
+		}
+	}
+}
+
+func TestRunLabelsTree_RollsUpCounts(t *testing.T) {
+	// "clients" does not exist as a label itself.
+	api := newFakeLabelAPI(t, "support", "support/billing", "support/billing/refunds", "clients/acme")
+	api.counts["Label_1"] = [2]int64{1, 1}
+	api.counts["Label_2"] = [2]int64{10, 3}
+	api.counts["Label_3"] = [2]int64{5, 2}
+	api.counts["Label_4"] = [2]int64{7, 0}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	if err := runLabelsTree(context.Background(), api.conn(), "", out); err != nil {
+		t.Fatalf("runLabelsTree() error = %v", err)
+	}
+	var roots []labelTreeNode
+	if err := json.Unmarshal(buf.Bytes(), &roots); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(roots) != 2 || roots[0].Name != "clients" || roots[1].Name != "support" {
+		t.Fatalf("roots = %+v", roots)
+	}
+	clients, support := roots[0], roots[1]
+	if clients.ID != "" || clients.Total.MessagesTotal != 7 {
+		t.Errorf("clients = %+v", clients)
+	}
+	if support.MessagesTotal != 1 || support.Total.MessagesTotal != 16 || support.Total.MessagesUnread != 6 {
+		t.Errorf("support = %+v", support)
+	}
+	billing := support.Children[0]
+	if billing.Path != "support/billing" || billing.Total.MessagesTotal != 15 || len(billing.Children) != 1 {
+		t.Errorf("billing = %+v", billing)
+	}
+}
+
+func TestRunLabelsTree_Root(t *testing.T) {
+	api := newFakeLabelAPI(t, "support", "support/billing", "sales")
+	var buf bytes.Buffer
+	out := &outputWriter{writer: &buf}
+
+	if err := runLabelsTree(context.Background(), api.conn(), "Support", out); err != nil {
+		t.Fatalf("runLabelsTree() error = %v", err)
+	}
+	got := buf.String()
+	if !strings.Contains(got, "\n  billing ") || strings.Contains(got, "sales") {
+		t.Errorf("unexpected tree:\n%s", got)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 )
@@ -64,8 +65,10 @@ type CLI struct {
 
 	VersionFlag kong.VersionFlag `name:"version" short:"V" help:"Print version and exit"`
 
-	Configure struct{} `cmd:"" help:"Configure OAuth authentication"`
-	Version   struct{} `cmd:"" help:"Show version"`
+	Configure struct {
+		AllowPurge bool `help:"Also grant full mailbox access (https://mail.google.com/), needed only by trash empty" name:"allow-purge"`
+	} `cmd:"" help:"Configure OAuth authentication"`
+	Version struct{} `cmd:"" help:"Show version"`
 
 	Auth struct {
 		TokenInfo struct{} `cmd:"" aliases:"token-info" help:"Show OAuth token information and scopes"`
@@ -76,66 +79,227 @@ type CLI struct {
 			Label      string `help:"Label to list" default:"INBOX"`
 			Limit      int    `help:"Max messages" default:"50"`
 			UnreadOnly bool   `help:"Unread only" name:"unread-only"`
+			PageToken  string `help:"Resume from a nextPageToken returned by a previous run" name:"page-token"`
 		} `cmd:"" help:"List messages"`
 
 		Read struct {
-			MessageID   string `arg:"" required:"" help:"Message ID"`
-			Raw         bool   `help:"Output RFC822 format"`
-			HeadersOnly bool   `help:"Show headers only" name:"headers-only"`
-			RawHTML     bool   `help:"Output raw HTML with HTML-formatted metadata" name:"raw-html"`
-			PreferPlain bool   `help:"Prefer plain text body over HTML" name:"prefer-plain"`
+			MessageID     string `arg:"" required:"" help:"Message ID"`
+			Raw           bool   `help:"Output RFC822 format"`
+			HeadersOnly   bool   `help:"Show headers only" name:"headers-only"`
+			RawHTML       bool   `help:"Output raw HTML with HTML-formatted metadata" name:"raw-html"`
+			PreferPlain   bool   `help:"Prefer plain text body over HTML" name:"prefer-plain"`
+			RequireSigned bool   `help:"Exit non-zero unless the message has a good PGP/MIME or S/MIME signature from a trusted key matching From" name:"require-signed"`
 		} `cmd:"" help:"Read message"`
 
 		Search struct {
-			Query string `arg:"" required:"" help:"Gmail search query"`
-			Limit int    `help:"Max results" default:"100"`
+			Query     string `arg:"" required:"" help:"Gmail search query"`
+			Limit     int    `help:"Max results" default:"100"`
+			PageToken string `help:"Resume from a nextPageToken returned by a previous run" name:"page-token"`
 		} `cmd:"" help:"Search messages"`
 
 		Send struct {
-			To       []string `required:"" help:"Recipients"`
-			Subject  string   `required:"" help:"Subject line"`
-			Body     string   `help:"Message body (or read from stdin)"`
-			Cc       []string `help:"CC recipients"`
-			Bcc      []string `help:"BCC recipients"`
-			Attach   []string `help:"File attachments" type:"existingfile"`
-			HTML     bool     `help:"Send as HTML"`
-			ThreadID string   `help:"Reply to thread" name:"thread-id"`
+			To       []string    `required:"" help:"Recipients"`
+			Subject  string      `required:"" help:"Subject line"`
+			Body     string      `help:"Message body (or read from stdin)"`
+			Cc       []string    `help:"CC recipients"`
+			Bcc      []string    `help:"BCC recipients"`
+			Attach   []string    `help:"File attachments" type:"existingfile"`
+			Inline   []string    `help:"Files to embed in the HTML body, referenced as cid:<file name>.<N>@gwcli for the N-th --inline file" type:"existingfile"`
+			HTML     bool        `help:"Send as HTML"`
+			Markdown bool        `help:"Render the markdown body to HTML (the markdown is kept as the plain-text part)"`
+			Sign     bool        `help:"Sign with the OpenPGP key of --from, or your default key (PGP/MIME)"`
+			Encrypt  bool        `help:"Encrypt to the OpenPGP keys of all recipients (PGP/MIME)"`
+			ThreadID string      `help:"Reply to thread" name:"thread-id"`
+			Sender   senderFlags `embed:""`
 		} `cmd:"" help:"Send email"`
 
 		Draft struct {
-			To       []string `help:"Recipients"`
-			Subject  string   `help:"Subject line"`
-			Body     string   `help:"Message body (or read from stdin)"`
-			Cc       []string `help:"CC recipients"`
-			Bcc      []string `help:"BCC recipients"`
-			Attach   []string `help:"File attachments" type:"existingfile"`
-			HTML     bool     `help:"Compose as HTML"`
-			ThreadID string   `help:"Associate with thread" name:"thread-id"`
+			To       []string    `help:"Recipients"`
+			Subject  string      `help:"Subject line"`
+			Body     string      `help:"Message body (or read from stdin)"`
+			Cc       []string    `help:"CC recipients"`
+			Bcc      []string    `help:"BCC recipients"`
+			Attach   []string    `help:"File attachments" type:"existingfile"`
+			Inline   []string    `help:"Files to embed in the HTML body, referenced as cid:<file name>.<N>@gwcli for the N-th --inline file" type:"existingfile"`
+			HTML     bool        `help:"Compose as HTML"`
+			Markdown bool        `help:"Render the markdown body to HTML (the markdown is kept as the plain-text part)"`
+			Sign     bool        `help:"Sign with the OpenPGP key of --from, or your default key (PGP/MIME)"`
+			Encrypt  bool        `help:"Encrypt to the OpenPGP keys of all recipients (PGP/MIME)"`
+			ThreadID string      `help:"Associate with thread" name:"thread-id"`
+			Sender   senderFlags `embed:""`
 		} `cmd:"" help:"Create a draft email"`
 
+		Reply struct {
+			MessageID string      `arg:"" required:"" help:"Message ID to reply to"`
+			Body      string      `help:"Reply body (or read from stdin)"`
+			Cc        []string    `help:"Additional CC recipients"`
+			Bcc       []string    `help:"BCC recipients"`
+			Attach    []string    `help:"File attachments" type:"existingfile"`
+			HTML      bool        `help:"Compose as HTML"`
+			Sender    senderFlags `embed:""`
+		} `cmd:"" help:"Reply to the sender of a message"`
+
+		ReplyAll struct {
+			MessageID string      `arg:"" required:"" help:"Message ID to reply to"`
+			Body      string      `help:"Reply body (or read from stdin)"`
+			Cc        []string    `help:"Additional CC recipients"`
+			Bcc       []string    `help:"BCC recipients"`
+			Attach    []string    `help:"File attachments" type:"existingfile"`
+			HTML      bool        `help:"Compose as HTML"`
+			Sender    senderFlags `embed:""`
+		} `cmd:"" help:"Reply to the sender and all recipients of a message"`
+
+		Forward struct {
+			MessageID string      `arg:"" required:"" help:"Message ID to forward"`
+			To        []string    `required:"" help:"Recipients"`
+			Cc        []string    `help:"CC recipients"`
+			Bcc       []string    `help:"BCC recipients"`
+			Body      string      `help:"Note to include above the forwarded message"`
+			Attach    []string    `help:"Additional file attachments" type:"existingfile"`
+			Sender    senderFlags `embed:""`
+		} `cmd:"" help:"Forward a message with its attachments"`
+
+		Watch struct {
+			Label    string        `help:"Only watch changes involving this label (name or ID)"`
+			Exec     []string      `help:"Shell command to run per event, with the event JSON on stdin (repeatable)"`
+			Interval time.Duration `help:"Polling interval" default:"30s"`
+			State    string        `help:"Checkpoint file (default: watch-state.json in the config dir)" type:"path"`
+			Once     bool          `help:"Poll once and exit"`
+		} `cmd:"" help:"Stream message changes as NDJSON using the history API"`
+
+		Export struct {
+			Query  string `required:"" help:"Gmail search query selecting messages to export"`
+			Format string `help:"Output format (mbox or maildir)" enum:"mbox,maildir" default:"mbox"`
+			Out    string `required:"" help:"mbox file or Maildir directory to write" type:"path"`
+			Limit  int    `help:"Max messages (0 = all)" default:"0"`
+		} `cmd:"" help:"Export messages to mbox or Maildir (incremental)"`
+
+		Import struct {
+			Files           []string `arg:"" required:"" help:".eml files or mbox archives" type:"existingfile"`
+			Label           []string `help:"Label to apply to imported messages (repeatable)"`
+			MarkRead        bool     `help:"Import as read" name:"mark-read"`
+			KeepDate        bool     `help:"Use the Date header as the message date instead of the import time" name:"keep-date"`
+			Insert          bool     `help:"Use insert instead of import (no spam scanning or filters)"`
+			AllowDuplicates bool     `help:"Import even if a message with the same Message-ID exists" name:"allow-duplicates"`
+		} `cmd:"" help:"Import .eml files or mbox archives"`
+
+		Merge struct {
+			Template string        `required:"" help:"Template: YAML frontmatter (to, cc, bcc, subject, attach) and a body, rendered per row with Go text/template" type:"existingfile"`
+			Data     string        `required:"" help:"Rows as CSV with a header line, or a JSON array of objects" type:"existingfile"`
+			Log      string        `help:"Result log used to resume (default: <data>.merge-log.jsonl)" type:"path"`
+			HTML     bool          `help:"Body is HTML"`
+			Markdown bool          `help:"Render the markdown body to HTML"`
+			Draft    bool          `help:"Create drafts instead of sending"`
+			DryRun   bool          `help:"Print the rendered messages without sending" name:"dry-run"`
+			Delay    time.Duration `help:"Wait between messages to stay under sending limits" default:"1s"`
+			Sender   senderFlags   `embed:""`
+		} `cmd:"" help:"Send templated messages to every row of a CSV or JSON file"`
+
 		Delete struct {
-			MessageID string `arg:"" optional:"" help:"Message ID"`
-			Stdin     bool   `help:"Read IDs from stdin"`
-			Force     bool   `required:"" help:"Confirm deletion"`
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Force     bool      `help:"Confirm deletion (required unless --dry-run)"`
+			Bulk      bulkFlags `embed:""`
 		} `cmd:"" help:"Delete messages"`
 
 		MarkRead struct {
-			MessageID string `arg:"" optional:"" help:"Message ID"`
-			Stdin     bool   `help:"Read IDs from stdin"`
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
 		} `cmd:"" aliases:"mark-read" help:"Mark as read"`
 
 		MarkUnread struct {
-			MessageID string `arg:"" optional:"" help:"Message ID"`
-			Stdin     bool   `help:"Read IDs from stdin"`
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
 		} `cmd:"" aliases:"mark-unread" help:"Mark as unread"`
 
 		Move struct {
-			MessageID string `arg:"" optional:"" help:"Message ID"`
-			To        string `required:"" help:"Destination label"`
-			Stdin     bool   `help:"Read IDs from stdin"`
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			To        string    `required:"" help:"Destination label"`
+			Bulk      bulkFlags `embed:""`
 		} `cmd:"" help:"Move to label"`
+
+		Trash struct {
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
+		} `cmd:"" help:"Move to trash"`
+
+		Untrash struct {
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
+		} `cmd:"" help:"Restore from trash"`
+
+		Spam struct {
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
+		} `cmd:"" help:"Report as spam"`
+
+		NotSpam struct {
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
+		} `cmd:"" name:"not-spam" help:"Move from spam to inbox"`
+
+		Archive struct {
+			MessageID string    `arg:"" optional:"" help:"Message ID"`
+			Bulk      bulkFlags `embed:""`
+		} `cmd:"" help:"Archive (remove from inbox)"`
+
+		Unsubscribe messagesUnsubscribeCmd `cmd:"" help:"Unsubscribe from mailing lists via List-Unsubscribe"`
+
+		Stats messagesStatsCmd `cmd:"" help:"Count messages, sizes and unread ratios by sender, domain, label or date"`
 	} `cmd:"" help:"Message operations"`
 
+	Trash struct {
+		Empty struct {
+			OlderThan string `help:"Only messages older than this (e.g. 30d, 6m, 1y)" name:"older-than"`
+			Force     bool   `help:"Confirm permanent deletion (required unless --dry-run)"`
+			DryRun    bool   `help:"Show how many messages would be deleted, and a sample" name:"dry-run"`
+		} `cmd:"" help:"Permanently delete messages in the trash"`
+	} `cmd:"" help:"Trash operations"`
+
+	Threads struct {
+		List struct {
+			Label     string `help:"Label to list (name or ID)"`
+			Query     string `help:"Gmail search query"`
+			Limit     int    `help:"Max threads (0 = all)" default:"50"`
+			PageToken string `help:"Resume from a nextPageToken returned by a previous run" name:"page-token"`
+		} `cmd:"" help:"List threads"`
+
+		Read struct {
+			ThreadID    string `arg:"" required:"" help:"Thread ID"`
+			RawHTML     bool   `help:"Output raw HTML with HTML-formatted metadata" name:"raw-html"`
+			PreferPlain bool   `help:"Prefer plain text body over HTML" name:"prefer-plain"`
+		} `cmd:"" help:"Read every message in a thread"`
+
+		Modify struct {
+			ThreadID    string   `arg:"" required:"" help:"Thread ID"`
+			AddLabel    []string `help:"Label to add (repeatable)" name:"add-label"`
+			RemoveLabel []string `help:"Label to remove (repeatable)" name:"remove-label"`
+			Archive     bool     `help:"Archive the thread (remove INBOX)"`
+			MarkRead    bool     `help:"Mark all messages read" name:"mark-read"`
+			MarkUnread  bool     `help:"Mark all messages unread" name:"mark-unread"`
+			Trash       bool     `help:"Move the thread to trash"`
+		} `cmd:"" help:"Modify labels on a whole thread"`
+	} `cmd:"" help:"Thread operations"`
+
+	Drafts struct {
+		List struct{} `cmd:"" help:"List drafts"`
+
+		Read struct {
+			DraftID     string `arg:"" name:"draft-id" help:"Draft ID"`
+			PreferPlain bool   `help:"Prefer plain text body over HTML" name:"prefer-plain"`
+		} `cmd:"" help:"Read a draft"`
+
+		Update draftsUpdateCmd `cmd:"" help:"Update a draft (unset fields and existing attachments are kept)"`
+
+		Send struct {
+			DraftID string `arg:"" name:"draft-id" help:"Draft ID"`
+		} `cmd:"" help:"Send a draft"`
+
+		Delete struct {
+			DraftID string `arg:"" name:"draft-id" help:"Draft ID"`
+			Force   bool   `name:"force" short:"f" help:"Skip confirmation"`
+		} `cmd:"" help:"Delete a draft"`
+	} `cmd:"" help:"Draft operations"`
+
 	Labels struct {
 		List struct {
 			System   bool `help:"System labels only"`
@@ -143,16 +307,58 @@ type CLI struct {
 		} `cmd:"" help:"List labels"`
 
 		Apply struct {
-			LabelID   string `arg:"" required:"" help:"Label ID or name"`
-			MessageID string `help:"Message ID" name:"message"`
-			Stdin     bool   `help:"Read IDs from stdin"`
+			LabelID   string    `arg:"" required:"" help:"Label ID or name"`
+			MessageID string    `help:"Message ID" name:"message"`
+			Bulk      bulkFlags `embed:""`
 		} `cmd:"" help:"Apply label to messages"`
 
 		Remove struct {
-			LabelID   string `arg:"" required:"" help:"Label ID or name"`
-			MessageID string `help:"Message ID" name:"message"`
-			Stdin     bool   `help:"Read IDs from stdin"`
+			LabelID   string    `arg:"" required:"" help:"Label ID or name"`
+			MessageID string    `help:"Message ID" name:"message"`
+			Bulk      bulkFlags `embed:""`
 		} `cmd:"" help:"Remove label from messages"`
+
+		Create struct {
+			Name   string `arg:"" required:"" help:"Label name (use Parent/Child to nest)"`
+			Parent string `help:"Create under this label (name or ID)"`
+			Bg     string `help:"Background color from the Gmail palette (see 'labels color --palette')"`
+			Fg     string `help:"Text color from the Gmail palette" default:"#000000"`
+			Hide   bool   `help:"Hide the label from the label list"`
+		} `cmd:"" help:"Create a label (missing parent labels are created too)"`
+
+		Rename struct {
+			Label   string `arg:"" required:"" help:"Label name or ID"`
+			NewName string `arg:"" required:"" name:"new-name" help:"New label name"`
+		} `cmd:"" help:"Rename a label and its sub-labels"`
+
+		Delete struct {
+			Label string `arg:"" required:"" help:"Label name or ID"`
+			Force bool   `name:"force" short:"f" help:"Skip confirmation"`
+		} `cmd:"" help:"Delete a label (messages are kept)"`
+
+		Color struct {
+			Label   string `arg:"" optional:"" help:"Label name or ID"`
+			Bg      string `help:"Background color from the Gmail palette"`
+			Fg      string `help:"Text color from the Gmail palette" default:"#000000"`
+			Clear   bool   `help:"Reset to the default color"`
+			Palette bool   `help:"List the Gmail label palette"`
+		} `cmd:"" help:"Set a label's color"`
+
+		Merge struct {
+			Source      string `arg:"" required:"" help:"Label to merge and delete (name or ID)"`
+			Destination string `arg:"" required:"" help:"Label to move messages to (name or ID)"`
+			Force       bool   `name:"force" short:"f" help:"Skip confirmation"`
+		} `cmd:"" help:"Move all messages from one label to another, then delete the first"`
+
+		Stats struct {
+			Labels   []string `arg:"" optional:"" help:"Label names or IDs; Parent/* for all labels below Parent (default: all)"`
+			System   bool     `help:"System labels only"`
+			UserOnly bool     `help:"User labels only" name:"user-only"`
+		} `cmd:"" help:"Show message and thread counts per label"`
+
+		Tree struct {
+			Root string `arg:"" optional:"" help:"Only show this label and its sub-labels"`
+		} `cmd:"" help:"Show nested labels as a tree with rolled-up counts"`
 	} `cmd:"" help:"Label operations"`
 
 	Attachments struct {
@@ -283,8 +489,60 @@ type CLI struct {
 			FilterID string `arg:"" name:"filter-id" help:"ID of the filter to delete"`
 			Force    bool   `name:"force" short:"f" help:"Skip confirmation"`
 		} `cmd:"" help:"Delete a filter"`
+
+		Export struct {
+			Format string `help:"Output format (xml is the Gmail web UI's mailFilters.xml)" enum:"xml,json,yaml" default:"xml"`
+			Out    string `help:"File to write (default: stdout)" type:"path"`
+		} `cmd:"" help:"Export all filters"`
+
+		Import struct {
+			File string `arg:"" help:"mailFilters.xml, JSON or YAML file to import (- for stdin)"`
+		} `cmd:"" help:"Create the filters in a file, creating missing labels"`
+
+		Plan struct {
+			File  string `short:"f" required:"" help:"Filter file (mailFilters.xml, JSON or YAML)"`
+			Prune bool   `help:"Plan to delete filters not in the file"`
+		} `cmd:"" help:"Show the filter creates and deletes needed to match a file"`
+
+		Apply struct {
+			File  string `short:"f" required:"" help:"Filter file (mailFilters.xml, JSON or YAML)"`
+			Prune bool   `help:"Also delete filters not in the file (requires --force)"`
+			Force bool   `help:"Confirm deleting filters with --prune"`
+		} `cmd:"" help:"Create (and with --prune delete) filters to match a file"`
+
+		Test filtersTestCmd `cmd:"" help:"List existing messages a filter matches"`
+
+		Run filtersRunCmd `cmd:"" help:"Apply a filter's actions to existing messages"`
 	} `cmd:"" help:"Manage Gmail filters"`
 
+	Settings struct {
+		Vacation struct {
+			Get struct{}               `cmd:"" help:"Show the vacation responder"`
+			Set settingsVacationSetCmd `cmd:"" help:"Turn on the vacation responder (replaces previous settings)"`
+			Off struct{}               `cmd:"" help:"Turn off the vacation responder"`
+		} `cmd:"" help:"Vacation responder (out of office)"`
+
+		Sendas struct {
+			List struct{} `cmd:"" help:"List send-as addresses"`
+
+			Get struct {
+				Email string `arg:"" name:"email" help:"Send-as address"`
+			} `cmd:"" help:"Show a send-as address and its signature"`
+
+			Update settingsSendAsUpdateCmd `cmd:"" help:"Update a send-as address (unset fields are kept)"`
+		} `cmd:"" name:"sendas" help:"Send-as addresses and signatures"`
+
+		Imap struct {
+			Get struct{}           `cmd:"" help:"Show IMAP settings"`
+			Set settingsImapSetCmd `cmd:"" help:"Update IMAP settings (unset fields are kept)"`
+		} `cmd:"" help:"IMAP access settings"`
+
+		Pop struct {
+			Get struct{}          `cmd:"" help:"Show POP settings"`
+			Set settingsPopSetCmd `cmd:"" help:"Update POP settings (unset fields are kept)"`
+		} `cmd:"" help:"POP access settings"`
+	} `cmd:"" help:"Manage Gmail settings"`
+
 	Tasklists struct {
 		List struct{} `cmd:"" help:"List all task lists"`
 
@@ -430,7 +688,7 @@ func main() {
 
 	switch ctx.Command() {
 	case "configure":
-		if err := runConfigure(cli.Config); err != nil {
+		if err := runConfigure(cli.Config, cli.Configure.AllowPurge); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(3)
 		}
@@ -459,7 +717,7 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesList(cmdCtx, conn, cli.Messages.List.Label, cli.Messages.List.Limit, cli.Messages.List.UnreadOnly, out); err != nil {
+		if err := runMessagesList(cmdCtx, conn, cli.Messages.List.Label, cli.Messages.List.Limit, cli.Messages.List.UnreadOnly, cli.Messages.List.PageToken, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
@@ -472,7 +730,7 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesRead(cmdCtx, conn, cli.Messages.Read.MessageID, cli.Messages.Read.Raw, cli.Messages.Read.HeadersOnly, cli.Messages.Read.RawHTML, cli.Messages.Read.PreferPlain, out); err != nil {
+		if err := runMessagesRead(cmdCtx, conn, cli.Messages.Read.MessageID, cli.Messages.Read.Raw, cli.Messages.Read.HeadersOnly, cli.Messages.Read.RawHTML, cli.Messages.Read.PreferPlain, cli.Messages.Read.RequireSigned, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
@@ -485,7 +743,7 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesSearch(cmdCtx, conn, cli.Messages.Search.Query, cli.Messages.Search.Limit, out); err != nil {
+		if err := runMessagesSearch(cmdCtx, conn, cli.Messages.Search.Query, cli.Messages.Search.Limit, cli.Messages.Search.PageToken, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
@@ -500,7 +758,14 @@ func main() {
 
 		if err := runMessagesSend(cmdCtx, conn, cli.Messages.Send.To, cli.Messages.Send.Cc, cli.Messages.Send.Bcc,
 			cli.Messages.Send.Subject, cli.Messages.Send.Body, cli.Messages.Send.Attach,
-			cli.Messages.Send.HTML, cli.Messages.Send.ThreadID, out); err != nil {
+			cli.Messages.Send.Inline, cli.Messages.Send.ThreadID,
+			composeOptions{
+				html:     cli.Messages.Send.HTML,
+				markdown: cli.Messages.Send.Markdown,
+				sign:     cli.Messages.Send.Sign,
+				encrypt:  cli.Messages.Send.Encrypt,
+			},
+			cli.Messages.Send.Sender.options(), out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
@@ -515,12 +780,19 @@ func main() {
 
 		if err := runMessagesDraft(cmdCtx, conn, cli.Messages.Draft.To, cli.Messages.Draft.Cc, cli.Messages.Draft.Bcc,
 			cli.Messages.Draft.Subject, cli.Messages.Draft.Body, cli.Messages.Draft.Attach,
-			cli.Messages.Draft.HTML, cli.Messages.Draft.ThreadID, out); err != nil {
+			cli.Messages.Draft.Inline, cli.Messages.Draft.ThreadID,
+			composeOptions{
+				html:     cli.Messages.Draft.HTML,
+				markdown: cli.Messages.Draft.Markdown,
+				sign:     cli.Messages.Draft.Sign,
+				encrypt:  cli.Messages.Draft.Encrypt,
+			},
+			cli.Messages.Draft.Sender.options(), out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "messages delete":
+	case "messages reply <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -528,12 +800,14 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesDelete(cmdCtx, conn, cli.Messages.Delete.MessageID, cli.Messages.Delete.Stdin, cli.Verbose, out); err != nil {
+		if err := runMessagesReply(cmdCtx, conn, cli.Messages.Reply.MessageID, false, cli.Messages.Reply.Body,
+			cli.Messages.Reply.Cc, cli.Messages.Reply.Bcc, cli.Messages.Reply.Attach, cli.Messages.Reply.HTML,
+			cli.Messages.Reply.Sender.options(), out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "messages mark-read":
+	case "messages reply-all <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -541,12 +815,14 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesMarkRead(cmdCtx, conn, cli.Messages.MarkRead.MessageID, cli.Messages.MarkRead.Stdin, cli.Verbose, out); err != nil {
+		if err := runMessagesReply(cmdCtx, conn, cli.Messages.ReplyAll.MessageID, true, cli.Messages.ReplyAll.Body,
+			cli.Messages.ReplyAll.Cc, cli.Messages.ReplyAll.Bcc, cli.Messages.ReplyAll.Attach, cli.Messages.ReplyAll.HTML,
+			cli.Messages.ReplyAll.Sender.options(), out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "messages mark-unread":
+	case "messages forward <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -554,12 +830,13 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesMarkUnread(cmdCtx, conn, cli.Messages.MarkUnread.MessageID, cli.Messages.MarkUnread.Stdin, cli.Verbose, out); err != nil {
+		if err := runMessagesForward(cmdCtx, conn, cli.Messages.Forward.MessageID, cli.Messages.Forward.To, cli.Messages.Forward.Cc,
+			cli.Messages.Forward.Bcc, cli.Messages.Forward.Body, cli.Messages.Forward.Attach, cli.Messages.Forward.Sender.options(), out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "messages move":
+	case "messages watch":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -567,12 +844,20 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runMessagesMove(cmdCtx, conn, cli.Messages.Move.MessageID, cli.Messages.Move.To, cli.Messages.Move.Stdin, cli.Verbose, out); err != nil {
+		statePath := cli.Messages.Watch.State
+		if statePath == "" {
+			if statePath, err = defaultWatchStatePath(cli.Config); err != nil {
+				out.writeError(err)
+				os.Exit(2)
+			}
+		}
+		if err := runMessagesWatch(cmdCtx, conn, cli.Messages.Watch.Label, statePath, cli.Messages.Watch.Exec,
+			cli.Messages.Watch.Interval, cli.Messages.Watch.Once, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "labels list":
+	case "messages export":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -580,12 +865,13 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runLabelsList(cmdCtx, conn, cli.Labels.List.System, cli.Labels.List.UserOnly, out); err != nil {
+		if err := runMessagesExport(cmdCtx, conn, cli.Messages.Export.Query, cli.Messages.Export.Format,
+			cli.Messages.Export.Out, cli.Messages.Export.Limit, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "labels apply":
+	case "messages import <files>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -593,12 +879,13 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runLabelsApply(cmdCtx, conn, cli.Labels.Apply.LabelID, cli.Labels.Apply.MessageID, cli.Labels.Apply.Stdin, cli.Verbose, out); err != nil {
+		if err := runMessagesImport(cmdCtx, conn, cli.Messages.Import.Files, cli.Messages.Import.Label, cli.Messages.Import.MarkRead,
+			cli.Messages.Import.KeepDate, cli.Messages.Import.Insert, cli.Messages.Import.AllowDuplicates, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "labels remove":
+	case "messages merge":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -606,12 +893,14 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runLabelsRemove(cmdCtx, conn, cli.Labels.Remove.LabelID, cli.Labels.Remove.MessageID, cli.Labels.Remove.Stdin, cli.Verbose, out); err != nil {
+		if err := runMessagesMerge(cmdCtx, conn, cli.Messages.Merge.Template, cli.Messages.Merge.Data, cli.Messages.Merge.Log,
+			cli.Messages.Merge.HTML, cli.Messages.Merge.Markdown, cli.Messages.Merge.Draft, cli.Messages.Merge.DryRun,
+			cli.Messages.Merge.Delay, cli.Messages.Merge.Sender.options(), out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "attachments list <message-id>":
+	case "messages delete", "messages delete <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -619,12 +908,12 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runAttachmentsList(cmdCtx, conn, cli.Attachments.List.MessageID, out); err != nil {
+		if err := runMessagesDelete(cmdCtx, conn, cli.Messages.Delete.MessageID, cli.Messages.Delete.Force, cli.Messages.Delete.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "attachments download <message-id>":
+	case "messages mark-read", "messages mark-read <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -632,14 +921,12 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runAttachmentsDownload(cmdCtx, conn, cli.Attachments.Download.MessageID,
-			cli.Attachments.Download.Index, cli.Attachments.Download.Filename,
-			cli.Attachments.Download.OutputDir, cli.Attachments.Download.Output, out); err != nil {
+		if err := runMessagesMarkRead(cmdCtx, conn, cli.Messages.MarkRead.MessageID, cli.Messages.MarkRead.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "artifacts list <message-id>":
+	case "messages mark-unread", "messages mark-unread <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -647,12 +934,12 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runArtifactsList(cmdCtx, conn, cli.Artifacts.List.MessageID, out); err != nil {
+		if err := runMessagesMarkUnread(cmdCtx, conn, cli.Messages.MarkUnread.MessageID, cli.Messages.MarkUnread.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "artifacts download <message-id>":
+	case "messages move", "messages move <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
@@ -660,245 +947,865 @@ func main() {
 			os.Exit(3)
 		}
 
-		if err := runArtifactsDownload(cmdCtx, conn, cli.Artifacts.Download.MessageID,
-			cli.Artifacts.Download.Index, cli.Artifacts.Download.Filename,
-			cli.Artifacts.Download.OutputDir, cli.Artifacts.Download.Output, out); err != nil {
+		if err := runMessagesMove(cmdCtx, conn, cli.Messages.Move.MessageID, cli.Messages.Move.To, cli.Messages.Move.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive get <file>":
+	case "messages trash", "messages trash <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveGet(cmdCtx, conn, cli.Drive.Get.Ref, out); err != nil {
+
+		if err := runMessagesAction(cmdCtx, conn, trashAction, cli.Messages.Trash.MessageID, cli.Messages.Trash.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive export <file>":
+	case "messages untrash", "messages untrash <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveExport(cmdCtx, conn, cli.Drive.Export.Ref,
-			cli.Drive.Export.ExportFormat, cli.Drive.Export.OutputDir,
-			cli.Drive.Export.Output, out); err != nil {
+
+		if err := runMessagesAction(cmdCtx, conn, untrashAction, cli.Messages.Untrash.MessageID, cli.Messages.Untrash.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive list":
+	case "messages spam", "messages spam <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveList(cmdCtx, conn, cli.Drive.List.Query,
-			cli.Drive.List.Folder, cli.Drive.List.Limit, out); err != nil {
+
+		if err := runMessagesAction(cmdCtx, conn, spamAction, cli.Messages.Spam.MessageID, cli.Messages.Spam.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive search <term>":
+	case "messages not-spam", "messages not-spam <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveSearch(cmdCtx, conn, cli.Drive.Search.Term,
-			cli.Drive.Search.Limit, out); err != nil {
+
+		if err := runMessagesAction(cmdCtx, conn, notSpamAction, cli.Messages.NotSpam.MessageID, cli.Messages.NotSpam.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive upload <path>":
+	case "messages archive", "messages archive <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveUpload(cmdCtx, conn, cli.Drive.Upload.Paths,
-			cli.Drive.Upload.Folder, cli.Drive.Upload.Name,
-			cli.Drive.Upload.Convert, cli.Drive.Upload.Upsert,
-			cli.Drive.Upload.As, out); err != nil {
+
+		if err := runMessagesAction(cmdCtx, conn, archiveAction, cli.Messages.Archive.MessageID, cli.Messages.Archive.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive update <file> <path>":
+	case "messages unsubscribe", "messages unsubscribe <message-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveUpdate(cmdCtx, conn, cli.Drive.Update.Ref,
-			cli.Drive.Update.Path, cli.Drive.Update.Name, out); err != nil {
+
+		if err := runMessagesUnsubscribe(cmdCtx, conn, newUnsubscribeClient(), cli.Messages.Unsubscribe, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive mkdir <name>":
+	case "messages stats":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveMkdir(cmdCtx, conn, cli.Drive.Mkdir.Name,
-			cli.Drive.Mkdir.Folder, cli.Drive.Mkdir.NoDedupe, out); err != nil {
+
+		if err := runMessagesStats(cmdCtx, conn, cli.Messages.Stats, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive mv <file>":
+	case "trash empty":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveMove(cmdCtx, conn, cli.Drive.Mv.Ref,
-			cli.Drive.Mv.Folder, out); err != nil {
+
+		if err := runTrashEmpty(cmdCtx, conn, cli.Trash.Empty.OlderThan, cli.Trash.Empty.Force, cli.Trash.Empty.DryRun, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive rename <file> <name>":
+	case "threads list":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveRename(cmdCtx, conn, cli.Drive.Rename.Ref,
-			cli.Drive.Rename.Name, out); err != nil {
+
+		if err := runThreadsList(cmdCtx, conn, cli.Threads.List.Label, cli.Threads.List.Query, cli.Threads.List.Limit, cli.Threads.List.PageToken, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive cp <file>":
+	case "threads read <thread-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveCopy(cmdCtx, conn, cli.Drive.Cp.Ref,
-			cli.Drive.Cp.Name, cli.Drive.Cp.Folder, out); err != nil {
+
+		if err := runThreadsRead(cmdCtx, conn, cli.Threads.Read.ThreadID, cli.Threads.Read.RawHTML, cli.Threads.Read.PreferPlain, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive rm <file>":
+	case "threads modify <thread-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveRm(cmdCtx, conn, cli.Drive.Rm.Ref,
-			cli.Drive.Rm.Permanent, cli.Drive.Rm.Force, out); err != nil {
+
+		if err := runThreadsModify(cmdCtx, conn, cli.Threads.Modify.ThreadID, cli.Threads.Modify.AddLabel, cli.Threads.Modify.RemoveLabel, cli.Threads.Modify.Archive, cli.Threads.Modify.MarkRead, cli.Threads.Modify.MarkUnread, cli.Threads.Modify.Trash, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive share <file>":
+	case "drafts list":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		principal := cli.Drive.Share.Email
-		if cli.Drive.Share.Type == "domain" {
-			principal = cli.Drive.Share.Domain
+
+		if err := runDraftsList(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
 		}
-		if err := runDriveShare(cmdCtx, conn, cli.Drive.Share.Ref,
-			cli.Drive.Share.Type, cli.Drive.Share.Role, principal,
-			cli.Drive.Share.Message, cli.Drive.Share.Notify, out); err != nil {
+
+	case "drafts read <draft-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+
+		if err := runDraftsRead(cmdCtx, conn, cli.Drafts.Read.DraftID, cli.Drafts.Read.PreferPlain, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive link <file>":
+	case "drafts update <draft-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDriveLink(cmdCtx, conn, cli.Drive.Link.Ref,
-			cli.Drive.Link.Role, cli.Drive.Link.NoAnyone, out); err != nil {
+
+		if err := runDraftsUpdate(cmdCtx, conn, cli.Drafts.Update, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "drive permissions <file>":
+	case "drafts send <draft-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runDrivePermissions(cmdCtx, conn, cli.Drive.Permissions.Ref, out); err != nil {
+
+		if err := runDraftsSend(cmdCtx, conn, cli.Drafts.Send.DraftID, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "filters list":
+	case "drafts delete <draft-id>":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runFiltersList(cmdCtx, conn, out); err != nil {
+
+		if err := runDraftsDelete(cmdCtx, conn, cli.Drafts.Delete.DraftID, cli.Drafts.Delete.Force, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "filters get <filter-id>":
+	case "labels list":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runFiltersGet(cmdCtx, conn, cli.Filters.Get.FilterID, out); err != nil {
+
+		if err := runLabelsList(cmdCtx, conn, cli.Labels.List.System, cli.Labels.List.UserOnly, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "filters create":
+	case "labels apply":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runFiltersCreate(cmdCtx, conn, cli.Filters.Create, out); err != nil {
+
+		if err := runLabelsApply(cmdCtx, conn, cli.Labels.Apply.LabelID, cli.Labels.Apply.MessageID, cli.Labels.Apply.Bulk, cli.Verbose, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
 
-	case "filters delete <filter-id>":
+	case "labels remove":
 		cmdCtx := context.Background()
 		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
 		if err != nil {
 			out.writeError(err)
 			os.Exit(3)
 		}
-		if err := runFiltersDelete(cmdCtx, conn, cli.Filters.Delete.FilterID, cli.Filters.Delete.Force, out); err != nil {
+
+		if err := runLabelsRemove(cmdCtx, conn, cli.Labels.Remove.LabelID, cli.Labels.Remove.MessageID, cli.Labels.Remove.Bulk, cli.Verbose, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels create <name>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsCreate(cmdCtx, conn, cli.Labels.Create.Name, cli.Labels.Create.Parent, cli.Labels.Create.Bg, cli.Labels.Create.Fg, cli.Labels.Create.Hide, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels rename <label> <new-name>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsRename(cmdCtx, conn, cli.Labels.Rename.Label, cli.Labels.Rename.NewName, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels delete <label>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsDelete(cmdCtx, conn, cli.Labels.Delete.Label, cli.Labels.Delete.Force, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels color":
+		// Without a label only --palette is valid, which needs no connection.
+		if err := runLabelsColor(context.Background(), nil, cli.Labels.Color.Label, cli.Labels.Color.Bg, cli.Labels.Color.Fg, cli.Labels.Color.Clear, cli.Labels.Color.Palette, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels color <label>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsColor(cmdCtx, conn, cli.Labels.Color.Label, cli.Labels.Color.Bg, cli.Labels.Color.Fg, cli.Labels.Color.Clear, cli.Labels.Color.Palette, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels merge <source> <destination>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsMerge(cmdCtx, conn, cli.Labels.Merge.Source, cli.Labels.Merge.Destination, cli.Labels.Merge.Force, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels stats":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsStats(cmdCtx, conn, nil, cli.Labels.Stats.System, cli.Labels.Stats.UserOnly, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels stats <labels>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsStats(cmdCtx, conn, cli.Labels.Stats.Labels, cli.Labels.Stats.System, cli.Labels.Stats.UserOnly, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels tree":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsTree(cmdCtx, conn, "", out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "labels tree <root>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runLabelsTree(cmdCtx, conn, cli.Labels.Tree.Root, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "attachments list <message-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+
+		if err := runAttachmentsList(cmdCtx, conn, cli.Attachments.List.MessageID, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "attachments download <message-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+
+		if err := runAttachmentsDownload(cmdCtx, conn, cli.Attachments.Download.MessageID,
+			cli.Attachments.Download.Index, cli.Attachments.Download.Filename,
+			cli.Attachments.Download.OutputDir, cli.Attachments.Download.Output, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "artifacts list <message-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+
+		if err := runArtifactsList(cmdCtx, conn, cli.Artifacts.List.MessageID, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "artifacts download <message-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+
+		if err := runArtifactsDownload(cmdCtx, conn, cli.Artifacts.Download.MessageID,
+			cli.Artifacts.Download.Index, cli.Artifacts.Download.Filename,
+			cli.Artifacts.Download.OutputDir, cli.Artifacts.Download.Output, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive get <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveGet(cmdCtx, conn, cli.Drive.Get.Ref, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive export <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveExport(cmdCtx, conn, cli.Drive.Export.Ref,
+			cli.Drive.Export.ExportFormat, cli.Drive.Export.OutputDir,
+			cli.Drive.Export.Output, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive list":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveList(cmdCtx, conn, cli.Drive.List.Query,
+			cli.Drive.List.Folder, cli.Drive.List.Limit, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive search <term>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveSearch(cmdCtx, conn, cli.Drive.Search.Term,
+			cli.Drive.Search.Limit, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive upload <path>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveUpload(cmdCtx, conn, cli.Drive.Upload.Paths,
+			cli.Drive.Upload.Folder, cli.Drive.Upload.Name,
+			cli.Drive.Upload.Convert, cli.Drive.Upload.Upsert,
+			cli.Drive.Upload.As, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive update <file> <path>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveUpdate(cmdCtx, conn, cli.Drive.Update.Ref,
+			cli.Drive.Update.Path, cli.Drive.Update.Name, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive mkdir <name>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveMkdir(cmdCtx, conn, cli.Drive.Mkdir.Name,
+			cli.Drive.Mkdir.Folder, cli.Drive.Mkdir.NoDedupe, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive mv <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveMove(cmdCtx, conn, cli.Drive.Mv.Ref,
+			cli.Drive.Mv.Folder, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive rename <file> <name>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveRename(cmdCtx, conn, cli.Drive.Rename.Ref,
+			cli.Drive.Rename.Name, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive cp <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveCopy(cmdCtx, conn, cli.Drive.Cp.Ref,
+			cli.Drive.Cp.Name, cli.Drive.Cp.Folder, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive rm <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveRm(cmdCtx, conn, cli.Drive.Rm.Ref,
+			cli.Drive.Rm.Permanent, cli.Drive.Rm.Force, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive share <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		principal := cli.Drive.Share.Email
+		if cli.Drive.Share.Type == "domain" {
+			principal = cli.Drive.Share.Domain
+		}
+		if err := runDriveShare(cmdCtx, conn, cli.Drive.Share.Ref,
+			cli.Drive.Share.Type, cli.Drive.Share.Role, principal,
+			cli.Drive.Share.Message, cli.Drive.Share.Notify, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive link <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDriveLink(cmdCtx, conn, cli.Drive.Link.Ref,
+			cli.Drive.Link.Role, cli.Drive.Link.NoAnyone, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "drive permissions <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runDrivePermissions(cmdCtx, conn, cli.Drive.Permissions.Ref, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters list":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersList(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters get <filter-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersGet(cmdCtx, conn, cli.Filters.Get.FilterID, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters create":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersCreate(cmdCtx, conn, cli.Filters.Create, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters delete <filter-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersDelete(cmdCtx, conn, cli.Filters.Delete.FilterID, cli.Filters.Delete.Force, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters export":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersExport(cmdCtx, conn, cli.Filters.Export.Format, cli.Filters.Export.Out, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters import <file>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersImport(cmdCtx, conn, cli.Filters.Import.File, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters plan":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersPlan(cmdCtx, conn, cli.Filters.Plan.File, cli.Filters.Plan.Prune, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters apply":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersApply(cmdCtx, conn, cli.Filters.Apply.File, cli.Filters.Apply.Prune, cli.Filters.Apply.Force, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters test":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersTest(cmdCtx, conn, cli.Filters.Test, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters test <filter-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersTest(cmdCtx, conn, cli.Filters.Test, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "filters run <filter-id>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runFiltersRun(cmdCtx, conn, cli.Filters.Run, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings vacation get":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsVacationGet(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings vacation set":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsVacationSet(cmdCtx, conn, cli.Settings.Vacation.Set, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings vacation off":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsVacationOff(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings sendas list":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsSendAsList(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings sendas get <email>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsSendAsGet(cmdCtx, conn, cli.Settings.Sendas.Get.Email, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings sendas update <email>":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsSendAsUpdate(cmdCtx, conn, cli.Settings.Sendas.Update, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings imap get":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsImapGet(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings imap set":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsImapSet(cmdCtx, conn, cli.Settings.Imap.Set, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings pop get":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsPopGet(cmdCtx, conn, out); err != nil {
+			out.writeError(err)
+			os.Exit(2)
+		}
+
+	case "settings pop set":
+		cmdCtx := context.Background()
+		conn, err := getConnection(cli.Config, cli.User, cli.Verbose)
+		if err != nil {
+			out.writeError(err)
+			os.Exit(3)
+		}
+		if err := runSettingsPopSet(cmdCtx, conn, cli.Settings.Pop.Set, out); err != nil {
 			out.writeError(err)
 			os.Exit(2)
 		}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// mergeTemplateHeader is the YAML frontmatter of a merge template. Every
+// field is itself a text/template rendered against each data row.
+type mergeTemplateHeader struct {
+	To      string   `yaml:"to"`
+	Cc      string   `yaml:"cc"`
+	Bcc     string   `yaml:"bcc"`
+	Subject string   `yaml:"subject"`
+	Attach  []string `yaml:"attach"`
+}
+
+// templateExecutor is a parsed text/template or html/template.
+type templateExecutor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// mergeTemplate is a parsed merge template.
+type mergeTemplate struct {
+	to, cc, bcc, subject *template.Template
+	body                 templateExecutor
+	attach               []*template.Template
+}
+
+// mergeMessage is one rendered message.
+type mergeMessage struct {
+	Row         int      `json:"row"`
+	To          []string `json:"to"`
+	Cc          []string `json:"cc,omitempty"`
+	Bcc         []string `json:"bcc,omitempty"`
+	Subject     string   `json:"subject"`
+	Body        string   `json:"body"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// mergeLogEntry is one line of the merge result log.
+type mergeLogEntry struct {
+	Row      int    `json:"row"`
+	To       string `json:"to"`
+	Status   string `json:"status"`       // sent, drafted, failed
+	ID       string `json:"id,omitempty"` // message ID, or draft ID with --draft
+	ThreadID string `json:"threadId,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Time     string `json:"time"`
+}
+
+// mergeResult is JSON output format for messages merge.
+type mergeResult struct {
+	Log     string          `json:"log"`
+	Sent    int             `json:"sent"`
+	Drafted int             `json:"drafted"`
+	Skipped int             `json:"skipped"`
+	Failed  int             `json:"failed"`
+	Rows    []mergeLogEntry `json:"rows"`
+}
+
+// parseMergeTemplate splits a template file into YAML frontmatter and body
+// and parses each field as a text/template. An HTML body is parsed as an
+// html/template instead, so data values are escaped. Missing data keys are
+// errors.
+func parseMergeTemplate(src string, html bool) (*mergeTemplate, error) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	if !strings.HasPrefix(src, "---\n") {
+		return nil, fmt.Errorf("template must start with a --- frontmatter block")
+	}
+	end := strings.Index(src[4:], "\n---\n")
+	if end < 0 {
+		return nil, fmt.Errorf("template frontmatter is not closed with ---")
+	}
+	var head mergeTemplateHeader
+	if err := yaml.Unmarshal([]byte(src[4:4+end]), &head); err != nil {
+		return nil, fmt.Errorf("failed to parse template frontmatter: %w", err)
+	}
+	if head.To == "" {
+		return nil, fmt.Errorf("template frontmatter must set to")
+	}
+
+	var parseErr error
+	parse := func(name, text string) *template.Template {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		return t
+	}
+	t := &mergeTemplate{
+		to:      parse("to", head.To),
+		cc:      parse("cc", head.Cc),
+		bcc:     parse("bcc", head.Bcc),
+		subject: parse("subject", head.Subject),
+	}
+	body := src[4+end+5:]
+	if html {
+		b, err := htmltemplate.New("body").Option("missingkey=error").Parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template body: %w", err)
+		}
+		t.body = b
+	} else {
+		t.body = parse("body", body)
+	}
+	for i, a := range head.Attach {
+		t.attach = append(t.attach, parse(fmt.Sprintf("attach[%d]", i), a))
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return t, nil
+}
+
+// render executes the template for one data row.
+func (t *mergeTemplate) render(index int, row map[string]any) (*mergeMessage, error) {
+	exec := func(tmpl templateExecutor) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, row); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	msg := &mergeMessage{Row: index}
+	for _, f := range []struct {
+		tmpl *template.Template
+		dst  *[]string
+	}{{t.to, &msg.To}, {t.cc, &msg.Cc}, {t.bcc, &msg.Bcc}} {
+		v, err := exec(f.tmpl)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = splitAddressHeader(strings.TrimSpace(v))
+	}
+	if len(msg.To) == 0 {
+		return nil, fmt.Errorf("rendered to is empty")
+	}
+
+	var err error
+	if msg.Subject, err = exec(t.subject); err != nil {
+		return nil, err
+	}
+	msg.Subject = strings.TrimSpace(msg.Subject)
+	if msg.Body, err = exec(t.body); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(msg.Body) == "" {
+		return nil, fmt.Errorf("rendered body is empty")
+	}
+
+	for _, a := range t.attach {
+		path, err := exec(a)
+		if err != nil {
+			return nil, err
+		}
+		// Rows without a value for an attachment simply skip it.
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("attachment %s: %w", path, err)
+		}
+		msg.Attachments = append(msg.Attachments, path)
+	}
+	return msg, nil
+}
+
+// loadMergeData reads the data rows from a JSON array of objects, or from a
+// CSV file whose first line names the columns.
+func loadMergeData(path string) ([]map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var rows []map[string]any
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: expected an array of objects: %w", path, err)
+		}
+		return rows, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	rows := make([]map[string]any, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]any, len(header))
+		for i, col := range header {
+			row[strings.TrimSpace(col)] = rec[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// loadMergeLog returns the last logged entry for every row.
+func loadMergeLog(path string) (map[int]mergeLogEntry, error) {
+	entries := map[int]mergeLogEntry{}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read merge log: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e mergeLogEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse merge log %s: %w", path, err)
+		}
+		entries[e.Row] = e
+	}
+	return entries, sc.Err()
+}
+
+// runMessagesMerge renders a template for every data row and sends (or
+// drafts) the result. Each row's outcome is appended to a JSONL log, and
+// rows already sent or drafted according to the log are skipped, so a
+// failed run can be repeated. The sender options are resolved once, and the
+// alias signature is added to every message but not to the --dry-run
+// preview.
+func runMessagesMerge(ctx context.Context, conn *gwcli.CmdG, templatePath, dataPath, logPath string, html, markdown, draft, dryRun bool, delay time.Duration, sender senderOptions, out *outputWriter) error {
+	src, err := os.ReadFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	tmpl, err := parseMergeTemplate(string(src), html)
+	if err != nil {
+		return err
+	}
+	rows, err := loadMergeData(dataPath)
+	if err != nil {
+		return err
+	}
+
+	// Render everything up front so template errors surface before anything
+	// is sent.
+	messages := make([]*mergeMessage, len(rows))
+	for i, row := range rows {
+		msg, err := tmpl.render(i+1, row)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i+1, err)
+		}
+		messages[i] = msg
+	}
+
+	if len(messages) == 0 {
+		return out.WriteEmptyList("No rows in " + dataPath)
+	}
+
+	if dryRun {
+		if out.json {
+			return out.writeJSON(messages)
+		}
+		for _, m := range messages {
+			fmt.Fprintf(out.writer, "--- Row %d ---\nTo: %s\n", m.Row, strings.Join(m.To, ", "))
+			if len(m.Cc) > 0 {
+				fmt.Fprintf(out.writer, "Cc: %s\n", strings.Join(m.Cc, ", "))
+			}
+			if len(m.Bcc) > 0 {
+				fmt.Fprintf(out.writer, "Bcc: %s\n", strings.Join(m.Bcc, ", "))
+			}
+			fmt.Fprintf(out.writer, "Subject: %s\n", m.Subject)
+			for _, a := range m.Attachments {
+				fmt.Fprintf(out.writer, "Attachment: %s\n", a)
+			}
+			fmt.Fprintf(out.writer, "\n%s\n", strings.TrimRight(m.Body, "\n"))
+		}
+		return nil
+	}
+
+	extra, signature, err := senderHeaders(ctx, conn, sender)
+	if err != nil {
+		return err
+	}
+
+	if logPath == "" {
+		logPath = dataPath + ".merge-log.jsonl"
+	}
+	done, err := loadMergeLog(logPath)
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open merge log: %w", err)
+	}
+	defer logFile.Close()
+
+	result := mergeResult{Log: logPath, Rows: []mergeLogEntry{}}
+	sentAny := false
+	for _, m := range messages {
+		to := strings.Join(m.To, ", ")
+		if prev, ok := done[m.Row]; ok && prev.To == to && prev.Status != "failed" {
+			out.writeVerbose("Skipping row %d (%s %s)", m.Row, prev.Status, prev.ID)
+			result.Skipped++
+			continue
+		}
+
+		if sentAny && delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		sentAny = true
+
+		entry := mergeLogEntry{Row: m.Row, To: to}
+		body := m.Body
+		if signature != "" {
+			body = appendSignature(body, signature, html, markdown)
+		}
+		headers, parts, err := buildOutgoingMessage(m.To, m.Cc, m.Bcc, m.Subject, body, m.Attachments, nil, composeOptions{html: html, markdown: markdown})
+		if err == nil {
+			mergeHeaders(headers, extra)
+			if draft {
+				entry.ID, err = conn.DraftParts(ctx, gwcli.NewThread, "mixed", headers, parts)
+			} else {
+				var sent *gmail.Message
+				if sent, err = conn.SendParts(ctx, gwcli.NewThread, "mixed", headers, parts); err == nil {
+					entry.ID, entry.ThreadID = sent.Id, sent.ThreadId
+				}
+			}
+		}
+		switch {
+		case err != nil:
+			entry.Status, entry.Error = "failed", err.Error()
+			result.Failed++
+		case draft:
+			entry.Status = "drafted"
+			result.Drafted++
+		default:
+			entry.Status = "sent"
+			result.Sent++
+		}
+		out.writeVerbose("Row %d to %s: %s", m.Row, to, entry.Status)
+
+		entry.Time = time.Now().UTC().Format(time.RFC3339)
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := logFile.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write merge log: %w", err)
+		}
+		result.Rows = append(result.Rows, entry)
+	}
+
+	if out.json {
+		if err := out.writeJSON(result); err != nil {
+			return err
+		}
+	} else {
+		if len(result.Rows) > 0 {
+			headers := []string{"ROW", "TO", "STATUS", "ID"}
+			tableRows := make([][]string, len(result.Rows))
+			for i, e := range result.Rows {
+				status := e.Status
+				if e.Error != "" {
+					status += ": " + e.Error
+				}
+				tableRows[i] = []string{fmt.Sprintf("%d", e.Row), truncateString(e.To, 40), status, e.ID}
+			}
+			if err := out.writeTable(headers, tableRows); err != nil {
+				return err
+			}
+		}
+		out.writeMessage(fmt.Sprintf("Sent %d, drafted %d, skipped %d, failed %d (log: %s)",
+			result.Sent, result.Drafted, result.Skipped, result.Failed, logPath))
+	}
+
+	if result.Failed > 0 {
+		return fmt.Errorf("%d rows failed; rerun to retry them", result.Failed)
+	}
+	return nil
+}
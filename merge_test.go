@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+const mergeTestTemplate = `---
+to: "{{.name}} <{{.email}}>"
+subject: "Invoice {{.invoice}}"
+attach:
+  - "{{.file}}"
+---
+Hi {{.name}},
+
+Your invoice {{.invoice}} is attached.
+`
+
+func writeMergeFixtures(t *testing.T) (dir, tmplPath, dataPath string) {
+	t.Helper()
+	dir = t.TempDir()
+	tmplPath = filepath.Join(dir, "invoice.md")
+	if err := os.WriteFile(tmplPath, []byte(mergeTestTemplate), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pdf := filepath.Join(dir, "a.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dataPath = filepath.Join(dir, "rows.csv")
+	csv := "name,email,invoice,file\n" +
+		"Ana,ana@example.com,1001," + pdf + "\n" +
+		"Bob,bob@example.com,1002,\n"
+	if err := os.WriteFile(dataPath, []byte(csv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir, tmplPath, dataPath
+}
+
+// newFakeMergeConn records sent messages; sends to failFor get a 500.
+func newFakeMergeConn(t *testing.T, sent *[]string, failFor string) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "messages/send" {
+			return nil
+		}
+		var msg gmail.Message
+		decodeRequest(t, req, &msg)
+		raw := decodeSent(t, &msg)
+		if failFor != "" && strings.Contains(raw, failFor) {
+			return fakeError{code: http.StatusInternalServerError, message: "backend error"}
+		}
+		*sent = append(*sent, raw)
+		return `{"id":"S1","threadId":"S1"}`
+	})
+}
+
+func TestRunMessagesMerge_DryRun(t *testing.T) {
+	_, tmplPath, dataPath := writeMergeFixtures(t)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesMerge(context.Background(), nil, tmplPath, dataPath, "", false, false, false, true, 0, senderOptions{}, out); err != nil {
+		t.Fatalf("runMessagesMerge() error = %v", err)
+	}
+	var msgs []mergeMessage
+	if err := json.Unmarshal(buf.Bytes(), &msgs); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 rendered messages, got %d", len(msgs))
+	}
+	if msgs[0].To[0] != `"Ana" <ana@example.com>` || msgs[0].Subject != "Invoice 1001" || len(msgs[0].Attachments) != 1 {
+		t.Errorf("unexpected row 1: %+v", msgs[0])
+	}
+	if !strings.HasPrefix(msgs[1].Body, "Hi Bob,") || len(msgs[1].Attachments) != 0 {
+		t.Errorf("unexpected row 2: %+v", msgs[1])
+	}
+	if _, err := os.Stat(dataPath + ".merge-log.jsonl"); !os.IsNotExist(err) {
+		t.Error("--dry-run should not write a log")
+	}
+}
+
+func TestRunMessagesMerge_MissingColumn(t *testing.T) {
+	dir, tmplPath, _ := writeMergeFixtures(t)
+	dataPath := filepath.Join(dir, "rows.json")
+	if err := os.WriteFile(dataPath, []byte(`[{"name":"Ana","email":"ana@example.com","file":""}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runMessagesMerge(context.Background(), nil, tmplPath, dataPath, "", false, false, false, true, 0, senderOptions{}, out)
+	if err == nil || !strings.Contains(err.Error(), "row 1") || !strings.Contains(err.Error(), "invoice") {
+		t.Errorf("error = %v, want a row 1 error naming the missing key", err)
+	}
+}
+
+func TestRunMessagesMerge_ResumesFailedRows(t *testing.T) {
+	_, tmplPath, dataPath := writeMergeFixtures(t)
+
+	// First run: Bob's send fails.
+	var sent []string
+	conn := newFakeMergeConn(t, &sent, "bob@example.com")
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	err := runMessagesMerge(context.Background(), conn, tmplPath, dataPath, "", false, false, false, false, 0, senderOptions{}, out)
+	if err == nil {
+		t.Fatal("expected an error for the failed row")
+	}
+	var res mergeResult
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res.Sent != 1 || res.Failed != 1 || res.Rows[1].Status != "failed" || res.Rows[1].Error == "" {
+		t.Errorf("unexpected first run result: %+v", res)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "Subject: Invoice 1001") || !strings.Contains(sent[0], `filename="a.pdf"`) {
+		t.Fatalf("unexpected sent messages: %q", sent)
+	}
+
+	// Second run: only Bob is retried.
+	sent = nil
+	conn = newFakeMergeConn(t, &sent, "")
+	buf.Reset()
+	if err := runMessagesMerge(context.Background(), conn, tmplPath, dataPath, "", false, false, false, false, 0, senderOptions{}, out); err != nil {
+		t.Fatalf("second runMessagesMerge() error = %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Sent != 1 || res.Skipped != 1 || res.Failed != 0 {
+		t.Errorf("second run sent=%d skipped=%d failed=%d, want 1/1/0", res.Sent, res.Skipped, res.Failed)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "To: \"Bob\" <bob@example.com>") {
+		t.Errorf("unexpected retry: %q", sent)
+	}
+
+	entries, err := loadMergeLog(res.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[1].Status != "sent" || entries[2].Status != "sent" || entries[2].ID != "S1" || entries[2].ThreadID != "S1" {
+		t.Errorf("log entries = %+v", entries)
+	}
+}
+
+func TestParseMergeTemplate_HTMLEscapesData(t *testing.T) {
+	src := "---\nto: \"{{.email}}\"\nsubject: \"Hi {{.name}}\"\n---\n<p>Hi {{.name}}, <a href=\"{{.url}}\">here</a></p>\n"
+	row := map[string]any{"email": "a@example.com", "name": "<script>x</script>", "url": `"><img src=x>`}
+
+	tmpl, err := parseMergeTemplate(src, true)
+	if err != nil {
+		t.Fatalf("parseMergeTemplate() error = %v", err)
+	}
+	msg, err := tmpl.render(1, row)
+	if err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+	if strings.Contains(msg.Body, "<script>") || strings.Contains(msg.Body, "<img") {
+		t.Errorf("data was not escaped in the HTML body: %s", msg.Body)
+	}
+	if !strings.Contains(msg.Body, "<p>Hi &lt;script&gt;") {
+		t.Errorf("template markup was lost: %s", msg.Body)
+	}
+	// Headers stay plain text.
+	if msg.Subject != "Hi <script>x</script>" {
+		t.Errorf("subject = %q", msg.Subject)
+	}
+}
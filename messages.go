@@ -4,12 +4,13 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"mime"
 	"net/mail"
-	"net/textproto"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/wesnick/gwcli/pkg/gpg"
 	"github.com/wesnick/gwcli/pkg/gwcli"
 	"google.golang.org/api/gmail/v1"
 )
@@ -25,7 +26,73 @@ type messageListOutput struct {
 	Snippet  string   `json:"snippet"`
 }
 
-func runMessagesList(ctx context.Context, conn *gwcli.CmdG, label string, limit int, unreadOnly bool, out *outputWriter) error {
+// messageListPage is JSON output format for message list and search results
+// resumed with --page-token. NextPageToken is set when more results remain;
+// pass it back via --page-token to continue the scan. Runs without
+// --page-token output a bare array of messages instead.
+type messageListPage struct {
+	Messages      []messageListOutput `json:"messages"`
+	NextPageToken string              `json:"nextPageToken,omitempty"`
+}
+
+// listMessagePages lists messages starting at pageToken and follows next-page
+// tokens until limit messages have been collected (limit <= 0 means no
+// limit). It returns the messages, preloaded with metadata, and the token to
+// resume from, which is empty once the listing is exhausted.
+func listMessagePages(ctx context.Context, conn *gwcli.CmdG, labelID, query, pageToken string, limit int, out *outputWriter) ([]*gwcli.Message, string, error) {
+	var messages []*gwcli.Message
+	for {
+		// Only ask for what is still needed so the next page token never
+		// skips messages we did not return.
+		var want int64
+		if limit > 0 {
+			want = int64(limit - len(messages))
+		}
+		page, err := conn.ListMessagesN(ctx, labelID, query, pageToken, want)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := page.PreloadSubjects(ctx); err != nil {
+			return nil, "", fmt.Errorf("failed to preload messages: %w", err)
+		}
+		messages = append(messages, page.Messages...)
+		pageToken = page.Response.NextPageToken
+		out.writeVerbose("Fetched %d messages so far", len(messages))
+
+		if pageToken == "" || (limit > 0 && len(messages) >= limit) {
+			return messages, pageToken, nil
+		}
+	}
+}
+
+// writeEmptyMessageList outputs an empty message list result.
+func writeEmptyMessageList(out *outputWriter, paged bool) error {
+	if out.json && paged {
+		return out.writeJSON(messageListPage{Messages: []messageListOutput{}})
+	}
+	return out.WriteEmptyList("No messages found")
+}
+
+// writeMessageListJSON outputs message list results. Without --page-token
+// (paged false) it keeps the bare array, and the token to continue from
+// goes to stderr as in text mode.
+func writeMessageListJSON(out *outputWriter, messages []messageListOutput, paged bool, nextPageToken string) error {
+	if paged {
+		return out.writeJSON(messageListPage{Messages: messages, NextPageToken: nextPageToken})
+	}
+	writeNextPageHint(nextPageToken)
+	return out.writeJSON(messages)
+}
+
+// writeNextPageHint tells the user how to continue a truncated listing. It
+// goes to stderr so the table or JSON array on stdout stays parseable.
+func writeNextPageHint(nextPageToken string) {
+	if nextPageToken != "" {
+		fmt.Fprintf(os.Stderr, "More messages available; continue with --page-token %s\n", nextPageToken)
+	}
+}
+
+func runMessagesList(ctx context.Context, conn *gwcli.CmdG, label string, limit int, unreadOnly bool, pageToken string, out *outputWriter) error {
 	out.writeVerbose("Loading labels from config...")
 	if err := conn.LoadLabels(ctx, out.verbose); err != nil {
 		return fmt.Errorf("failed to load labels: %w", err)
@@ -61,25 +128,14 @@ func runMessagesList(ctx context.Context, conn *gwcli.CmdG, label string, limit
 		query = "is:unread"
 	}
 
-	// List messages - returns a Page
-	page, err := conn.ListMessages(ctx, labelID, query, "")
+	// List messages, following next-page tokens up to the limit
+	messages, nextPageToken, err := listMessagePages(ctx, conn, labelID, query, pageToken, limit, out)
 	if err != nil {
 		return fmt.Errorf("failed to list messages: %w", err)
 	}
 
-	if len(page.Messages) == 0 {
-		return out.WriteEmptyList("No messages found")
-	}
-
-	// Preload message metadata
-	if err := page.PreloadSubjects(ctx); err != nil {
-		return fmt.Errorf("failed to preload messages: %w", err)
-	}
-
-	// Limit the number of messages if needed
-	messages := page.Messages
-	if limit > 0 && len(messages) > limit {
-		messages = messages[:limit]
+	if len(messages) == 0 {
+		return writeEmptyMessageList(out, pageToken != "")
 	}
 
 	// Output
@@ -125,7 +181,7 @@ func runMessagesList(ctx context.Context, conn *gwcli.CmdG, label string, limit
 				Snippet:  snippet,
 			}
 		}
-		return out.writeJSON(output)
+		return writeMessageListJSON(out, output, pageToken != "", nextPageToken)
 	}
 
 	// Text output
@@ -178,7 +234,11 @@ func runMessagesList(ctx context.Context, conn *gwcli.CmdG, label string, limit
 		}
 	}
 
-	return out.writeTable(headers, rows)
+	if err := out.writeTable(headers, rows); err != nil {
+		return err
+	}
+	writeNextPageHint(nextPageToken)
+	return nil
 }
 
 // messageReadOutput is JSON output format for reading a message
@@ -193,9 +253,80 @@ type messageReadOutput struct {
 	BodyMarkdown   string            `json:"bodyMarkdown,omitempty"`
 	Attachments    []attachmentInfo  `json:"attachments,omitempty"`
 	DriveArtifacts []driveArtifact   `json:"driveArtifacts,omitempty"`
+	Signature      *signatureInfo    `json:"signature,omitempty"`
 	Raw            string            `json:"raw,omitempty"`
 }
 
+// signatureInfo is the result of verifying a PGP/MIME or S/MIME message.
+type signatureInfo struct {
+	Signer      string   `json:"signer,omitempty" yaml:"signer,omitempty"`
+	Good        bool     `json:"good" yaml:"good"`
+	Trusted     bool     `json:"trusted" yaml:"trusted"`
+	Fingerprint string   `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	EncryptedTo []string `json:"encryptedTo,omitempty" yaml:"encrypted_to,omitempty"`
+	Warnings    []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+
+	// emails are the signer's verified addresses, matched against From.
+	emails []string
+}
+
+// messageSignature verifies a multipart/signed or multipart/encrypted
+// message. It returns nil for messages that are neither.
+func messageSignature(ctx context.Context, msg *gwcli.Message) (*signatureInfo, error) {
+	ct, _ := msg.GetHeader(ctx, "Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	if mediaType != "multipart/signed" && mediaType != "multipart/encrypted" {
+		return nil, nil
+	}
+	if err := msg.Preload(ctx, gwcli.LevelFull); err != nil {
+		return nil, fmt.Errorf("failed to load message for verification: %w", err)
+	}
+	st := msg.GPGStatus()
+	if st == nil {
+		return &signatureInfo{Warnings: []string{"no verification result for " + mediaType}}, nil
+	}
+	return &signatureInfo{
+		Signer:      st.Signed,
+		Good:        st.GoodSignature,
+		Trusted:     st.Trusted,
+		Fingerprint: st.Fingerprint,
+		EncryptedTo: st.Encrypted,
+		Warnings:    st.Warnings,
+		emails:      st.Emails,
+	}, nil
+}
+
+// checkRequireSigned returns an error unless sig is a good signature by a
+// trusted key whose user IDs include the From address.
+func checkRequireSigned(messageID, from string, sig *signatureInfo) error {
+	switch {
+	case sig == nil || (sig.Signer == "" && !sig.Good && len(sig.Warnings) == 0):
+		return fmt.Errorf("message %s is not signed", messageID)
+	case !sig.Good:
+		reason := "bad signature"
+		if len(sig.Warnings) > 0 {
+			reason = strings.Join(sig.Warnings, "; ")
+		}
+		return fmt.Errorf("signature verification failed for message %s: %s", messageID, reason)
+	case !sig.Trusted:
+		reason := "key is not fully trusted"
+		if len(sig.Warnings) > 0 {
+			reason = strings.Join(sig.Warnings, "; ")
+		}
+		return fmt.Errorf("signature on message %s by %q is not trusted: %s", messageID, sig.Signer, reason)
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("message %s has no valid From address to match the signer: %w", messageID, err)
+	}
+	for _, e := range sig.emails {
+		if strings.EqualFold(e, addr.Address) {
+			return nil
+		}
+	}
+	return fmt.Errorf("message %s is from %s but signed by %q", messageID, addr.Address, sig.Signer)
+}
+
 type attachmentInfo struct {
 	Index    int    `json:"index"`
 	Filename string `json:"filename"`
@@ -356,7 +487,7 @@ func extractHTMLFromPart(part *gmail.MessagePart) string {
 }
 
 // runMessagesRead reads and displays a single message
-func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, raw, headersOnly, rawHTML, preferPlain bool, out *outputWriter) error {
+func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, raw, headersOnly, rawHTML, preferPlain, requireSigned bool, out *outputWriter) error {
 	// Validate flags
 	if rawHTML && preferPlain {
 		return fmt.Errorf("--raw-html and --prefer-plain are mutually exclusive")
@@ -372,16 +503,30 @@ func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, ra
 			return fmt.Errorf("failed to get raw message: %w", err)
 		}
 
+		var sig *signatureInfo
+		if requireSigned {
+			if sig, err = messageSignature(ctx, msg); err != nil {
+				return err
+			}
+			from, _ := msg.GetHeader(ctx, "From")
+			if err := checkRequireSigned(messageID, from, sig); err != nil {
+				return err
+			}
+		}
+
 		if out.json {
 			output := messageReadOutput{
-				ID:  messageID,
-				Raw: rawData,
+				ID:        messageID,
+				Raw:       rawData,
+				Signature: sig,
+			}
+			if err := out.writeJSON(output); err != nil {
+				return err
 			}
-			return out.writeJSON(output)
+		} else {
+			// Text output - just print raw
+			fmt.Println(rawData)
 		}
-
-		// Text output - just print raw
-		fmt.Println(rawData)
 		return nil
 	}
 
@@ -391,17 +536,28 @@ func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, ra
 	if err := msg.Preload(ctx, level); err != nil {
 		return fmt.Errorf("failed to get message metadata: %w", err)
 	}
+	sig, err := messageSignature(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if requireSigned {
+		from, _ := msg.GetHeader(ctx, "From")
+		if err := checkRequireSigned(messageID, from, sig); err != nil {
+			return err
+		}
+	}
 
 	// JSON output
 	if out.json {
 		threadID, _ := msg.ThreadID(ctx)
 
 		output := messageReadOutput{
-			ID:       messageID,
-			ThreadID: string(threadID),
-			LabelIDs: msg.Response.LabelIds,
-			Snippet:  msg.Response.Snippet,
-			Headers:  make(map[string]string),
+			ID:        messageID,
+			ThreadID:  string(threadID),
+			LabelIDs:  msg.Response.LabelIds,
+			Snippet:   msg.Response.Snippet,
+			Headers:   make(map[string]string),
+			Signature: sig,
 		}
 
 		// Extract common headers
@@ -471,6 +627,7 @@ func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, ra
 			Subject:   subject,
 			Date:      date,
 			Labels:    msg.Response.LabelIds,
+			Signature: sig,
 		}
 		if artifacts, err := extractDriveArtifacts(ctx, conn, messageID); err == nil {
 			frontmatter.DriveArtifacts = artifacts
@@ -567,6 +724,7 @@ func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, ra
 		Date:      date,
 		Labels:    msg.Response.LabelIds,
 		Note:      fallbackNote,
+		Signature: sig,
 	}
 	if artifacts, err := extractDriveArtifacts(ctx, conn, messageID); err == nil {
 		frontmatter.DriveArtifacts = artifacts
@@ -607,28 +765,18 @@ func runMessagesRead(ctx context.Context, conn *gwcli.CmdG, messageID string, ra
 	return nil
 }
 
-func runMessagesSearch(ctx context.Context, conn *gwcli.CmdG, query string, limit int, out *outputWriter) error {
+func runMessagesSearch(ctx context.Context, conn *gwcli.CmdG, query string, limit int, pageToken string, out *outputWriter) error {
 	out.writeVerbose("Searching with query: %s", query)
 
-	page, err := conn.ListMessages(ctx, "", query, "")
+	messages, nextPageToken, err := listMessagePages(ctx, conn, "", query, pageToken, limit, out)
 	if err != nil {
 		return fmt.Errorf("failed to search messages: %w", err)
 	}
 
-	out.writeVerbose("Found %d messages", len(page.Messages))
-
-	if len(page.Messages) == 0 {
-		return out.WriteEmptyList("No messages found")
-	}
-
-	if err := page.PreloadSubjects(ctx); err != nil {
-		return fmt.Errorf("failed to preload messages: %w", err)
-	}
+	out.writeVerbose("Found %d messages", len(messages))
 
-	messages := page.Messages
-	if limit > 0 && len(messages) > limit {
-		messages = messages[:limit]
-		out.writeVerbose("Limited to %d messages", limit)
+	if len(messages) == 0 {
+		return writeEmptyMessageList(out, pageToken != "")
 	}
 
 	out.writeVerbose("Loading labels from config...")
@@ -681,7 +829,7 @@ func runMessagesSearch(ctx context.Context, conn *gwcli.CmdG, query string, limi
 				Snippet:  snippet,
 			}
 		}
-		return out.writeJSON(output)
+		return writeMessageListJSON(out, output, pageToken != "", nextPageToken)
 	}
 
 	// Text output
@@ -729,41 +877,63 @@ func runMessagesSearch(ctx context.Context, conn *gwcli.CmdG, query string, limi
 		}
 	}
 
-	return out.writeTable(headers, rows)
+	if err := out.writeTable(headers, rows); err != nil {
+		return err
+	}
+	writeNextPageHint(nextPageToken)
+	return nil
+}
+
+// composeOptions are the body format and OpenPGP flags of an outgoing
+// message.
+type composeOptions struct {
+	html     bool // body is HTML
+	markdown bool // body is markdown, rendered to HTML
+	sign     bool // sign with OpenPGP (PGP/MIME)
+	encrypt  bool // encrypt to the recipients' OpenPGP keys (PGP/MIME)
 }
 
 // buildOutgoingMessage assembles the headers and MIME parts for an outgoing
-// email, reading the body from stdin when it is empty. Shared by send and
-// draft.
-func buildOutgoingMessage(to, cc, bcc []string, subject, body string, attachments []string, html bool) (mail.Header, []*gwcli.Part, error) {
-	// Read body from stdin if not provided
-	if body == "" {
-		scanner := bufio.NewScanner(os.Stdin)
-		var lines []string
-		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
-		}
-		if err := scanner.Err(); err != nil {
-			return nil, nil, fmt.Errorf("error reading body from stdin: %w", err)
-		}
-		body = strings.Join(lines, "\n")
+// email. The body is used as given, even when empty; send and draft read it
+// from stdin first. HTML bodies get a plain-text alternative, and inline files are
+// embedded next to the HTML so it can reference them (see inlineContentID).
+// Markdown bodies are rendered to HTML and kept as the plain-text part.
+func buildOutgoingMessage(to, cc, bcc []string, subject, body string, attachments, inline []string, opts composeOptions) (mail.Header, []*gwcli.Part, error) {
+	if opts.html && opts.markdown {
+		return nil, nil, fmt.Errorf("--html and --markdown cannot be used together")
+	}
+	if len(inline) > 0 && !opts.html && !opts.markdown {
+		return nil, nil, fmt.Errorf("--inline requires --html or --markdown")
 	}
 
 	// Build message parts
 	parts := []*gwcli.Part{}
+	inlineParts, err := readInlineParts(inline)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Add body
-	contentType := `text/plain; charset="UTF-8"`
-	if html {
-		contentType = `text/html; charset="UTF-8"`
-	}
-	parts = append(parts, &gwcli.Part{
-		Header: textproto.MIMEHeader{
-			"Content-Type":        {contentType},
-			"Content-Disposition": {"inline"},
-		},
-		Contents: body,
-	})
+	switch {
+	case opts.markdown:
+		rendered, err := renderMarkdown(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		part, err := htmlBodyPart(rendered, body, inlineParts)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, part)
+	case opts.html:
+		part, err := htmlBodyPart(body, "", inlineParts)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, part)
+	default:
+		parts = append(parts, textPart("plain", body))
+	}
 
 	// Add attachments
 	for _, path := range attachments {
@@ -771,27 +941,7 @@ func buildOutgoingMessage(to, cc, bcc []string, subject, body string, attachment
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to read attachment %s: %w", path, err)
 		}
-
-		filename := filepath.Base(path)
-		mimeType := "application/octet-stream"
-		// Basic MIME type detection
-		if strings.HasSuffix(filename, ".pdf") {
-			mimeType = "application/pdf"
-		} else if strings.HasSuffix(filename, ".jpg") || strings.HasSuffix(filename, ".jpeg") {
-			mimeType = "image/jpeg"
-		} else if strings.HasSuffix(filename, ".png") {
-			mimeType = "image/png"
-		} else if strings.HasSuffix(filename, ".txt") {
-			mimeType = "text/plain"
-		}
-
-		parts = append(parts, &gwcli.Part{
-			Header: textproto.MIMEHeader{
-				"Content-Type":        {fmt.Sprintf(`%s; name=%q`, mimeType, filename)},
-				"Content-Disposition": {fmt.Sprintf(`attachment; filename=%q`, filename)},
-			},
-			Contents: string(data),
-		})
+		parts = append(parts, attachmentPart(filepath.Base(path), "", data))
 	}
 
 	// Build headers
@@ -810,41 +960,81 @@ func buildOutgoingMessage(to, cc, bcc []string, subject, body string, attachment
 	return headers, parts, nil
 }
 
+// readBodyFromStdin reads a message body from stdin.
+func readBodyFromStdin() (string, error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("error reading body from stdin: %w", err)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
 // runMessagesSend sends an email message
-func runMessagesSend(ctx context.Context, conn *gwcli.CmdG, to, cc, bcc []string, subject, body string, attachments []string, html bool, threadID string, out *outputWriter) error {
-	headers, parts, err := buildOutgoingMessage(to, cc, bcc, subject, body, attachments, html)
+func runMessagesSend(ctx context.Context, conn *gwcli.CmdG, to, cc, bcc []string, subject, body string, attachments, inline []string, threadID string, opts composeOptions, sender senderOptions, out *outputWriter) error {
+	// Read body from stdin if not provided
+	if body == "" {
+		var err error
+		if body, err = readBodyFromStdin(); err != nil {
+			return err
+		}
+	}
+	body, extra, err := prepareSender(ctx, conn, sender, body, opts)
+	if err != nil {
+		return err
+	}
+	headers, parts, err := buildOutgoingMessage(to, cc, bcc, subject, body, attachments, inline, opts)
 	if err != nil {
 		return err
 	}
+	mergeHeaders(headers, extra)
 
-	// Determine multipart type
-	multipartType := "mixed"
+	// Determine multipart type, signing and encrypting if asked to
+	multipartType, parts, err := protectOutgoing(ctx, gpg.New(gpgBinary), conn, headers, parts, opts.sign, opts.encrypt)
+	if err != nil {
+		return err
+	}
 
 	// Send the message
-	// Note: SendParts API does not return the message ID of the sent message.
-	// This is a limitation of the Gmail API's messages.send endpoint when using
-	// raw RFC822 format. The API only confirms successful submission.
-	if err := conn.SendParts(ctx, gwcli.ThreadID(threadID), multipartType, headers, parts); err != nil {
+	sent, err := conn.SendParts(ctx, gwcli.ThreadID(threadID), multipartType, headers, parts)
+	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
 	if out.json {
-		// Message ID is not available from SendParts API
-		return out.writeJSON(map[string]string{"status": "sent"})
+		return out.writeJSON(map[string]string{"status": "sent", "id": sent.Id, "threadId": sent.ThreadId})
 	}
 
-	out.writeMessage("Message sent successfully")
+	out.writeMessage(fmt.Sprintf("Message sent successfully (ID: %s, thread: %s)", sent.Id, sent.ThreadId))
 	return nil
 }
 
 // runMessagesDraft creates a draft email instead of sending it.
-func runMessagesDraft(ctx context.Context, conn *gwcli.CmdG, to, cc, bcc []string, subject, body string, attachments []string, html bool, threadID string, out *outputWriter) error {
-	headers, parts, err := buildOutgoingMessage(to, cc, bcc, subject, body, attachments, html)
+func runMessagesDraft(ctx context.Context, conn *gwcli.CmdG, to, cc, bcc []string, subject, body string, attachments, inline []string, threadID string, opts composeOptions, sender senderOptions, out *outputWriter) error {
+	// Read body from stdin if not provided
+	if body == "" {
+		var err error
+		if body, err = readBodyFromStdin(); err != nil {
+			return err
+		}
+	}
+	body, extra, err := prepareSender(ctx, conn, sender, body, opts)
+	if err != nil {
+		return err
+	}
+	headers, parts, err := buildOutgoingMessage(to, cc, bcc, subject, body, attachments, inline, opts)
 	if err != nil {
 		return err
 	}
+	mergeHeaders(headers, extra)
 
-	multipartType := "mixed"
+	multipartType, parts, err := protectOutgoing(ctx, gpg.New(gpgBinary), conn, headers, parts, opts.sign, opts.encrypt)
+	if err != nil {
+		return err
+	}
 
 	draftID, err := conn.DraftParts(ctx, gwcli.ThreadID(threadID), multipartType, headers, parts)
 	if err != nil {
@@ -860,148 +1050,59 @@ func runMessagesDraft(ctx context.Context, conn *gwcli.CmdG, to, cc, bcc []strin
 }
 
 // runMessagesDelete deletes messages (moves to trash)
-func runMessagesDelete(ctx context.Context, conn *gwcli.CmdG, messageID string, stdin bool, verbose bool, out *outputWriter) error {
-	var ids []string
-	var err error
-
-	if stdin {
-		ids, err = readIDsFromStdin()
-		if err != nil {
-			return err
-		}
-	} else {
-		if messageID == "" {
-			return fmt.Errorf("either provide message ID or use --stdin")
-		}
-		ids = []string{messageID}
+func runMessagesDelete(ctx context.Context, conn *gwcli.CmdG, messageID string, force bool, sel bulkFlags, verbose bool, out *outputWriter) error {
+	if !force && !sel.DryRun {
+		return fmt.Errorf("--force is required to delete messages")
 	}
-
-	// Batch delete
-	if len(ids) == 1 {
-		// Single message
-		if err := conn.BatchTrash(ctx, ids); err != nil {
-			return fmt.Errorf("failed to delete message: %w", err)
-		}
-		if out.json {
-			return out.writeJSON(map[string]int{"deleted": 1})
-		}
-		out.writeMessage("Message deleted")
-		return nil
+	ids, err := sel.messageIDs(ctx, conn, messageID, "message ID", out)
+	if err != nil {
+		return err
 	}
-
-	// Multiple messages
-	bp := newBatchProcessor(len(ids), verbose)
-	err = bp.process(ctx, ids, func(ctx context.Context, id string) error {
-		return conn.BatchTrash(ctx, []string{id})
-	})
-
-	if out.json {
-		return out.writeJSON(map[string]int{
-			"deleted": bp.processed - len(bp.errors),
-			"errors":  len(bp.errors),
-		})
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, "delete", fmt.Sprintf("delete %s messages", sel.matched(ids)), out)
 	}
 
-	bp.report(os.Stdout)
-	return err
+	bp := newBatchProcessor(len(ids), verbose)
+	bp.modify(ctx, conn, ids, []string{gwcli.Trash}, nil)
+	return bp.finish(out, "deleted", "Message deleted")
 }
 
 // runMessagesMarkRead marks messages as read
-func runMessagesMarkRead(ctx context.Context, conn *gwcli.CmdG, messageID string, stdin bool, verbose bool, out *outputWriter) error {
-	var ids []string
-	var err error
-
-	if stdin {
-		ids, err = readIDsFromStdin()
-		if err != nil {
-			return err
-		}
-	} else {
-		if messageID == "" {
-			return fmt.Errorf("either provide message ID or use --stdin")
-		}
-		ids = []string{messageID}
+func runMessagesMarkRead(ctx context.Context, conn *gwcli.CmdG, messageID string, sel bulkFlags, verbose bool, out *outputWriter) error {
+	ids, err := sel.messageIDs(ctx, conn, messageID, "message ID", out)
+	if err != nil {
+		return err
+	}
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, "mark-read", fmt.Sprintf("mark %s messages as read", sel.matched(ids)), out)
 	}
 
 	// Remove UNREAD label
-	unreadLabelID := gwcli.Unread
-
-	// Batch operation
 	bp := newBatchProcessor(len(ids), verbose)
-	err = bp.process(ctx, ids, func(ctx context.Context, id string) error {
-		return conn.BatchUnlabel(ctx, []string{id}, unreadLabelID)
-	})
-
-	if out.json {
-		return out.writeJSON(map[string]int{
-			"marked": bp.processed - len(bp.errors),
-			"errors": len(bp.errors),
-		})
-	}
-
-	if len(ids) == 1 {
-		out.writeMessage("Message marked as read")
-	} else {
-		bp.report(os.Stdout)
-	}
-	return err
+	bp.modify(ctx, conn, ids, nil, []string{gwcli.Unread})
+	return bp.finish(out, "marked", "Message marked as read")
 }
 
 // runMessagesMarkUnread marks messages as unread
-func runMessagesMarkUnread(ctx context.Context, conn *gwcli.CmdG, messageID string, stdin bool, verbose bool, out *outputWriter) error {
-	var ids []string
-	var err error
-
-	if stdin {
-		ids, err = readIDsFromStdin()
-		if err != nil {
-			return err
-		}
-	} else {
-		if messageID == "" {
-			return fmt.Errorf("either provide message ID or use --stdin")
-		}
-		ids = []string{messageID}
+func runMessagesMarkUnread(ctx context.Context, conn *gwcli.CmdG, messageID string, sel bulkFlags, verbose bool, out *outputWriter) error {
+	ids, err := sel.messageIDs(ctx, conn, messageID, "message ID", out)
+	if err != nil {
+		return err
+	}
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, "mark-unread", fmt.Sprintf("mark %s messages as unread", sel.matched(ids)), out)
 	}
 
 	// Add UNREAD label
-	unreadLabelID := gwcli.Unread
-
-	// Batch operation
 	bp := newBatchProcessor(len(ids), verbose)
-	err = bp.process(ctx, ids, func(ctx context.Context, id string) error {
-		return conn.BatchLabel(ctx, []string{id}, unreadLabelID)
-	})
-
-	if out.json {
-		return out.writeJSON(map[string]int{
-			"marked": bp.processed - len(bp.errors),
-			"errors": len(bp.errors),
-		})
-	}
-
-	if len(ids) == 1 {
-		out.writeMessage("Message marked as unread")
-	} else {
-		bp.report(os.Stdout)
-	}
-	return err
+	bp.modify(ctx, conn, ids, []string{gwcli.Unread}, nil)
+	return bp.finish(out, "marked", "Message marked as unread")
 }
 
-func runMessagesMove(ctx context.Context, conn *gwcli.CmdG, messageID, toLabelName string, stdin bool, verbose bool, out *outputWriter) error {
-	var ids []string
-	var err error
-
-	if stdin {
-		ids, err = readIDsFromStdin()
-		if err != nil {
-			return err
-		}
-	} else {
-		if messageID == "" {
-			return fmt.Errorf("either provide message ID or use --stdin")
-		}
-		ids = []string{messageID}
+func runMessagesMove(ctx context.Context, conn *gwcli.CmdG, messageID, toLabelName string, sel bulkFlags, verbose bool, out *outputWriter) error {
+	ids, err := sel.messageIDs(ctx, conn, messageID, "message ID", out)
+	if err != nil {
+		return err
 	}
 
 	out.writeVerbose("Loading labels from config...")
@@ -1029,55 +1130,18 @@ func runMessagesMove(ctx context.Context, conn *gwcli.CmdG, messageID, toLabelNa
 		return fmt.Errorf("label not found: %s", toLabelName)
 	}
 
-	// Batch operation
 	// Note: "Move" in Gmail means adding the destination label and removing INBOX.
 	// This implements Gmail's label-based filing system where messages can have
-	// multiple labels. The move operation adds the target label and removes INBOX
-	// to achieve the traditional "move" behavior users expect.
-	bp := newBatchProcessor(len(ids), verbose)
-	err = bp.process(ctx, ids, func(ctx context.Context, id string) error {
-		// Get current labels
-		msg := gwcli.NewMessage(conn, id)
-		if err := msg.Preload(ctx, gwcli.LevelMinimal); err != nil {
-			return err
-		}
-
-		// Remove INBOX if present
-		removeLabels := []string{}
-		if msg.Response != nil {
-			for _, labelID := range msg.Response.LabelIds {
-				if labelID == gwcli.Inbox {
-					removeLabels = append(removeLabels, labelID)
-				}
-			}
-		}
-
-		// Add new label
-		if err := conn.BatchLabel(ctx, []string{id}, toLabelID); err != nil {
-			return err
-		}
-
-		// Remove old labels
-		if len(removeLabels) > 0 {
-			if err := conn.BatchUnlabel(ctx, []string{id}, removeLabels[0]); err != nil {
-				return err
-			}
-		}
-
-		return nil
-	})
-
-	if out.json {
-		return out.writeJSON(map[string]int{
-			"moved":  bp.processed - len(bp.errors),
-			"errors": len(bp.errors),
-		})
+	// multiple labels. Removing INBOX from a message that is not in the inbox
+	// is a no-op, so both changes go into the same BatchModify call.
+	remove := []string{gwcli.Inbox}
+	if toLabelID == gwcli.Inbox {
+		remove = nil
 	}
-
-	if len(ids) == 1 {
-		out.writeMessage(fmt.Sprintf("Message moved to %s", toLabelName))
-	} else {
-		bp.report(os.Stdout)
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, "move", fmt.Sprintf("move %s messages to %s", sel.matched(ids), toLabelName), out)
 	}
-	return err
+	bp := newBatchProcessor(len(ids), verbose)
+	bp.modify(ctx, conn, ids, []string{toLabelID}, remove)
+	return bp.finish(out, "moved", fmt.Sprintf("Message moved to %s", toLabelName))
 }
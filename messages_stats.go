@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+)
+
+// messagesStatsCmd holds the flags for `gwcli messages stats`.
+type messagesStatsCmd struct {
+	Query   string `help:"Gmail search query selecting the messages to count (default: all mail)"`
+	GroupBy string `help:"Group by sender, domain, label, day or week" name:"group-by" enum:"sender,domain,label,day,week" default:"sender"`
+	Top     int    `help:"Show only the top N senders, domains or labels (0 = all)" default:"20"`
+	Largest int    `help:"Instead of grouping, list the N largest of the newest --max messages (add larger:<size> to --query to search further back)"`
+	Max     int    `help:"Count at most this many messages, newest first (0 = no limit)" default:"5000"`
+}
+
+// messageStat is the metadata stats are computed from.
+type messageStat struct {
+	ID      string
+	From    string
+	Subject string
+	Time    time.Time
+	Size    int64
+	Labels  []string
+	Unread  bool
+}
+
+// statsGroup is JSON output for one group of messages stats.
+type statsGroup struct {
+	Key         string  `json:"key"`
+	Messages    int     `json:"messages"`
+	Unread      int     `json:"unread"`
+	UnreadRatio float64 `json:"unreadRatio"`
+	TotalSize   int64   `json:"totalSize"`
+}
+
+// largestMessage is JSON output for messages stats --largest.
+type largestMessage struct {
+	ID      string `json:"id"`
+	Date    string `json:"date"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Size    int64  `json:"size"`
+}
+
+// statsConcurrency is how many messages stats loads at once. Each Get
+// costs 5 quota units against Gmail's per-user limit of 250 per second.
+const statsConcurrency = 10
+
+// loadMessageStat fetches the metadata of one message, backing off and
+// retrying when rate limited.
+func loadMessageStat(ctx context.Context, conn *gwcli.CmdG, id string, out *outputWriter) (*messageStat, error) {
+	msg := gwcli.NewMessage(conn, id)
+	err := retryAPI(ctx, func() error {
+		return msg.Preload(ctx, gwcli.LevelMetadata)
+	}, func(err error, delay time.Duration) {
+		out.writeVerbose("Retrying message %s in %v: %v", id, delay, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &messageStat{ID: id, Labels: msg.LocalLabels()}
+	s.From, _ = msg.GetHeader(ctx, "From")
+	s.Subject, _ = msg.GetHeader(ctx, "Subject")
+	if msg.Response != nil {
+		s.Size = msg.Response.SizeEstimate
+		if msg.Response.InternalDate > 0 {
+			s.Time = time.UnixMilli(msg.Response.InternalDate).Local()
+		}
+	}
+	if s.Time.IsZero() {
+		s.Time, _ = msg.GetTime(ctx)
+	}
+	for _, l := range s.Labels {
+		if l == gwcli.Unread {
+			s.Unread = true
+		}
+	}
+	return s, nil
+}
+
+// loadMessageStats fetches the metadata of ids concurrently. Messages that
+// still fail after retrying are left out and counted in the second return
+// value.
+func loadMessageStats(ctx context.Context, conn *gwcli.CmdG, ids []string, out *outputWriter) ([]messageStat, int) {
+	loaded := make([]*messageStat, len(ids))
+	sem := make(chan struct{}, statsConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			s, err := loadMessageStat(ctx, conn, id, out)
+			if err != nil {
+				out.writeVerbose("Failed to load message %s: %v", id, err)
+				return
+			}
+			loaded[i] = s
+		}(i, id)
+	}
+	wg.Wait()
+
+	var ret []messageStat
+	failed := 0
+	for _, s := range loaded {
+		if s == nil {
+			failed++
+			continue
+		}
+		ret = append(ret, *s)
+	}
+	return ret, failed
+}
+
+// statsKeys returns the groups a message counts towards. A message counts
+// once per label when grouping by label.
+func statsKeys(s messageStat, groupBy string, labelNames map[string]string) []string {
+	switch groupBy {
+	case "domain":
+		addr := senderAddress(s.From)
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			return []string{addr[i+1:]}
+		}
+		return []string{addr}
+	case "label":
+		var keys []string
+		for _, id := range s.Labels {
+			if name, ok := labelNames[id]; ok {
+				keys = append(keys, name)
+			} else {
+				keys = append(keys, id)
+			}
+		}
+		return keys
+	case "day":
+		if s.Time.IsZero() {
+			return []string{"unknown"}
+		}
+		return []string{s.Time.Format("2006-01-02")}
+	case "week":
+		if s.Time.IsZero() {
+			return []string{"unknown"}
+		}
+		year, week := s.Time.ISOWeek()
+		return []string{fmt.Sprintf("%d-W%02d", year, week)}
+	}
+	return []string{senderAddress(s.From)}
+}
+
+// groupMessageStats totals stats per group. Day and week groups are sorted
+// by date; the others by message count, largest first.
+func groupMessageStats(stats []messageStat, groupBy string, labelNames map[string]string) []statsGroup {
+	byKey := map[string]*statsGroup{}
+	for _, s := range stats {
+		for _, k := range statsKeys(s, groupBy, labelNames) {
+			g, ok := byKey[k]
+			if !ok {
+				g = &statsGroup{Key: k}
+				byKey[k] = g
+			}
+			g.Messages++
+			g.TotalSize += s.Size
+			if s.Unread {
+				g.Unread++
+			}
+		}
+	}
+
+	groups := make([]statsGroup, 0, len(byKey))
+	for _, g := range byKey {
+		g.UnreadRatio = float64(g.Unread) / float64(g.Messages)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if groupBy != "day" && groupBy != "week" && a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Key < b.Key
+	})
+	return groups
+}
+
+// runMessagesStats reports message counts, sizes and unread ratios per
+// sender, domain, label, day or week, or lists the largest messages. Only
+// the newest --max matching messages are looked at. Messages that cannot be
+// loaded are left out of the report, which then ends in an error.
+func runMessagesStats(ctx context.Context, conn *gwcli.CmdG, c messagesStatsCmd, out *outputWriter) error {
+	out.writeVerbose("Searching with query: %s", c.Query)
+	ids, more, err := conn.ListMessageIDsN(ctx, "", c.Query, c.Max)
+	if err != nil {
+		return fmt.Errorf("failed to search messages: %w", err)
+	}
+	if more {
+		hint := "raise --max to count more"
+		if c.Largest > 0 {
+			hint = `raise --max, or add "larger:<size>" to --query, to look further back`
+		}
+		fmt.Fprintf(os.Stderr, "Warning: only the newest %d matching messages are counted; %s\n", c.Max, hint)
+	}
+	out.writeVerbose("Loading metadata of %d messages...", len(ids))
+	stats, failed := loadMessageStats(ctx, conn, ids, out)
+	if err := writeMessagesStats(ctx, conn, c, stats, out); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages could not be loaded and are not counted", failed, len(ids))
+	}
+	return nil
+}
+
+// writeMessagesStats prints the groups, or the largest messages, of stats.
+func writeMessagesStats(ctx context.Context, conn *gwcli.CmdG, c messagesStatsCmd, stats []messageStat, out *outputWriter) error {
+	if c.Largest > 0 {
+		return writeLargestMessages(stats, c.Largest, out)
+	}
+
+	labelNames := map[string]string{}
+	if c.GroupBy == "label" {
+		if err := conn.LoadLabels(ctx, out.verbose); err != nil {
+			return fmt.Errorf("failed to load labels: %w", err)
+		}
+		for _, l := range conn.Labels() {
+			labelNames[l.ID] = l.Label
+		}
+	}
+	groups := groupMessageStats(stats, c.GroupBy, labelNames)
+	if c.Top > 0 && len(groups) > c.Top && c.GroupBy != "day" && c.GroupBy != "week" {
+		groups = groups[:c.Top]
+	}
+
+	if out.json {
+		return out.writeJSON(groups)
+	}
+	if len(groups) == 0 {
+		out.writeMessage("No messages found")
+		return nil
+	}
+	rows := make([][]string, len(groups))
+	for i, g := range groups {
+		rows[i] = []string{
+			g.Key,
+			strconv.Itoa(g.Messages),
+			strconv.Itoa(g.Unread),
+			fmt.Sprintf("%.0f%%", g.UnreadRatio*100),
+			formatSize(g.TotalSize),
+		}
+	}
+	return out.writeTable([]string{strings.ToUpper(c.GroupBy), "MESSAGES", "UNREAD", "UNREAD %", "SIZE"}, rows)
+}
+
+// writeLargestMessages prints the n largest messages.
+func writeLargestMessages(stats []messageStat, n int, out *outputWriter) error {
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Size > stats[j].Size })
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+
+	largest := make([]largestMessage, len(stats))
+	for i, s := range stats {
+		largest[i] = largestMessage{ID: s.ID, From: s.From, Subject: s.Subject, Size: s.Size}
+		if !s.Time.IsZero() {
+			largest[i].Date = s.Time.Format("2006-01-02")
+		}
+	}
+	if out.json {
+		return out.writeJSON(largest)
+	}
+	if len(largest) == 0 {
+		out.writeMessage("No messages found")
+		return nil
+	}
+	rows := make([][]string, len(largest))
+	for i, m := range largest {
+		rows[i] = []string{m.ID, m.Date, truncateString(m.From, 30), truncateString(m.Subject, 40), formatSize(m.Size)}
+	}
+	return out.writeTable([]string{"ID", "DATE", "FROM", "SUBJECT", "SIZE"}, rows)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// newFakeStatsConn serves the metadata of four messages from two domains,
+// all matching the query "newer_than:1y". Gets of a message in fail answer
+// with the listed status codes, in turn, before succeeding.
+func newFakeStatsConn(t *testing.T, fail map[string][]int) *gwcli.CmdG {
+	t.Helper()
+	day := func(d string) int64 {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", d+" 12:00", time.Local)
+		return tm.UnixMilli()
+	}
+	msgs := map[string]*gmail.Message{
+		"m1": {Id: "m1", SizeEstimate: 1000, InternalDate: day("2024-03-04"), LabelIds: []string{"INBOX", "UNREAD", "Label_1"}},
+		"m2": {Id: "m2", SizeEstimate: 5000, InternalDate: day("2024-03-04"), LabelIds: []string{"INBOX"}},
+		"m3": {Id: "m3", SizeEstimate: 200, InternalDate: day("2024-03-11"), LabelIds: []string{"UNREAD", "Label_1"}},
+		"m4": {Id: "m4", SizeEstimate: 40000, InternalDate: day("2024-03-12"), LabelIds: []string{"INBOX"}},
+	}
+	from := map[string]string{
+		"m1": "News <news@shop.example.com>",
+		"m2": "news@shop.example.com",
+		"m3": "Sales <sales@shop.example.com>",
+		"m4": "Alice <alice@example.org>",
+	}
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		id := strings.TrimPrefix(path, "messages/")
+		switch {
+		case path == "labels":
+			return gmail.ListLabelsResponse{Labels: []*gmail.Label{{Id: "Label_1", Name: "Promotions", Type: "user"}}}
+		case path == "messages" && req.URL.Query().Get("q") == "newer_than:1y":
+			return messageList("m1", "m2", "m3", "m4")
+		case msgs[id] != nil:
+			if codes := fail[id]; len(codes) > 0 {
+				fail[id] = codes[1:]
+				return fakeError{code: codes[0], message: "failed"}
+			}
+			m := *msgs[id]
+			m.Payload = &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: from[id]},
+				{Name: "Subject", Value: "Subject " + id},
+			}}
+			return &m
+		}
+		return nil
+	})
+}
+
+func TestRunMessagesStats_GroupBy(t *testing.T) {
+	tests := []struct {
+		groupBy string
+		want    []statsGroup
+	}{
+		{"sender", []statsGroup{
+			{Key: "news@shop.example.com", Messages: 2, Unread: 1, UnreadRatio: 0.5, TotalSize: 6000},
+			{Key: "alice@example.org", Messages: 1, TotalSize: 40000},
+			{Key: "sales@shop.example.com", Messages: 1, Unread: 1, UnreadRatio: 1, TotalSize: 200},
+		}},
+		{"domain", []statsGroup{
+			{Key: "shop.example.com", Messages: 3, Unread: 2, UnreadRatio: 2.0 / 3, TotalSize: 6200},
+			{Key: "example.org", Messages: 1, TotalSize: 40000},
+		}},
+		{"label", []statsGroup{
+			{Key: "INBOX", Messages: 3, Unread: 1, UnreadRatio: 1.0 / 3, TotalSize: 46000},
+			{Key: "Promotions", Messages: 2, Unread: 2, UnreadRatio: 1, TotalSize: 1200},
+			{Key: "UNREAD", Messages: 2, Unread: 2, UnreadRatio: 1, TotalSize: 1200},
+		}},
+		{"week", []statsGroup{
+			{Key: "2024-W10", Messages: 2, Unread: 1, UnreadRatio: 0.5, TotalSize: 6000},
+			{Key: "2024-W11", Messages: 2, Unread: 1, UnreadRatio: 0.5, TotalSize: 40200},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			var buf bytes.Buffer
+			out := &outputWriter{json: true, writer: &buf}
+			c := messagesStatsCmd{Query: "newer_than:1y", GroupBy: tt.groupBy, Top: 20}
+			if err := runMessagesStats(context.Background(), newFakeStatsConn(t, nil), c, out); err != nil {
+				t.Fatalf("runMessagesStats() error = %v", err)
+			}
+			var got []statsGroup
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("group %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunMessagesStats_Largest(t *testing.T) {
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	c := messagesStatsCmd{Query: "newer_than:1y", GroupBy: "sender", Largest: 2}
+	if err := runMessagesStats(context.Background(), newFakeStatsConn(t, nil), c, out); err != nil {
+		t.Fatalf("runMessagesStats() error = %v", err)
+	}
+	var got []largestMessage
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got) != 2 || got[0].ID != "m4" || got[1].ID != "m2" {
+		t.Fatalf("largest = %+v, want m4 then m2", got)
+	}
+	if got[0].Size != 40000 || got[0].Date != "2024-03-12" || got[0].Subject != "Subject m4" {
+		t.Errorf("largest[0] = %+v", got[0])
+	}
+}
+
+func TestRunMessagesStats_RetriesAndCountsFailures(t *testing.T) {
+	apiRetryDelay = time.Millisecond
+	defer func() { apiRetryDelay = time.Second }()
+
+	fail := map[string][]int{
+		"m1": {http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		"m3": {http.StatusNotFound},
+	}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	c := messagesStatsCmd{Query: "newer_than:1y", GroupBy: "domain"}
+	err := runMessagesStats(context.Background(), newFakeStatsConn(t, fail), c, out)
+	if err == nil || !strings.Contains(err.Error(), "1 of 4 messages could not be loaded") {
+		t.Fatalf("runMessagesStats() error = %v, want 1 of 4 failed", err)
+	}
+	var got []statsGroup
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	// m1 is counted after two retries; m3 is not found and left out.
+	if len(got) != 2 || got[0].Key != "shop.example.com" || got[0].Messages != 2 || got[1].Messages != 1 {
+		t.Errorf("groups = %+v", got)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+)
+
+// fakeGmailMessageList serves users.messages.list from a fixed set of IDs,
+// honoring pageToken (an offset) and maxResults like the real API does.
+func fakeGmailMessageList(t *testing.T, ids []string, req *http.Request) string {
+	t.Helper()
+	q := req.URL.Query()
+	start := 0
+	if tok := q.Get("pageToken"); tok != "" {
+		if _, err := fmt.Sscanf(tok, "tok%d", &start); err != nil {
+			t.Fatalf("bad page token %q", tok)
+		}
+	}
+	size := 100
+	if s := q.Get("maxResults"); s != "" {
+		fmt.Sscanf(s, "%d", &size)
+	}
+	end := start + size
+	if end > len(ids) {
+		end = len(ids)
+	}
+	var msgs []map[string]string
+	for _, id := range ids[start:end] {
+		msgs = append(msgs, map[string]string{"id": id, "threadId": id})
+	}
+	resp := map[string]interface{}{"messages": msgs}
+	if end < len(ids) {
+		resp["nextPageToken"] = fmt.Sprintf("tok%d", end)
+	}
+	b, _ := json.Marshal(resp)
+	return string(b)
+}
+
+// newFakeMessagesConn serves the messages ids, counting list calls.
+func newFakeMessagesConn(t *testing.T, ids []string, listCalls *int) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "labels":
+			return `{"labels":[]}`
+		case path == "messages":
+			*listCalls++
+			return fakeGmailMessageList(t, ids, req)
+		case strings.HasPrefix(path, "messages/"):
+			id := strings.TrimPrefix(path, "messages/")
+			return fmt.Sprintf(`{"id":%q,"threadId":%q,"payload":{"headers":[{"name":"Subject","value":"Subject %s"}]}}`, id, id, id)
+		}
+		return nil
+	})
+}
+
+func TestRunMessagesSearch_FollowsPages(t *testing.T) {
+	var ids []string
+	for i := 0; i < 250; i++ {
+		ids = append(ids, fmt.Sprintf("M%03d", i))
+	}
+	var listCalls int
+	conn := newFakeMessagesConn(t, ids, &listCalls)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesSearch(context.Background(), conn, "older_than:1y", 150, "", out); err != nil {
+		t.Fatalf("runMessagesSearch() error = %v", err)
+	}
+
+	// Without --page-token the output stays a bare array.
+	var got []messageListOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got) != 150 {
+		t.Fatalf("expected 150 messages, got %d", len(got))
+	}
+	if got[149].ID != "M149" {
+		t.Errorf("last message = %s, want M149", got[149].ID)
+	}
+	if listCalls != 2 {
+		t.Errorf("expected 2 list calls, got %d", listCalls)
+	}
+
+	// Resuming from a token picks up exactly where the previous run
+	// stopped and reports the token to continue from.
+	buf.Reset()
+	if err := runMessagesSearch(context.Background(), conn, "older_than:1y", 50, "tok150", out); err != nil {
+		t.Fatalf("runMessagesSearch() resume error = %v", err)
+	}
+	var page messageListPage
+	if err := json.Unmarshal(buf.Bytes(), &page); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(page.Messages) != 50 || page.Messages[0].ID != "M150" || page.NextPageToken != "tok200" {
+		t.Fatalf("resume returned %d messages starting at %v, next %q", len(page.Messages), page.Messages, page.NextPageToken)
+	}
+
+	buf.Reset()
+	if err := runMessagesSearch(context.Background(), conn, "older_than:1y", 0, page.NextPageToken, out); err != nil {
+		t.Fatalf("runMessagesSearch() resume error = %v", err)
+	}
+	var rest messageListPage
+	if err := json.Unmarshal(buf.Bytes(), &rest); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if len(rest.Messages) != 50 || rest.Messages[0].ID != "M200" {
+		t.Fatalf("resume returned %d messages starting at %v", len(rest.Messages), rest.Messages)
+	}
+	if rest.NextPageToken != "" {
+		t.Errorf("expected no nextPageToken after exhausting results, got %q", rest.NextPageToken)
+	}
+}
+
+func TestRunMessagesList_Empty(t *testing.T) {
+	var listCalls int
+	conn := newFakeMessagesConn(t, nil, &listCalls)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesList(context.Background(), conn, "", 50, false, "", out); err != nil {
+		t.Fatalf("runMessagesList() error = %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "[]" {
+		t.Errorf("expected an empty array, got %s", got)
+	}
+}
+
+func TestRunMessagesSend_ReturnsIDs(t *testing.T) {
+	var threadID string
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "messages/send" {
+			return nil
+		}
+		var msg struct {
+			ThreadID string `json:"threadId"`
+		}
+		decodeRequest(t, req, &msg)
+		threadID = msg.ThreadID
+		return `{"id":"S9","threadId":"T9","labelIds":["SENT"]}`
+	})
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesSend(context.Background(), conn, []string{"a@example.com"}, nil, nil, "Hi", "Hello",
+		nil, nil, "T9", composeOptions{}, senderOptions{}, out); err != nil {
+		t.Fatalf("runMessagesSend() error = %v", err)
+	}
+	if threadID != "T9" {
+		t.Errorf("sent threadId = %q, want T9", threadID)
+	}
+	var res map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res["status"] != "sent" || res["id"] != "S9" || res["threadId"] != "T9" {
+		t.Errorf("output = %v, want status sent, id S9, threadId T9", res)
+	}
+}
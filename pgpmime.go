@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gpg"
+	"github.com/wesnick/gwcli/pkg/gwcli"
+)
+
+// gpgBinary is the gpg executable used to sign and encrypt outgoing mail.
+var gpgBinary = "gpg"
+
+// canonicalCRLF converts all line endings to CRLF, the canonical form that
+// PGP/MIME signatures are computed over.
+func canonicalCRLF(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// entityBytes renders a part the way multipart.Writer writes it (headers
+// sorted by name), which is what a multipart/signed signature must cover.
+func entityBytes(p *gwcli.Part) string {
+	keys := make([]string, 0, len(p.Header))
+	for k := range p.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range p.Header[k] {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	b.WriteString("\r\n")
+	b.WriteString(p.Contents)
+	return b.String()
+}
+
+// headerAddresses returns the bare addresses in the named headers.
+func headerAddresses(head mail.Header, names ...string) ([]string, error) {
+	var addrs []string
+	for _, name := range names {
+		for _, v := range head[name] {
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			list, err := mail.ParseAddressList(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+			}
+			for _, a := range list {
+				addrs = append(addrs, a.Address)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// ownAddress returns the address outgoing mail is sent from: the From
+// header if set, otherwise the account's own address, which Gmail uses.
+func ownAddress(ctx context.Context, conn *gwcli.CmdG, head mail.Header) (string, error) {
+	from, err := headerAddresses(head, "From")
+	if err != nil {
+		return "", err
+	}
+	if len(from) > 0 {
+		return from[0], nil
+	}
+	profile, err := conn.GetProfile(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get account address: %w", err)
+	}
+	return profile.EmailAddress, nil
+}
+
+// protectOutgoing wraps the parts of an outgoing message in RFC 3156
+// PGP/MIME. It returns the multipart type (with parameters) and parts to
+// pass to SendParts or DraftParts. Recipient keys are looked up from the
+// To and Cc addresses, and Bcc addresses are added as hidden recipients.
+// The sender's key is always a recipient too, or the message could not be
+// read from Sent. Signatures are made with the key of the From address
+// (the default key without one), so mail sent from an alias is signed by
+// that identity. Without sign or encrypt the parts are returned as a
+// plain multipart/mixed.
+func protectOutgoing(ctx context.Context, g *gpg.GPG, conn *gwcli.CmdG, head mail.Header, parts []*gwcli.Part, sign, encrypt bool) (string, []*gwcli.Part, error) {
+	if !sign && !encrypt {
+		return "mixed", parts, nil
+	}
+
+	inner, err := multipartPart("mixed", parts)
+	if err != nil {
+		return "", nil, err
+	}
+	inner.Contents = canonicalCRLF(inner.Contents)
+	entity := entityBytes(inner)
+
+	signer := ""
+	if sign {
+		from, err := headerAddresses(head, "From")
+		if err != nil {
+			return "", nil, err
+		}
+		if len(from) > 0 {
+			signer = from[0]
+		}
+	}
+
+	if encrypt {
+		recipients, err := headerAddresses(head, "To", "Cc")
+		if err != nil {
+			return "", nil, err
+		}
+		hidden, err := headerAddresses(head, "Bcc")
+		if err != nil {
+			return "", nil, err
+		}
+		self, err := ownAddress(ctx, conn, head)
+		if err != nil {
+			return "", nil, err
+		}
+		enc, err := g.Encrypt(ctx, entity, recipients, hidden, self, signer, sign)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		return `encrypted; protocol="application/pgp-encrypted"`, []*gwcli.Part{
+			{
+				Header: textproto.MIMEHeader{
+					"Content-Type":        {"application/pgp-encrypted"},
+					"Content-Description": {"PGP/MIME version identification"},
+				},
+				Contents: "Version: 1\r\n",
+			},
+			{
+				Header: textproto.MIMEHeader{
+					"Content-Type":        {`application/octet-stream; name="encrypted.asc"`},
+					"Content-Description": {"OpenPGP encrypted message"},
+					"Content-Disposition": {`inline; filename="encrypted.asc"`},
+				},
+				Contents: enc,
+			},
+		}, nil
+	}
+
+	sig, micalg, err := g.Sign(ctx, entity, signer)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+	return fmt.Sprintf(`signed; micalg=%s; protocol="application/pgp-signature"`, micalg), []*gwcli.Part{
+		inner,
+		{
+			Header: textproto.MIMEHeader{
+				"Content-Type":        {`application/pgp-signature; name="signature.asc"`},
+				"Content-Description": {"OpenPGP digital signature"},
+				"Content-Disposition": {`attachment; filename="signature.asc"`},
+			},
+			Contents: sig,
+		},
+	}, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gpg"
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// setupTestGPG creates a throwaway keyring with a passphrase-less key for
+// alice@example.com.
+func setupTestGPG(t *testing.T) *gpg.GPG {
+	t.Helper()
+	if _, err := exec.LookPath(gpgBinary); err != nil {
+		t.Skip("gpg not installed")
+	}
+	t.Setenv("GNUPGHOME", t.TempDir())
+	cmd := exec.Command(gpgBinary, "--batch", "--passphrase", "", "--quick-gen-key",
+		"Alice <alice@example.com>", "future-default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generate key: %v\n%s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("gpgconf", "--kill", "gpg-agent").Run() })
+	return gpg.New(gpgBinary)
+}
+
+// sentRaw sends a message through runMessagesSend with a fake API and
+// returns the raw RFC822 message that was submitted.
+func sentRaw(t *testing.T, sign, encrypt bool) string {
+	t.Helper()
+	var sent gmail.Message
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch path {
+		case "profile":
+			return `{"emailAddress":"alice@example.com"}`
+		case "messages/send":
+			decodeRequest(t, req, &sent)
+			return `{"id":"S1","threadId":"S1"}`
+		}
+		return nil
+	})
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	if err := runMessagesSend(context.Background(), conn, []string{"Alice <alice@example.com>"}, nil, nil, "Secret plans",
+		"line one\nline two\n", nil, nil, "", composeOptions{sign: sign, encrypt: encrypt}, senderOptions{}, out); err != nil {
+		t.Fatalf("runMessagesSend() error = %v", err)
+	}
+	return decodeSent(t, &sent)
+}
+
+// splitMultipart returns the raw bytes (headers and body) of each part of a
+// multipart body, exactly as they appear on the wire.
+func splitMultipart(t *testing.T, raw string) (string, []string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parse Content-Type: %v", err)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	delim := "\r\n--" + params["boundary"]
+	chunks := strings.Split("\r\n"+string(body), delim)
+	var parts []string
+	for _, c := range chunks[1 : len(chunks)-1] {
+		parts = append(parts, strings.TrimPrefix(c, "\r\n"))
+	}
+	return mediaType + "; micalg=" + params["micalg"] + "; protocol=" + params["protocol"], parts
+}
+
+func TestRunMessagesSend_SignPGPMIME(t *testing.T) {
+	g := setupTestGPG(t)
+	raw := sentRaw(t, true, false)
+
+	ct, parts := splitMultipart(t, raw)
+	if !strings.HasPrefix(ct, "multipart/signed; micalg=pgp-") || !strings.HasSuffix(ct, "protocol=application/pgp-signature") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+	if !strings.Contains(parts[0], "line one\r\nline two\r\n") {
+		t.Errorf("signed part should carry the body with CRLF line endings:\n%q", parts[0])
+	}
+
+	sigMsg, err := mail.ReadMessage(strings.NewReader(parts[1]))
+	if err != nil {
+		t.Fatalf("parse signature part: %v", err)
+	}
+	if got := sigMsg.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/pgp-signature") {
+		t.Errorf("signature Content-Type = %q", got)
+	}
+	sig, _ := io.ReadAll(sigMsg.Body)
+
+	status, err := g.Verify(context.Background(), parts[0], string(sig))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !status.GoodSignature || status.Signed != "Alice <alice@example.com>" {
+		t.Errorf("signature status = %+v", status)
+	}
+}
+
+func TestRunMessagesSend_EncryptPGPMIME(t *testing.T) {
+	g := setupTestGPG(t)
+	raw := sentRaw(t, true, true)
+
+	ct, parts := splitMultipart(t, raw)
+	if !strings.HasPrefix(ct, "multipart/encrypted;") || !strings.HasSuffix(ct, "protocol=application/pgp-encrypted") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if len(parts) != 2 || !strings.Contains(parts[0], "Version: 1") {
+		t.Fatalf("unexpected parts: %q", parts)
+	}
+	if strings.Contains(raw, "line one") {
+		t.Error("body leaked in clear text")
+	}
+
+	encMsg, err := mail.ReadMessage(strings.NewReader(parts[1]))
+	if err != nil {
+		t.Fatalf("parse encrypted part: %v", err)
+	}
+	enc, _ := io.ReadAll(encMsg.Body)
+	dec, status, err := g.Decrypt(context.Background(), string(enc))
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !strings.Contains(dec, "line one\r\nline two") {
+		t.Errorf("decrypted entity = %q", dec)
+	}
+	if !status.GoodSignature {
+		t.Errorf("expected the encrypted message to be signed, got %+v", status)
+	}
+}
+
+func TestProtectOutgoing_MissingRecipientKey(t *testing.T) {
+	g := setupTestGPG(t)
+	head := mail.Header{"From": {"alice@example.com"}, "To": {"alice@example.com"}, "Bcc": {"mallory@example.com"}}
+	_, _, err := protectOutgoing(context.Background(), g, nil, head, []*gwcli.Part{textPart("plain", "hi")}, false, true)
+	if err == nil || !strings.Contains(err.Error(), "mallory@example.com") {
+		t.Errorf("error = %v, want missing key for mallory@example.com", err)
+	}
+}
+
+func TestProtectOutgoing_EncryptsToSender(t *testing.T) {
+	g := setupTestGPG(t)
+	cmd := exec.Command(gpgBinary, "--batch", "--passphrase", "", "--quick-gen-key",
+		"Bob <bob@example.com>", "future-default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generate key: %v\n%s", err, out)
+	}
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "profile" {
+			return nil
+		}
+		return `{"emailAddress":"alice@example.com"}`
+	})
+
+	head := mail.Header{"To": {"bob@example.com"}}
+	_, parts, err := protectOutgoing(context.Background(), g, conn, head, []*gwcli.Part{textPart("plain", "hi")}, false, true)
+	if err != nil {
+		t.Fatalf("protectOutgoing() error = %v", err)
+	}
+	cmd = exec.Command(gpgBinary, "--batch", "--list-packets")
+	cmd.Stdin = strings.NewReader(parts[1].Contents)
+	packets, _ := cmd.Output()
+	if n := strings.Count(string(packets), ":pubkey enc packet:"); n != 2 {
+		t.Errorf("encrypted to %d keys, want bob and the sender:\n%s", n, packets)
+	}
+}
+
+func TestProtectOutgoing_SignsWithFromKey(t *testing.T) {
+	g := setupTestGPG(t)
+	cmd := exec.Command(gpgBinary, "--batch", "--passphrase", "", "--quick-gen-key",
+		"Bob <bob@example.com>", "future-default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generate key: %v\n%s", err, out)
+	}
+
+	head := mail.Header{"From": {"Bob <bob@example.com>"}}
+	_, parts, err := protectOutgoing(context.Background(), g, nil, head, []*gwcli.Part{textPart("plain", "Grüße\n")}, true, false)
+	if err != nil {
+		t.Fatalf("protectOutgoing() error = %v", err)
+	}
+	if !strings.Contains(parts[0].Contents, "Content-Transfer-Encoding: quoted-printable") {
+		t.Errorf("signed 8-bit text should be quoted-printable:\n%q", parts[0].Contents)
+	}
+	status, err := g.Verify(context.Background(), entityBytes(parts[0]), parts[1].Contents)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !status.GoodSignature || status.Signed != "Bob <bob@example.com>" {
+		t.Errorf("signature status = %+v, want a good signature by Bob", status)
+	}
+}
+
+// signedRaw returns a complete multipart/signed RFC822 message for body.
+func signedRaw(t *testing.T, g *gpg.GPG, body string) string {
+	t.Helper()
+	mp, parts, err := protectOutgoing(context.Background(), g, nil, nil, []*gwcli.Part{textPart("plain", body)}, true, false)
+	if err != nil {
+		t.Fatalf("protectOutgoing() error = %v", err)
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		pw, err := w.CreatePart(p.Header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw.Write([]byte(p.Contents))
+	}
+	w.Close()
+	return "From: Alice <alice@example.com>\r\nTo: bob@example.com\r\nSubject: Signed\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/" + mp + "; boundary=" + w.Boundary() + "\r\n\r\n" + buf.String()
+}
+
+// newFakeReadConn serves message M1 from raw in the metadata, full and raw
+// formats.
+func newFakeReadConn(t *testing.T, raw string) *gwcli.CmdG {
+	t.Helper()
+	m, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	var headers []*gmail.MessagePartHeader
+	for k, vs := range m.Header {
+		headers = append(headers, &gmail.MessagePartHeader{Name: k, Value: vs[0]})
+	}
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "messages/M1" {
+			return nil
+		}
+		msg := &gmail.Message{
+			Id:       "M1",
+			ThreadId: "T1",
+			Payload:  &gmail.MessagePart{MimeType: mediaType, Headers: headers},
+		}
+		switch format := req.URL.Query().Get("format"); {
+		case strings.EqualFold(format, "raw"):
+			msg = &gmail.Message{Id: "M1", Raw: base64.URLEncoding.EncodeToString([]byte(raw))}
+		case strings.EqualFold(format, "full") && mediaType == "multipart/signed":
+			msg.Payload.Parts = []*gmail.MessagePart{
+				{MimeType: "multipart/mixed", Body: &gmail.MessagePartBody{}},
+				{MimeType: "application/pgp-signature", Body: &gmail.MessagePartBody{}},
+			}
+		}
+		return msg
+	})
+}
+
+// readSignature reads message M1 from raw as JSON and returns the reported
+// signature. A failed read must not write anything.
+func readSignature(t *testing.T, raw string, requireSigned bool) (*signatureInfo, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	err := runMessagesRead(context.Background(), newFakeReadConn(t, raw), "M1", false, true, false, false, requireSigned, out)
+	if err != nil {
+		if buf.Len() > 0 {
+			t.Errorf("output written before failing: %s", buf.String())
+		}
+		return nil, err
+	}
+	var got messageReadOutput
+	if jerr := json.Unmarshal(buf.Bytes(), &got); jerr != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", jerr, buf.String())
+	}
+	return got.Signature, nil
+}
+
+// setupReadGPG points message verification at a fresh test keyring.
+func setupReadGPG(t *testing.T) *gpg.GPG {
+	t.Helper()
+	g := setupTestGPG(t)
+	gwcli.GPG = g
+	t.Cleanup(func() { gwcli.GPG = nil })
+	return g
+}
+
+func TestRunMessagesRead_GoodSignature(t *testing.T) {
+	g := setupReadGPG(t)
+
+	sig, err := readSignature(t, signedRaw(t, g, "line one\nline two\n"), true)
+	if err != nil {
+		t.Fatalf("runMessagesRead() error = %v", err)
+	}
+	if sig == nil || !sig.Good || !sig.Trusted || sig.Signer != "Alice <alice@example.com>" || sig.Fingerprint == "" {
+		t.Errorf("signature = %+v", sig)
+	}
+}
+
+func TestRunMessagesRead_BadSignature(t *testing.T) {
+	g := setupReadGPG(t)
+
+	raw := strings.Replace(signedRaw(t, g, "pay 10 EUR\n"), "pay 10 EUR", "pay 99 EUR", 1)
+	sig, err := readSignature(t, raw, false)
+	if err != nil {
+		t.Fatalf("runMessagesRead() error = %v", err)
+	}
+	if sig == nil || sig.Good || sig.Trusted {
+		t.Errorf("signature = %+v, want a bad signature", sig)
+	}
+	if _, err := readSignature(t, raw, true); err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Errorf("error = %v, want a verification failure", err)
+	}
+}
+
+func TestRunMessagesRead_UntrustedSignature(t *testing.T) {
+	g := setupReadGPG(t)
+	raw := signedRaw(t, g, "line one\n")
+
+	// Drop the ultimate trust the key got when it was generated.
+	fpr, err := exec.Command(gpgBinary, "--batch", "--with-colons", "--list-keys", "alice@example.com").Output()
+	if err != nil {
+		t.Fatalf("list key: %v", err)
+	}
+	var trust string
+	for _, line := range strings.Split(string(fpr), "\n") {
+		if f := strings.Split(line, ":"); f[0] == "fpr" && trust == "" {
+			trust = f[9] + ":2:\n"
+		}
+	}
+	cmd := exec.Command(gpgBinary, "--batch", "--import-ownertrust")
+	cmd.Stdin = strings.NewReader(trust)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("set owner trust: %v\n%s", err, out)
+	}
+
+	if _, err := readSignature(t, raw, true); err == nil || !strings.Contains(err.Error(), "is not trusted") {
+		t.Errorf("error = %v, want an untrusted signature error", err)
+	}
+}
+
+func TestRunMessagesRead_SignerNotSender(t *testing.T) {
+	g := setupReadGPG(t)
+	raw := strings.Replace(signedRaw(t, g, "line one\n"), "From: Alice <alice@example.com>", "From: Mallory <mallory@example.com>", 1)
+
+	_, err := readSignature(t, raw, true)
+	if want := `message M1 is from mallory@example.com but signed by "Alice <alice@example.com>"`; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestRunMessagesRead_RequireSignedUnsigned(t *testing.T) {
+	raw := "From: a@example.com\r\nSubject: hi\r\nContent-Type: text/plain\r\n\r\nhi\r\n"
+	_, err := readSignature(t, raw, true)
+	if want := fmt.Sprintf("message %s is not signed", "M1"); err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/mail"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -22,14 +24,19 @@ type Status struct {
 	Signed        string
 	Encrypted     []string
 	GoodSignature bool
-	Warnings      []string
+	// Trusted is set if the signature is valid and the signing key is
+	// fully or ultimately trusted.
+	Trusted bool
+	// Fingerprint is the primary key fingerprint of a valid signature.
+	Fingerprint string
+	// Emails are the addresses of the signing key's fully valid user IDs.
+	Emails   []string
+	Warnings []string
 }
 
 var (
-	goodSignatureRE = regexp.MustCompile(`(?m)^gpg: Good signature from "(.*)"`)
-	badSignatureRE  = regexp.MustCompile(`(?m)^gpg: BAD signature from "(.*)"`)
-	encryptedRE     = regexp.MustCompile(`(?m)^gpg: encrypted with[^\n]+\n\s*"([^\n]+)"\n`)
-	unprintableRE   = regexp.MustCompile(`[\033\r]`)
+	encryptedRE   = regexp.MustCompile(`(?m)^gpg: encrypted with[^\n]+\n\s*"([^\n]+)"\n`)
+	unprintableRE = regexp.MustCompile(`[\033\r]`)
 
 	debugNoRemove = flag.Bool("debug_keep_sig_tempfiles", false, "Keep signature tempfiles.")
 )
@@ -51,7 +58,7 @@ func New(gpg string) *GPG {
 func (gpg *GPG) Decrypt(ctx context.Context, dec string) (string, *Status, error) {
 	var stderr bytes.Buffer
 	var stdout bytes.Buffer
-	cmd := exec.CommandContext(ctx, gpg.GPG, "--batch", "--no-tty")
+	cmd := exec.CommandContext(ctx, gpg.GPG, "--batch", "--no-tty", "--status-fd", "2")
 	if gpg.Passphrase != "" {
 		// Used for testing.
 		cmd.Args = append(cmd.Args,
@@ -69,9 +76,8 @@ func (gpg *GPG) Decrypt(ctx context.Context, dec string) (string, *Status, error
 		return "", nil, errors.Wrapf(err, "gpg decrypt failed: %q", stderr.String())
 	}
 	status := &Status{}
-	if m := goodSignatureRE.FindStringSubmatch(stderr.String()); m != nil {
-		status.Signed = unprintableRE.ReplaceAllString(m[1], "")
-		status.GoodSignature = true
+	if _, err := gpg.parseStatus(ctx, stderr.String(), status); err != nil {
+		return "", nil, err
 	}
 	if ms := encryptedRE.FindAllStringSubmatch(stderr.String(), -1); ms != nil {
 		for _, m := range ms {
@@ -104,13 +110,12 @@ func (gpg *GPG) Verify(ctx context.Context, data, sig string) (*Status, error) {
 	}
 
 	var stderr bytes.Buffer
-	cmd := exec.CommandContext(ctx, gpg.GPG, "--verify", "--no-tty", sigFN, dataFN)
+	cmd := exec.CommandContext(ctx, gpg.GPG, "--verify", "--no-tty", "--status-fd", "2", sigFN, dataFN)
 	cmd.Stderr = &stderr
 	if err := cmd.Start(); err != nil {
 		return nil, errors.Wrapf(err, "failed to start gpg (%q)", gpg.GPG)
 	}
 	status := &Status{}
-	goodOrBad := false
 	if err := cmd.Wait(); err != nil {
 		e, ok := err.(*exec.ExitError)
 		if !ok {
@@ -125,14 +130,9 @@ func (gpg *GPG) Verify(ctx context.Context, data, sig string) (*Status, error) {
 		}
 		// Continue since status 1, assume either good or bad signature now.
 	}
-	if m := badSignatureRE.FindStringSubmatch(stderr.String()); m != nil {
-		status.Signed = unprintableRE.ReplaceAllString(m[1], "")
-		goodOrBad = true
-	}
-	if m := goodSignatureRE.FindStringSubmatch(stderr.String()); m != nil {
-		status.Signed = unprintableRE.ReplaceAllString(m[1], "")
-		status.GoodSignature = true
-		goodOrBad = true
+	goodOrBad, err := gpg.parseStatus(ctx, stderr.String(), status)
+	if err != nil {
+		return nil, err
 	}
 	if !goodOrBad {
 		return nil, fmt.Errorf("signature not good nor bad. What? %q", stderr.String())
@@ -143,14 +143,13 @@ func (gpg *GPG) Verify(ctx context.Context, data, sig string) (*Status, error) {
 // VerifyInline verifies non-detached signatures.
 func (gpg *GPG) VerifyInline(ctx context.Context, data string) (*Status, error) {
 	var stderr bytes.Buffer
-	cmd := exec.CommandContext(ctx, gpg.GPG, "--verify", "--no-tty", "-")
+	cmd := exec.CommandContext(ctx, gpg.GPG, "--verify", "--no-tty", "--status-fd", "2", "-")
 	cmd.Stderr = &stderr
 	cmd.Stdin = strings.NewReader(data)
 	if err := cmd.Start(); err != nil {
 		return nil, errors.Wrapf(err, "failed to start gpg (%q)", gpg.GPG)
 	}
 	status := &Status{}
-	goodOrBad := false
 	if err := cmd.Wait(); err != nil {
 		e, ok := err.(*exec.ExitError)
 		if !ok {
@@ -165,17 +164,227 @@ func (gpg *GPG) VerifyInline(ctx context.Context, data string) (*Status, error)
 		}
 		// Continue since status 1, assume either good or bad signature now.
 	}
-	if m := badSignatureRE.FindStringSubmatch(stderr.String()); m != nil {
-		status.Signed = unprintableRE.ReplaceAllString(m[1], "")
-		goodOrBad = true
-	}
-	if m := goodSignatureRE.FindStringSubmatch(stderr.String()); m != nil {
-		status.Signed = unprintableRE.ReplaceAllString(m[1], "")
-		status.GoodSignature = true
-		goodOrBad = true
+	goodOrBad, err := gpg.parseStatus(ctx, stderr.String(), status)
+	if err != nil {
+		return nil, err
 	}
 	if !goodOrBad {
 		return nil, fmt.Errorf("signature not good nor bad. What? %q", stderr.String())
 	}
 	return status, nil
 }
+
+// parseStatus fills in status from the [GNUPG:] lines of gpg's
+// --status-fd output, which unlike its messages are not localized. It
+// returns whether a signature was checked, good or bad.
+func (gpg *GPG) parseStatus(ctx context.Context, out string, status *Status) (bool, error) {
+	goodOrBad := false
+	good, bad, valid := false, false, false
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "[GNUPG:] ") {
+			continue
+		}
+		f := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(f) == 0 {
+			continue
+		}
+		switch f[0] {
+		case "GOODSIG", "EXPSIG", "EXPKEYSIG", "REVKEYSIG", "BADSIG":
+			goodOrBad = true
+			if len(f) > 2 {
+				status.Signed = statusUserID(strings.Join(f[2:], " "))
+			}
+			switch f[0] {
+			case "BADSIG":
+				bad = true
+			case "EXPSIG":
+				status.Warnings = append(status.Warnings, "signature has expired")
+			case "EXPKEYSIG":
+				status.Warnings = append(status.Warnings, "signing key has expired")
+			case "REVKEYSIG":
+				status.Warnings = append(status.Warnings, "signing key has been revoked")
+			}
+			good = good || f[0] != "BADSIG"
+		case "VALIDSIG":
+			valid = true
+			// The primary key fingerprint is the last field; older gpg
+			// versions only give the signing (sub)key's.
+			status.Fingerprint = f[len(f)-1]
+		case "TRUST_FULLY", "TRUST_ULTIMATE":
+			status.Trusted = true
+		}
+	}
+	// A message with several signatures is only good if all of them are.
+	status.GoodSignature = good && !bad
+	if !valid || !status.GoodSignature || len(status.Warnings) > 0 {
+		status.Trusted = false
+	}
+	if status.Trusted {
+		emails, err := gpg.validEmails(ctx, status.Fingerprint)
+		if err != nil {
+			return false, err
+		}
+		status.Emails = emails
+	}
+	return goodOrBad, nil
+}
+
+// statusUserID decodes a user ID from a status line, where special
+// characters are percent-escaped.
+func statusUserID(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		s = u
+	}
+	return unprintableRE.ReplaceAllString(s, "")
+}
+
+// validEmails returns the addresses of the fully or ultimately valid user
+// IDs of the key with the given fingerprint.
+func (gpg *GPG) validEmails(ctx context.Context, fingerprint string) ([]string, error) {
+	var stderr bytes.Buffer
+	var stdout bytes.Buffer
+	cmd := gpg.command(ctx, "", "--list-keys", "--with-colons", "--fixed-list-mode", fingerprint)
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "gpg failed to list key %s: %q", fingerprint, stderr.String())
+	}
+	var emails []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		f := strings.Split(line, ":")
+		if len(f) < 10 || f[0] != "uid" || (f[1] != "f" && f[1] != "u") {
+			continue
+		}
+		uid := strings.ReplaceAll(f[9], `\x3a`, ":")
+		if a, err := mail.ParseAddress(uid); err == nil {
+			emails = append(emails, strings.ToLower(a.Address))
+		}
+	}
+	return emails, nil
+}
+
+var (
+	sigCreatedRE = regexp.MustCompile(`(?m)^\[GNUPG:\] SIG_CREATED \S+ \d+ (\d+) `)
+
+	// micalgNames maps OpenPGP hash algorithm IDs (RFC 4880 9.4) to the
+	// micalg parameter of multipart/signed (RFC 3156 5).
+	micalgNames = map[string]string{
+		"1":  "pgp-md5",
+		"2":  "pgp-sha1",
+		"3":  "pgp-ripemd160",
+		"8":  "pgp-sha256",
+		"9":  "pgp-sha384",
+		"10": "pgp-sha512",
+		"11": "pgp-sha224",
+	}
+)
+
+// command returns a gpg command with the common batch flags, the test
+// passphrase and, if set, the signing key.
+func (gpg *GPG) command(ctx context.Context, signer string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, gpg.GPG, "--batch", "--no-tty")
+	if gpg.Passphrase != "" {
+		// Used for testing.
+		cmd.Args = append(cmd.Args,
+			"--passphrase", gpg.Passphrase,
+			"--pinentry-mode", "loopback",
+		)
+	}
+	if signer != "" {
+		cmd.Args = append(cmd.Args, "--local-user", signer)
+	}
+	cmd.Args = append(cmd.Args, args...)
+	return cmd
+}
+
+// Sign creates an ASCII armored detached signature of data with the
+// signer's key (the default key if empty). It also returns the micalg
+// name of the hash that was used, for the multipart/signed header.
+func (gpg *GPG) Sign(ctx context.Context, data, signer string) (string, string, error) {
+	var stderr bytes.Buffer
+	var stdout bytes.Buffer
+	cmd := gpg.command(ctx, signer, "--armor", "--detach-sign", "--status-fd", "2")
+	cmd.Stdin = strings.NewReader(data)
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		return "", "", errors.Wrapf(err, "failed to start gpg (%q)", gpg.GPG)
+	}
+	if err := cmd.Wait(); err != nil {
+		return "", "", errors.Wrapf(err, "gpg sign failed: %q", stderr.String())
+	}
+	m := sigCreatedRE.FindStringSubmatch(stderr.String())
+	if m == nil {
+		return "", "", fmt.Errorf("gpg did not report the signature hash: %q", stderr.String())
+	}
+	micalg, ok := micalgNames[m[1]]
+	if !ok {
+		return "", "", fmt.Errorf("unknown signature hash algorithm %s", m[1])
+	}
+	return stdout.String(), micalg, nil
+}
+
+// MissingKeys returns the addresses that have no public key in the keyring.
+func (gpg *GPG) MissingKeys(ctx context.Context, addrs []string) ([]string, error) {
+	var missing []string
+	for _, a := range addrs {
+		cmd := gpg.command(ctx, "", "--list-keys", "--with-colons", "<"+a+">")
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				return nil, errors.Wrapf(err, "failed to start gpg (%q)", gpg.GPG)
+			}
+			missing = append(missing, a)
+		}
+	}
+	return missing, nil
+}
+
+// Encrypt encrypts data to the given recipient addresses and returns it
+// ASCII armored. Hidden recipients (e.g. Bcc) are not named in the output.
+// self, if set, is the sender's address; it gets a copy too, so that the
+// sender can read the message later. With sign, the data is also signed
+// by signer (the default key if empty).
+func (gpg *GPG) Encrypt(ctx context.Context, data string, recipients, hidden []string, self, signer string, sign bool) (string, error) {
+	if len(recipients)+len(hidden) == 0 {
+		return "", fmt.Errorf("no recipients to encrypt to")
+	}
+	all := append(append([]string{}, recipients...), hidden...)
+	if self != "" {
+		all = append(all, self)
+	}
+	missing, err := gpg.MissingKeys(ctx, all)
+	if err != nil {
+		return "", err
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no public key for %s", strings.Join(missing, ", "))
+	}
+
+	args := []string{"--armor", "--encrypt"}
+	if sign {
+		args = append(args, "--sign")
+	}
+	for _, r := range recipients {
+		args = append(args, "--recipient", "<"+r+">")
+	}
+	for _, r := range hidden {
+		args = append(args, "--hidden-recipient", "<"+r+">")
+	}
+	if self != "" {
+		args = append(args, "--encrypt-to", "<"+self+">")
+	}
+
+	var stderr bytes.Buffer
+	var stdout bytes.Buffer
+	cmd := gpg.command(ctx, signer, args...)
+	cmd.Stdin = strings.NewReader(data)
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		return "", errors.Wrapf(err, "failed to start gpg (%q)", gpg.GPG)
+	}
+	if err := cmd.Wait(); err != nil {
+		return "", errors.Wrapf(err, "gpg encrypt failed: %q", stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -104,6 +104,8 @@ func TestVerify(t *testing.T) {
 			want: &Status{
 				Signed:        "Thomas Habets <thomas@habets.se>",
 				GoodSignature: true,
+				Fingerprint:   "990786988A24F52F1C2E87F639A49EEA460A0169",
+				Warnings:      []string{"signing key has expired"},
 			},
 		},
 		// TODO: sign with unknown key.
@@ -165,6 +167,8 @@ func TestVerifyInline(t *testing.T) {
 			want: &Status{
 				Signed:        "Thomas Habets <thomas@habets.se>",
 				GoodSignature: true,
+				Fingerprint:   "990786988A24F52F1C2E87F639A49EEA460A0169",
+				Warnings:      []string{"signing key has expired"},
 			},
 		},
 		{
@@ -266,3 +270,64 @@ func TestDecrypt(t *testing.T) {
 		}
 	}
 }
+
+func TestSign(t *testing.T) {
+	ctx := context.Background()
+	g := New(gpg)
+	g.Passphrase = testKeyPassphrase
+
+	data := "Content-Type: text/plain\r\n\r\nsigned body\r\n"
+	sig, micalg, err := g.Sign(ctx, data, "test@example.com")
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !strings.HasPrefix(micalg, "pgp-") {
+		t.Errorf("micalg = %q, want pgp-*", micalg)
+	}
+	if !strings.Contains(sig, "-----BEGIN PGP SIGNATURE-----") {
+		t.Errorf("not an armored signature: %q", sig)
+	}
+
+	s, err := g.Verify(ctx, data, sig)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := &Status{
+		Signed:        "Joe Tester (with stupid passphrase) <test@example.com>",
+		GoodSignature: true,
+		Trusted:       true,
+		Fingerprint:   s.Fingerprint,
+		Emails:        []string{"test@example.com"},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+	g := New(gpg)
+	g.Passphrase = testKeyPassphrase
+
+	enc, err := g.Encrypt(ctx, "secret message", nil, []string{"test@example.com"}, "", "", true)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.Contains(enc, "-----BEGIN PGP MESSAGE-----") {
+		t.Errorf("not an armored message: %q", enc)
+	}
+	out, s, err := g.Decrypt(ctx, enc)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if out != "secret message" {
+		t.Errorf("decrypted = %q", out)
+	}
+	if !s.GoodSignature {
+		t.Errorf("expected a good signature, got %+v", s)
+	}
+
+	if _, err := g.Encrypt(ctx, "x", []string{"test@example.com", "nobody@example.com"}, nil, "", "", false); err == nil || !strings.Contains(err.Error(), "nobody@example.com") {
+		t.Errorf("expected missing key error naming nobody@example.com, got %v", err)
+	}
+}
@@ -124,8 +124,9 @@ Scopes needed:
 	return nil, fmt.Errorf("token not found - run 'gwcli configure' to authorize")
 }
 
-// ConfigureAuth performs the OAuth flow and saves the token
-func ConfigureAuth(ctx context.Context, paths *ConfigPaths, port int) error {
+// ConfigureAuth performs the OAuth flow and saves the token. allowPurge also
+// asks for the full mailbox scope, which permanent deletion needs.
+func ConfigureAuth(ctx context.Context, paths *ConfigPaths, port int, allowPurge bool) error {
 	// Ensure config directory exists
 	if err := os.MkdirAll(paths.Dir, 0700); err != nil {
 		return fmt.Errorf("creating config directory: %w", err)
@@ -143,6 +144,9 @@ func ConfigureAuth(ctx context.Context, paths *ConfigPaths, port int) error {
 	if err != nil {
 		return fmt.Errorf("creating authenticator: %w", err)
 	}
+	if allowPurge {
+		auth.AllowPurge()
+	}
 
 	// Start local OAuth server
 	localAddr := fmt.Sprintf("http://localhost:%d", port)
@@ -79,6 +79,7 @@ type CmdG struct {
 	m            sync.RWMutex
 	authedClient *http.Client
 	gmail        *gmail.Service
+	purge        *gmail.Service // may permanently delete; see BatchDelete
 	drive        *drive.Service
 	people       *people.Service
 	tasks        *tasks.Service
@@ -121,8 +122,13 @@ func (c *CmdG) LabelCache(label *Label) *Label {
 func NewFake(client *http.Client) (*CmdG, error) {
 	conn := &CmdG{
 		authedClient: client,
+		messageCache: make(map[string]*Message),
+		labelCache:   make(map[string]*Label),
 	}
-	return conn, conn.setupClients()
+	err := conn.setupClients()
+	// A fake connection is granted every scope, purgeScope included.
+	conn.purge = conn.gmail
+	return conn, err
 }
 
 // New creates a new CmdG with OAuth/service-account authentication.
@@ -242,6 +248,19 @@ func New(configDir string, userEmail string, verbose bool) (*CmdG, error) {
 			}
 		}
 
+		// Likewise best-effort: permanent deletion needs its own scope.
+		credFile5, err := os.Open(paths.Credentials)
+		if err == nil {
+			defer credFile5.Close()
+			if saAuth, err := NewServiceAccountAuthenticator(credFile5, userEmail); err == nil {
+				if purgeSvc, err := saAuth.PurgeService(ctx); err == nil {
+					conn.purge = purgeSvc
+				} else if verbose {
+					log.Infof("Purge service unavailable for service account: %v", err)
+				}
+			}
+		}
+
 		if verbose {
 			log.Infof("Service account connection ready")
 		}
@@ -279,7 +298,16 @@ func New(configDir string, userEmail string, verbose bool) (*CmdG, error) {
 
 	// Set up Drive and People services (note: gwcli doesn't actively use these,
 	// but keeping them for compatibility with old cmdg code)
-	return conn, conn.setupClients()
+	if err := conn.setupClients(); err != nil {
+		return conn, err
+	}
+
+	// An OAuth token carries every consented scope, so the same client can
+	// purge if `gwcli configure --allow-purge` was granted purgeScope.
+	if tokenGrants(tokBytes, purgeScope) {
+		conn.purge = conn.gmail
+	}
+	return conn, nil
 }
 
 // getOAuthConfig creates an OAuth2 config from credentials file
@@ -477,6 +505,69 @@ func (c *CmdG) LoadLabels(ctx context.Context, verbose bool) error {
 	return nil
 }
 
+// RefreshLabels drops the label cache and reloads it from the Gmail API.
+// Called after any label is created, changed or deleted.
+func (c *CmdG) RefreshLabels(ctx context.Context) error {
+	c.m.Lock()
+	c.labelCache = make(map[string]*Label)
+	c.labelsLoaded = false
+	c.m.Unlock()
+	return c.LoadLabels(ctx, false)
+}
+
+// CreateLabel creates a user label and refreshes the label cache.
+func (c *CmdG) CreateLabel(ctx context.Context, label *gmail.Label) (*gmail.Label, error) {
+	var created *gmail.Label
+	err := wrapLogRPC("gmail.Users.Labels.Create", func() (err error) {
+		created, err = c.gmail.Users.Labels.Create(email, label).Context(ctx).Do()
+		return
+	}, "email=%q name=%q", email, label.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating label %q", label.Name)
+	}
+	return created, c.RefreshLabels(ctx)
+}
+
+// UpdateLabel patches a label and refreshes the label cache. Only the
+// fields set in patch (or listed in its ForceSendFields) are changed.
+func (c *CmdG) UpdateLabel(ctx context.Context, id string, patch *gmail.Label) (*gmail.Label, error) {
+	var updated *gmail.Label
+	err := wrapLogRPC("gmail.Users.Labels.Patch", func() (err error) {
+		updated, err = c.gmail.Users.Labels.Patch(email, id, patch).Context(ctx).Do()
+		return
+	}, "email=%q id=%q", email, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "updating label %q", id)
+	}
+	return updated, c.RefreshLabels(ctx)
+}
+
+// DeleteLabel deletes a user label and refreshes the label cache. Messages
+// keep their other labels.
+func (c *CmdG) DeleteLabel(ctx context.Context, id string) error {
+	err := wrapLogRPC("gmail.Users.Labels.Delete", func() error {
+		return c.gmail.Users.Labels.Delete(email, id).Context(ctx).Do()
+	}, "email=%q id=%q", email, id)
+	if err != nil {
+		return errors.Wrapf(err, "deleting label %q", id)
+	}
+	return c.RefreshLabels(ctx)
+}
+
+// GetLabel fetches a single label. Unlike the cached labels from
+// Labels.List, the result includes message and thread counts.
+func (c *CmdG) GetLabel(ctx context.Context, id string) (*gmail.Label, error) {
+	var l *gmail.Label
+	err := wrapLogRPC("gmail.Users.Labels.Get", func() (err error) {
+		l, err = c.gmail.Users.Labels.Get(email, id).Context(ctx).Do()
+		return
+	}, "email=%q id=%q", email, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting label %q", id)
+	}
+	return l, nil
+}
+
 // Labels returns a list of all labels.
 // Labels are lazily loaded from the Gmail API on first access.
 func (c *CmdG) Labels() []*Label {
@@ -703,16 +794,18 @@ func ParseUserMessage(in string) (mail.Header, *Part, error) {
 	}, nil
 }
 
-// SendParts sends a multipart message.
+// SendParts sends a multipart message and returns the sent message, which
+// carries the new message ID and its thread ID.
 // Args:
 //
-//	mp:    multipart type. "mixed" is a typical type.
+//	mp:    multipart type. "mixed" is a typical type. Parameters may
+//	       follow, e.g. `signed; protocol="application/pgp-signature"`.
 //	head:  Email header.
 //	parts: Email parts.
-func (c *CmdG) SendParts(ctx context.Context, threadID ThreadID, mp string, head mail.Header, parts []*Part) error {
+func (c *CmdG) SendParts(ctx context.Context, threadID ThreadID, mp string, head mail.Header, parts []*Part) (*gmail.Message, error) {
 	msgs, err := buildPartsMessage(mp, head, parts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Infof("Final message: %q", msgs)
 	return c.send(ctx, threadID, msgs)
@@ -764,7 +857,10 @@ func buildPartsMessage(mp string, head mail.Header, parts []*Part) (string, erro
 		return "", errors.Wrapf(err, "closing multipart")
 	}
 
+	// Address headers get RFC 2047 encoded display names; other headers
+	// are encoded as a whole.
 	addrHeader := map[string]bool{
+		"from":     true,
 		"to":       true,
 		"cc":       true,
 		"bcc":      true,
@@ -788,7 +884,9 @@ func buildPartsMessage(mp string, head mail.Header, parts []*Part) (string, erro
 					if a.Name == "" {
 						ass = append(ass, a.Address)
 					} else {
-						ass = append(ass, fmt.Sprintf(`"%s" <%s>`, mime.QEncoding.Encode("utf-8", a.Name), a.Address))
+						// An encoded-word must not appear inside a quoted string,
+						// so let net/mail choose between quoting and encoding.
+						ass = append(ass, a.String())
 					}
 				}
 				hlines = append(hlines, fmt.Sprintf("%s: %s", k, strings.Join(ass, ", ")))
@@ -806,14 +904,17 @@ func buildPartsMessage(mp string, head mail.Header, parts []*Part) (string, erro
 	return strings.Join(hlines, "\r\n") + "\r\n\r\n" + mbuf.String(), nil
 }
 
-func (c *CmdG) send(ctx context.Context, threadID ThreadID, msg string) (err error) {
-	return wrapLogRPC("gmail.Users.Messages.Send", func() error {
-		_, err = c.gmail.Users.Messages.Send(email, &gmail.Message{
+func (c *CmdG) send(ctx context.Context, threadID ThreadID, msg string) (*gmail.Message, error) {
+	var sent *gmail.Message
+	err := wrapLogRPC("gmail.Users.Messages.Send", func() error {
+		var err error
+		sent, err = c.gmail.Users.Messages.Send(email, &gmail.Message{
 			Raw:      MIMEEncode(msg),
 			ThreadId: string(threadID),
 		}).Context(ctx).Do()
 		return err
 	}, "email=%q threadID=%q msg=%q", email, threadID, msg)
+	return sent, err
 }
 
 // PutFile uploads a file into the config dir on Google drive.
@@ -981,14 +1082,21 @@ func (c *CmdG) BatchArchive(ctx context.Context, ids []string) error {
 	}, "email=%q remove=INBOX ids=%v)", email, ids)
 }
 
+// ErrPurgeNotAllowed is returned by BatchDelete when the connection was not
+// granted the https://mail.google.com/ scope.
+var ErrPurgeNotAllowed = errors.New("permanent deletion is not authorized for this connection")
+
 // BatchDelete deletes. Does not put in trash. Does not pass go:
 // "Immediately and permanently deletes the specified message. This operation cannot be undone."
 //
-// cmdg doesn't actually request oauth permission to do this, so this function is never used.
-// Instead BatchTrash is used.
+// It needs the https://mail.google.com/ scope, which only the purge client
+// may have; everything else uses BatchTrash.
 func (c *CmdG) BatchDelete(ctx context.Context, ids []string) error {
+	if c.purge == nil {
+		return ErrPurgeNotAllowed
+	}
 	return wrapLogRPC("gmail.Users.Messages.BatchDelete", func() error {
-		return c.gmail.Users.Messages.BatchDelete(email, &gmail.BatchDeleteMessagesRequest{
+		return c.purge.Users.Messages.BatchDelete(email, &gmail.BatchDeleteMessagesRequest{
 			Ids: ids,
 		}).Context(ctx).Do()
 	}, "email=%q ids=%v", email, ids)
@@ -1001,6 +1109,21 @@ func (c *CmdG) BatchTrash(ctx context.Context, ids []string) error {
 	return c.BatchLabel(ctx, ids, Trash)
 }
 
+// BatchModifyLimit is the most message IDs one BatchModify call accepts.
+const BatchModifyLimit = 1000
+
+// BatchModify adds and removes labels on up to BatchModifyLimit messages in
+// one call.
+func (c *CmdG) BatchModify(ctx context.Context, ids, add, remove []string) error {
+	return wrapLogRPC("gmail.Users.Messages.BatchModify", func() error {
+		return c.gmail.Users.Messages.BatchModify(email, &gmail.BatchModifyMessagesRequest{
+			Ids:            ids,
+			AddLabelIds:    add,
+			RemoveLabelIds: remove,
+		}).Context(ctx).Do()
+	}, "email=%q add=%v remove=%v ids=%v", email, add, remove, ids)
+}
+
 // BatchLabel adds one new label to many messages.
 func (c *CmdG) BatchLabel(ctx context.Context, ids []string, labelID string) error {
 	return wrapLogRPC("gmail.Users.Messages.BatchModify", func() error {
@@ -1049,13 +1172,18 @@ func (c *CmdG) MoreHistory(ctx context.Context, start HistoryID, labelID string)
 	return len(r.History) > 0, nil
 }
 
-// History returns history since startID (all pages).
+// History returns history since startID (all pages), optionally limited to
+// changes involving labelID. The returned ID is where to resume from.
 func (c *CmdG) History(ctx context.Context, startID HistoryID, labelID string) ([]*gmail.History, HistoryID, error) {
 	log.Infof("History for %d %s", startID, labelID)
 	var ret []*gmail.History
 	var h HistoryID
 	err := wrapLogRPC("gmail.Users.History.List", func() error {
-		return c.gmail.Users.History.List(email).Context(ctx).StartHistoryId(uint64(startID)).LabelId(labelID).Pages(ctx, func(r *gmail.ListHistoryResponse) error {
+		call := c.gmail.Users.History.List(email).Context(ctx).StartHistoryId(uint64(startID))
+		if labelID != "" {
+			call = call.LabelId(labelID)
+		}
+		return call.Pages(ctx, func(r *gmail.ListHistoryResponse) error {
 			ret = append(ret, r.History...)
 			h = HistoryID(r.HistoryId)
 			return nil
@@ -1069,12 +1197,72 @@ func (c *CmdG) History(ctx context.Context, startID HistoryID, labelID string) (
 
 // ListMessages lists messages in a given label or query, with optional page token.
 func (c *CmdG) ListMessages(ctx context.Context, label, query, token string) (*Page, error) {
+	return c.ListMessagesN(ctx, label, query, token, pageSize)
+}
+
+// ListMessageIDs returns the IDs of all messages in a label and/or matching
+// a query, following every page.
+func (c *CmdG) ListMessageIDs(ctx context.Context, label, query string) ([]string, error) {
+	ids, _, err := c.ListMessageIDsN(ctx, label, query, 0)
+	return ids, err
+}
+
+// ListMessageIDsN is like ListMessageIDs, but stops after n IDs and reports
+// whether more messages match. n <= 0 means no limit.
+func (c *CmdG) ListMessageIDsN(ctx context.Context, label, query string, n int) ([]string, bool, error) {
+	var ids []string
+	token := ""
+	for {
+		size := int64(500)
+		if n > 0 && int64(n-len(ids)+1) < size {
+			// One past the limit tells us whether there are more.
+			size = int64(n - len(ids) + 1)
+		}
+		q := c.gmail.Users.Messages.List(email).
+			PageToken(token).
+			MaxResults(size).
+			Context(ctx).
+			Fields("messages/id,nextPageToken")
+		if query != "" {
+			q = q.Q(query)
+		}
+		if label != "" {
+			q = q.LabelIds(label)
+		}
+		var res *gmail.ListMessagesResponse
+		err := wrapLogRPC("gmail.Users.Messages.List", func() (err error) {
+			res, err = q.Do()
+			return
+		}, "email=%q token=%v labelID=%q query=%q size=%d", email, token, label, query, size)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "listing messages")
+		}
+		for _, m := range res.Messages {
+			ids = append(ids, m.Id)
+		}
+		if n > 0 && len(ids) > n {
+			return ids[:n], true, nil
+		}
+		if res.NextPageToken == "" {
+			return ids, false, nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+// ListMessagesN is like ListMessages, but asks for at most n messages. Callers
+// that stop at a limit use this so the returned next page token points just
+// past the last message they saw. n outside 1..pageSize means pageSize.
+func (c *CmdG) ListMessagesN(ctx context.Context, label, query, token string, n int64) (*Page, error) {
 	const fields = "messages,resultSizeEstimate,nextPageToken"
-	nres := int64(pageSize)
+	nres := n
+	if nres <= 0 || nres > pageSize {
+		nres = pageSize
+	}
 
 	q := c.gmail.Users.Messages.List(email).
 		PageToken(token).
-		MaxResults(int64(nres)).
+		MaxResults(nres).
 		Context(ctx).
 		Fields(fields)
 	if query != "" {
@@ -1104,15 +1292,18 @@ func (c *CmdG) ListMessages(ctx context.Context, label, query, token string) (*P
 	return p, nil
 }
 
-// ListDrafts lists all drafts.
+// ListDrafts lists all drafts, with metadata loaded.
 func (c *CmdG) ListDrafts(ctx context.Context) ([]*Draft, error) {
 	var ret []*Draft
+	var wg sync.WaitGroup
 	if err := wrapLogRPC("gmail.Users.Drafts.List", func() error {
 		return c.gmail.Users.Drafts.List(email).Pages(ctx, func(r *gmail.ListDraftsResponse) error {
 			for _, d := range r.Drafts {
 				nd := NewDraft(c, d.Id)
 				ret = append(ret, nd)
+				wg.Add(1)
 				go func() {
+					defer wg.Done()
 					if err := nd.load(ctx, LevelMetadata); err != nil {
 						log.Errorf("Loading a draft: %v", err)
 					}
@@ -1121,7 +1312,9 @@ func (c *CmdG) ListDrafts(ctx context.Context) ([]*Draft, error) {
 			return nil
 		})
 	}, "email=%q", email); err != nil {
+		wg.Wait()
 		return nil, err
 	}
+	wg.Wait()
 	return ret, nil
 }
@@ -12,6 +12,7 @@ import (
 	"net/mail"
 	"regexp"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +29,7 @@ import (
 const (
 	Inbox   = "INBOX"
 	Trash   = "TRASH"
+	Spam    = "SPAM"
 	Unread  = "UNREAD"
 	Starred = "STARRED"
 )
@@ -576,96 +578,135 @@ func (msg *Message) GetLabels(ctx context.Context, withUnread bool) ([]*Label, e
 	return ret, nil
 }
 
+// labelColors maps the Gmail label color palette to 256-color terminal
+// colors.
+var labelColors = map[string]int{
+	// Shades of grey.
+	"#000000": 232,
+	"#434343": 240,
+	"#666666": 238,
+	"#999999": 248,
+	"#cccccc": 240,
+	"#efefef": 240,
+	"#f3f3f3": 240,
+	"#ffffff": 255,
+
+	"#4986e7": 21, // NON-STANDARD blue
+
+	"#fb4c2f": 9,   // Orange-ish.
+	"#ffad46": 208, // NON-STANDARD orange
+	"#ffad47": 240, // Yellow-orange
+	"#fad165": 240, // Yellow
+
+	"#16a766": 240, // Green-ish.
+	"#16a765": 28,  // NON-STANDARD green.
+	"#43d692": 240, // Lighter puke-green.
+
+	"#4a86e8": 240, // Light blue
+	"#a479e2": 240, // Purple
+	"#f691b3": 240, // Pink
+	"#f6c5be": 240, // Pig-pink
+	"#ffe6c7": 240, // White-yellow
+	"#fef1d1": 240, // Even lighter.
+	"#b9e4d0": 240, // Puke-green.
+	"#c6f3de": 200,
+	"#c9daf8": 200,
+	"#e4d7f5": 200,
+	"#fcdee8": 200,
+	"#efa093": 200,
+	"#ffd6a2": 200,
+	"#fce8b3": 200,
+	"#89d3b2": 200,
+	"#a0eac9": 200,
+	"#a4c2f4": 200,
+	"#d0bcf1": 200,
+	"#fbc8d9": 200,
+	"#e66550": 200,
+	"#ffbc6b": 200,
+	"#fcda83": 200,
+	"#44b984": 200,
+	"#68dfa9": 200,
+	"#6d9eeb": 200,
+	"#b694e8": 200,
+	"#f7a7c0": 200,
+	"#cc3a21": 200,
+	"#eaa041": 200,
+	"#f2c960": 200,
+	"#149e60": 200,
+	"#3dc789": 200,
+	"#3c78d8": 200,
+	"#8e63ce": 200,
+	"#e07798": 200,
+	"#ac2b16": 200,
+	"#cf8933": 200,
+	"#d5ae49": 200,
+	"#0b804b": 200,
+	"#2a9c68": 200,
+	"#285bac": 200,
+	"#653e9b": 200,
+	"#b65775": 200,
+	"#822111": 200,
+	"#a46a21": 200,
+	"#aa8831": 200,
+	"#076239": 200,
+	"#1a764d": 200,
+	"#1c4587": 200,
+	"#41236d": 200,
+	"#83334c": 200,
+
+	"#711a36": 52,  // NON-STANDARD maroon.
+	"#fbd3e0": 205, // NON-STANDARD pink.
+	"#fbe983": 11,  // NON-STANDARD yellow.
+	"#594c05": 58,  // NON-STANDARD dark yellow.
+	"#b3efd3": 79,  // NON-standard light greenish
+	"#0b4f30": 22,  // NON-standard green
+}
+
+// labelPalette is every color the Gmail API accepts for a label's
+// background or text, as documented for users.labels. labelColors only
+// covers the ones gwcli knows how to render in a terminal.
+var labelPalette = []string{
+	"#000000", "#434343", "#666666", "#999999", "#cccccc", "#efefef", "#f3f3f3", "#ffffff",
+	"#fb4c2f", "#ffad47", "#fad165", "#16a766", "#43d692", "#4a86e8", "#a479e2", "#f691b3",
+	"#f6c5be", "#ffe6c7", "#fef1d1", "#b9e4d0", "#c6f3de", "#c9daf8", "#e4d7f5", "#fcdee8",
+	"#efa093", "#ffd6a2", "#fce8b3", "#89d3b2", "#a0eac9", "#a4c2f4", "#d0bcf1", "#fbc8d9",
+	"#e66550", "#ffbc6b", "#fcda83", "#44b984", "#68dfa9", "#6d9eeb", "#b694e8", "#f7a7c0",
+	"#cc3a21", "#eaa041", "#f2c960", "#149e60", "#3dc789", "#3c78d8", "#8e63ce", "#e07798",
+	"#ac2b16", "#cf8933", "#d5ae49", "#0b804b", "#2a9c68", "#285bac", "#653e9b", "#b65775",
+	"#822111", "#a46a21", "#aa8831", "#076239", "#1a764d", "#1c4587", "#41236d", "#83334c",
+	"#464646", "#e7e7e7", "#0d3472", "#b6cff5", "#0d3b44", "#98d7e4", "#3d188e", "#e3d7ff",
+	"#711a36", "#fbd3e0", "#8a1c0a", "#f2b2a8", "#7a2e0b", "#ffc8af", "#7a4706", "#ffdeb5",
+	"#594c05", "#fbe983", "#684e07", "#fdedc1", "#0b4f30", "#b3efd3", "#04502e", "#a2dcc1",
+	"#c2c2c2", "#4986e7", "#2da2bb", "#b99aff", "#994a64", "#f691b2", "#ff7537", "#ffad46",
+	"#662e37", "#ebdbde", "#cca6ac", "#094228", "#42d692", "#16a765",
+}
+
+// IsLabelColor reports whether hex (e.g. "#fb4c2f") is in the Gmail label
+// color palette. Gmail rejects label colors outside it.
+func IsLabelColor(hex string) bool {
+	hex = strings.ToLower(hex)
+	for _, c := range labelPalette {
+		if c == hex {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelColors returns the Gmail label color palette, sorted.
+func LabelColors() []string {
+	ret := append([]string(nil), labelPalette...)
+	sort.Strings(ret)
+	return ret
+}
+
 func colorMap(fgs, bgs string) string {
-	// Textcolor.
-	textColorMap := map[string]int{
-		// Shades of grey.
-		"#000000": 232,
-		"#434343": 240,
-		"#666666": 238,
-		"#999999": 248,
-		"#cccccc": 240,
-		"#efefef": 240,
-		"#f3f3f3": 240,
-		"#ffffff": 255,
-
-		"#4986e7": 21, // NON-STANDARD blue
-
-		"#fb4c2f": 9,   // Orange-ish.
-		"#ffad46": 208, // NON-STANDARD orange
-		"#ffad47": 240, // Yellow-orange
-		"#fad165": 240, // Yellow
-
-		"#16a766": 240, // Green-ish.
-		"#16a765": 28,  // NON-STANDARD green.
-		"#43d692": 240, // Lighter puke-green.
-
-		"#4a86e8": 240, // Light blue
-		"#a479e2": 240, // Purple
-		"#f691b3": 240, // Pink
-		"#f6c5be": 240, // Pig-pink
-		"#ffe6c7": 240, // White-yellow
-		"#fef1d1": 240, // Even lighter.
-		"#b9e4d0": 240, // Puke-green.
-		"#c6f3de": 200,
-		"#c9daf8": 200,
-		"#e4d7f5": 200,
-		"#fcdee8": 200,
-		"#efa093": 200,
-		"#ffd6a2": 200,
-		"#fce8b3": 200,
-		"#89d3b2": 200,
-		"#a0eac9": 200,
-		"#a4c2f4": 200,
-		"#d0bcf1": 200,
-		"#fbc8d9": 200,
-		"#e66550": 200,
-		"#ffbc6b": 200,
-		"#fcda83": 200,
-		"#44b984": 200,
-		"#68dfa9": 200,
-		"#6d9eeb": 200,
-		"#b694e8": 200,
-		"#f7a7c0": 200,
-		"#cc3a21": 200,
-		"#eaa041": 200,
-		"#f2c960": 200,
-		"#149e60": 200,
-		"#3dc789": 200,
-		"#3c78d8": 200,
-		"#8e63ce": 200,
-		"#e07798": 200,
-		"#ac2b16": 200,
-		"#cf8933": 200,
-		"#d5ae49": 200,
-		"#0b804b": 200,
-		"#2a9c68": 200,
-		"#285bac": 200,
-		"#653e9b": 200,
-		"#b65775": 200,
-		"#822111": 200,
-		"#a46a21": 200,
-		"#aa8831": 200,
-		"#076239": 200,
-		"#1a764d": 200,
-		"#1c4587": 200,
-		"#41236d": 200,
-		"#83334c": 200,
-
-		"#711a36": 52,  // NON-STANDARD maroon.
-		"#fbd3e0": 205, // NON-STANDARD pink.
-		"#fbe983": 11,  // NON-STANDARD yellow.
-		"#594c05": 58,  // NON-STANDARD dark yellow.
-		"#b3efd3": 79,  // NON-standard light greenish
-		"#0b4f30": 22,  // NON-standard green
-	}
-
-	fg, found := textColorMap[fgs]
+	fg, found := labelColors[fgs]
 	if !found {
 		log.Infof("Could not find foreground %q", fgs)
 		fg = 50
 	}
-	bg, found := textColorMap[bgs]
+	bg, found := labelColors[bgs]
 	if !found {
 		log.Infof("Could not find background %q", bgs)
 		bg = 200
@@ -898,53 +939,24 @@ func (msg *Message) trySigned(ctx context.Context) error {
 	if msg.Response.Payload.MimeType != "multipart/signed" {
 		return nil
 	}
-	var partSig *gmail.MessagePart
-	var dec string
 	for _, p := range msg.Response.Payload.Parts {
 		switch p.MimeType {
-		case "text/plain":
-			var err error
-			dec, err = MIMEDecode(p.Body.Data)
-			if err != nil {
-				return err
-			}
-			var hs []string
-			for _, h := range p.Headers {
-				hs = append(hs, fmt.Sprintf("%s: %s", h.Name, h.Value))
-			}
-			hp := strings.Join(hs, "\r\n") + "\r\n\r\n"
-			dec = hp + dec
-			// TODO: what if it's signed HTML?
-		case "application/pgp-signature":
-			// GPG/PGP signed
-			partSig = p
-		case "application/x-pkcs7-signature":
+		case "application/x-pkcs7-signature", "application/pkcs7-signature":
 			return msg.trySMIMESigned(ctx)
-		case "multipart/mixed":
-			log.Warningf("Multipart signed: %+v", p)
-		default:
-			log.Warningf("Found unexpected part in signed packet: %q", p.MimeType)
 		}
 	}
 
-	if partSig == nil {
-		return fmt.Errorf("no supported attached signature")
-	}
-
-	// Fetch attachment.
-	var body *gmail.MessagePartBody
-	err := wrapLogRPC("gmail.Users.Messages.Attachments.Get", func() (err error) {
-		body, err = msg.conn.gmail.Users.Messages.Attachments.Get(email, msg.ID, partSig.Body.AttachmentId).Context(ctx).Do()
-		return err
-	}, "email=%q msgID=%v attachmentID=%v", email, msg.ID, partSig.Body.AttachmentId)
+	// The signature covers the signed part byte for byte, headers included,
+	// so verify against the raw message rather than the parsed payload.
+	raw, err := msg.rawNoLock(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to download signature attachment")
+		return errors.Wrap(err, "fetching raw message")
 	}
-	sigDec, err := MIMEDecode(body.Data)
+	data, sig, err := splitSigned(raw)
 	if err != nil {
-		return errors.Wrap(err, "failed to MIME decode signature attachment")
+		return err
 	}
-	st, err := GPG.Verify(ctx, dec, sigDec)
+	st, err := GPG.Verify(ctx, data, sig)
 	if err != nil {
 		return err
 	}
@@ -952,6 +964,60 @@ func (msg *Message) trySigned(ctx context.Context) error {
 	return nil
 }
 
+// splitSigned returns the signed part of a multipart/signed message, in
+// canonical CRLF form exactly as it appears in the message, and the
+// decoded signature.
+func splitSigned(raw string) (string, string, error) {
+	raw = strings.ReplaceAll(strings.ReplaceAll(raw, "\r\n", "\n"), "\n", "\r\n")
+	m, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return "", "", errors.Wrap(err, "parsing signed message")
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		return "", "", errors.Wrap(err, "parsing signed message content type")
+	}
+	if params["boundary"] == "" {
+		return "", "", fmt.Errorf("signed message has no boundary")
+	}
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The CRLF before each delimiter belongs to the delimiter, not the part.
+	chunks := strings.Split("\r\n"+string(body), "\r\n--"+params["boundary"])
+	if len(chunks) < 4 {
+		return "", "", fmt.Errorf("signed message has %d parts, want 2", len(chunks)-2)
+	}
+	var parts []string
+	for _, c := range chunks[1:3] {
+		// Skip the rest of the delimiter line.
+		i := strings.Index(c, "\r\n")
+		if i < 0 {
+			return "", "", fmt.Errorf("malformed signed message part")
+		}
+		parts = append(parts, c[i+2:])
+	}
+
+	sigPart, err := mail.ReadMessage(strings.NewReader(parts[1]))
+	if err != nil {
+		return "", "", errors.Wrap(err, "parsing signature part")
+	}
+	var r io.Reader = sigPart.Body
+	switch strings.ToLower(sigPart.Header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	sig, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", "", errors.Wrap(err, "decoding signature part")
+	}
+	return parts[0], string(sig), nil
+}
+
 var inlineGPG = regexp.MustCompile(`(?sm)(-----BEGIN PGP SIGNED MESSAGE-----.*-----BEGIN PGP SIGNATURE-----.*-----END PGP SIGNATURE-----)`)
 
 func (msg *Message) tryGPGInlineSigned(ctx context.Context) error {
@@ -1150,9 +1216,11 @@ func (msg *Message) load(ctx context.Context, level DataLevel) error {
 		}
 		if err := msg.tryGPGEncrypted(ctx); err != nil {
 			msg.body = fmt.Sprintf("ERROR Decrypting GPG: %v", err)
+			msg.gpgStatus = &gpg.Status{Warnings: []string{fmt.Sprintf("decrypting: %v", err)}}
 		}
 		if err := msg.trySigned(ctx); err != nil {
 			log.Errorf("Checking GPG signature: %v", err)
+			msg.gpgStatus = &gpg.Status{Warnings: []string{fmt.Sprintf("checking signature: %v", err)}}
 		}
 		msg.originalBody = msg.body
 		if err := msg.tryGPGInlineSigned(ctx); err != nil {
@@ -1249,22 +1317,33 @@ func (d *Draft) GetBody(ctx context.Context) (string, error) {
 	return d.body, nil
 }
 
-// UpdateParts updates a draft… right?
-func (d *Draft) UpdateParts(ctx context.Context, head mail.Header, parts []*Part) error {
-	//d.update(ctx)
-	return fmt.Errorf("NOT IMPLEMENTED")
+// UpdateParts replaces the draft contents with a multipart message.
+// Arguments mirror SendParts.
+func (d *Draft) UpdateParts(ctx context.Context, mp string, head mail.Header, parts []*Part) error {
+	msg, err := buildPartsMessage(mp, head, parts)
+	if err != nil {
+		return err
+	}
+	return d.Update(ctx, msg)
 }
 
-// Update the draft.
+// Update the draft. If the draft has been loaded, it stays in its thread.
 func (d *Draft) Update(ctx context.Context, content string) error {
+	var threadID string
+	d.m.RLock()
+	if d.Response != nil && d.Response.Message != nil {
+		threadID = d.Response.Message.ThreadId
+	}
+	d.m.RUnlock()
 	if err := wrapLogRPC("gmail.Users.Drafts.Update", func() error {
 		_, err := d.conn.gmail.Users.Drafts.Update(email, d.ID, &gmail.Draft{
 			Message: &gmail.Message{
-				Raw: MIMEEncode(content),
+				Raw:      MIMEEncode(content),
+				ThreadId: threadID,
 			},
 		}).Context(ctx).Do()
 		return err
-	}, "email=%q msgID=%v contents=%q", email, d.ID, content); err != nil {
+	}, "email=%q msgID=%v threadID=%q contents=%q", email, d.ID, threadID, content); err != nil {
 		return err
 	}
 
@@ -1275,15 +1354,19 @@ func (d *Draft) Update(ctx context.Context, content string) error {
 	return nil
 }
 
-// Send sends the draft. Sending a draft makes it no longer a draft.
-func (d *Draft) Send(ctx context.Context) error {
+// Send sends the draft and returns the sent message, which carries the new
+// message ID and its thread ID. Sending a draft makes it no longer a draft.
+func (d *Draft) Send(ctx context.Context) (*gmail.Message, error) {
 	if err := d.load(ctx, LevelFull); err != nil {
-		return errors.Wrap(err, "downloading draft for send")
+		return nil, errors.Wrap(err, "downloading draft for send")
 	}
-	return wrapLogRPC("gmail.USers.Drafts.Send", func() error {
-		_, err := d.conn.gmail.Users.Drafts.Send(email, d.Response).Context(ctx).Do()
+	var sent *gmail.Message
+	err := wrapLogRPC("gmail.USers.Drafts.Send", func() error {
+		var err error
+		sent, err = d.conn.gmail.Users.Drafts.Send(email, d.Response).Context(ctx).Do()
 		return err
 	}, "email=%q draftID=%v", email, d.ID)
+	return sent, err
 }
 
 // Delete deletes the draft.
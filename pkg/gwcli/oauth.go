@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -22,7 +23,8 @@ import (
 // Drive clients. Every scope the tool will ever need must be bundled here:
 // user-consent OAuth is not all-or-nothing, but Google will not add scopes
 // to an already-issued token, so anything omitted here can never be
-// consented to without re-running `gwcli configure`.
+// consented to without re-running `gwcli configure`. The one exception is
+// purgeScope, which is only requested on explicit opt-in.
 //
 // Service accounts do NOT use this list: ServiceAccountAuthenticator
 // requests the Drive scope separately (see DriveService) because
@@ -37,6 +39,36 @@ var authScopes = []string{
 	drive.DriveScope,
 }
 
+// purgeScope is the only Gmail scope that permanently deletes messages
+// (`trash empty`); gmail.modify can only move them to the trash. It grants
+// full mailbox access, so OAuth users are only asked for it by `gwcli
+// configure --allow-purge` (see AllowPurge). Service accounts request it
+// separately (see PurgeService), so domain-wide delegation that does not
+// authorize it keeps working.
+const purgeScope = gmail.MailGoogleComScope
+
+// cachedToken is the token file format: the OAuth token plus the scopes it
+// was granted, so later runs know whether it may purge. Token files without
+// a scope still decode as a plain oauth2.Token.
+type cachedToken struct {
+	*oauth2.Token
+	Scope string `json:"scope,omitempty"`
+}
+
+// tokenGrants reports whether a cached token file was granted scope.
+func tokenGrants(tokBytes []byte, scope string) bool {
+	var tok cachedToken
+	if err := json.Unmarshal(tokBytes, &tok); err != nil {
+		return false
+	}
+	for _, s := range strings.Fields(tok.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // IsServiceAccount reports whether the credentials JSON is a service-account
 // key (as opposed to an installed/desktop OAuth client).
 func IsServiceAccount(credentials io.Reader) (bool, error) {
@@ -75,6 +107,12 @@ func NewAuthenticator(credentials io.Reader) (*Authenticator, error) {
 	return &Authenticator{State: randomState(), cfg: cfg}, nil
 }
 
+// AllowPurge adds purgeScope to the scopes the consent screen asks for, so
+// the token can permanently delete messages.
+func (a *Authenticator) AllowPurge() {
+	a.cfg.Scopes = append(a.cfg.Scopes, purgeScope)
+}
+
 // AuthURL returns the consent-screen URL the user must visit to obtain an
 // authorization code, configured for the given local redirect URL.
 func (a *Authenticator) AuthURL(redirectURL string) string {
@@ -83,13 +121,14 @@ func (a *Authenticator) AuthURL(redirectURL string) string {
 }
 
 // CacheToken exchanges an authorization code for a token and writes it as
-// JSON to token.
+// JSON to token, along with the scopes the user granted.
 func (a *Authenticator) CacheToken(ctx context.Context, authCode string, token io.Writer) error {
 	tok, err := a.cfg.Exchange(ctx, authCode)
 	if err != nil {
 		return fmt.Errorf("exchanging auth code for token: %w", err)
 	}
-	return json.NewEncoder(token).Encode(tok)
+	scope, _ := tok.Extra("scope").(string)
+	return json.NewEncoder(token).Encode(cachedToken{Token: tok, Scope: scope})
 }
 
 // Service builds a Gmail client from a previously cached token. The same
@@ -176,6 +215,17 @@ func (a *ServiceAccountAuthenticator) DriveService(ctx context.Context) (*drive.
 	return drive.NewService(ctx, option.WithTokenSource(ts))
 }
 
+// PurgeService builds a Gmail client via domain-wide delegation that can
+// permanently delete messages. Only purgeScope is requested, for the same
+// reason as DriveService.
+func (a *ServiceAccountAuthenticator) PurgeService(ctx context.Context) (*gmail.Service, error) {
+	ts, err := a.tokenSource(ctx, purgeScope)
+	if err != nil {
+		return nil, err
+	}
+	return gmail.NewService(ctx, option.WithTokenSource(ts))
+}
+
 // randomState returns a cryptographically random OAuth state value.
 func randomState() string {
 	b := make([]byte, 128)
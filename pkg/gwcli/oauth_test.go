@@ -45,6 +45,37 @@ func TestNewAuthenticatorScopes(t *testing.T) {
 	}
 }
 
+func TestAllowPurge(t *testing.T) {
+	a, err := NewAuthenticator(strings.NewReader(fakeOAuthCreds))
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if strings.Contains(a.AuthURL("http://localhost:8080"), "mail.google.com") {
+		t.Errorf("full mailbox scope requested without AllowPurge: %v", a.cfg.Scopes)
+	}
+	a.AllowPurge()
+	if !strings.Contains(a.AuthURL("http://localhost:8080"), "mail.google.com") {
+		t.Errorf("AllowPurge() did not request the full mailbox scope: %v", a.cfg.Scopes)
+	}
+}
+
+func TestTokenGrants(t *testing.T) {
+	granted := `{"access_token":"a","refresh_token":"r","scope":"https://www.googleapis.com/auth/gmail.modify https://mail.google.com/"}`
+	if !tokenGrants([]byte(granted), purgeScope) {
+		t.Error("tokenGrants() = false for a token granted the purge scope")
+	}
+	// Tokens cached before scopes were recorded, or consented without
+	// --allow-purge, cannot purge.
+	for _, tok := range []string{
+		`{"access_token":"a","refresh_token":"r"}`,
+		`{"access_token":"a","scope":"https://www.googleapis.com/auth/gmail.modify"}`,
+	} {
+		if tokenGrants([]byte(tok), purgeScope) {
+			t.Errorf("tokenGrants(%s) = true", tok)
+		}
+	}
+}
+
 func TestIsServiceAccount(t *testing.T) {
 	if ok, err := IsServiceAccount(strings.NewReader(fakeOAuthCreds)); err != nil || ok {
 		t.Errorf("IsServiceAccount(installed) = (%v, %v), want (false, nil)", ok, err)
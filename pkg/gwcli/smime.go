@@ -61,11 +61,19 @@ func (msg *Message) trySMIMESigned(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to parse signer's cert")
 	}
-	// TODO: Verify that GetFrom() is in cert.EmailAddresses. Locking complicates this a bit.
 	log.Infof("Signed subject: %+v, emails: %v", cert.Subject, cert.EmailAddresses)
 	log.Infof("Issuer: %+v", cert.Issuer)
+	// openssl only accepts certificates that chain to a trusted CA, so a
+	// verified signature is also a trusted one. Matching the sender
+	// against the certificate's addresses is left to the caller.
+	var emails []string
+	for _, e := range cert.EmailAddresses {
+		emails = append(emails, strings.ToLower(e))
+	}
 	msg.gpgStatus = &gpg.Status{
 		GoodSignature: true,
+		Trusted:       true,
+		Emails:        emails,
 		Signed:        unprintableRE.ReplaceAllString(cert.Subject.String(), ""),
 	}
 	return nil
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// prefixSubject adds prefix ("Re:" or "Fwd:") to subject unless it is
+// already there.
+func prefixSubject(prefix, subject string) string {
+	subject = strings.TrimSpace(subject)
+	if strings.HasPrefix(strings.ToLower(subject), strings.ToLower(prefix)) {
+		return subject
+	}
+	if subject == "" {
+		return prefix
+	}
+	return prefix + " " + subject
+}
+
+// originalText returns the plain text body of a loaded message, converting
+// from HTML when no text/plain part exists.
+func originalText(msg *gwcli.Message) string {
+	if text := extractPlainTextFromPart(msg.Response.Payload); text != "" {
+		return text
+	}
+	if html := extractHTMLFromPart(msg.Response.Payload); html != "" {
+		return stripHTMLTags(html)
+	}
+	return msg.Response.Snippet
+}
+
+// quoteText prefixes every line of text with "> ".
+func quoteText(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\r\n"), "\n")
+	for i, l := range lines {
+		lines[i] = "> " + strings.TrimRight(l, "\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// optionalHeader returns a header of msg, or "" when it is missing.
+func optionalHeader(ctx context.Context, msg *gwcli.Message, name string) (string, error) {
+	v, err := msg.GetHeader(ctx, name)
+	if errors.Is(err, gwcli.ErrMissing) {
+		return "", nil
+	}
+	return v, err
+}
+
+// replyHeaders returns In-Reply-To and References values that thread a
+// reply to msg.
+func replyHeaders(ctx context.Context, msg *gwcli.Message) (string, string, error) {
+	msgID, err := optionalHeader(ctx, msg, "Message-ID")
+	if err != nil {
+		return "", "", err
+	}
+	refs, err := optionalHeader(ctx, msg, "References")
+	if err != nil {
+		return "", "", err
+	}
+	if refs == "" {
+		// Fall back to In-Reply-To so the chain survives clients that
+		// only set that header.
+		if refs, err = optionalHeader(ctx, msg, "In-Reply-To"); err != nil {
+			return "", "", err
+		}
+	}
+	refs = strings.Join(strings.Fields(refs+" "+msgID), " ")
+	return msgID, refs, nil
+}
+
+// withoutAddress drops addrs (case-insensitively) from a comma separated
+// address list.
+func withoutAddress(list string, addrs ...string) []string {
+	if list == "" {
+		return nil
+	}
+	as, err := mail.ParseAddressList(list)
+	if err != nil {
+		return []string{list}
+	}
+	var ret []string
+next:
+	for _, a := range as {
+		for _, addr := range addrs {
+			if addr != "" && strings.EqualFold(a.Address, addr) {
+				continue next
+			}
+		}
+		ret = append(ret, a.String())
+	}
+	return ret
+}
+
+// runMessagesReply replies to a message (or to all recipients with all),
+// setting In-Reply-To/References so the reply threads in every client.
+func runMessagesReply(ctx context.Context, conn *gwcli.CmdG, messageID string, all bool, body string, cc, bcc []string, attachments []string, html bool, sender senderOptions, out *outputWriter) error {
+	msg := gwcli.NewMessage(conn, messageID)
+	if err := msg.Preload(ctx, gwcli.LevelFull); err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+
+	var to []string
+	if all {
+		replyTo, replyCc, err := msg.GetReplyToAll(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get reply recipients: %w", err)
+		}
+		self := ""
+		if profile, err := conn.GetProfile(ctx); err != nil {
+			out.writeVerbose("Could not look up own address, keeping it in Cc: %v", err)
+		} else {
+			self = profile.EmailAddress
+		}
+		// Replying from an alias: leave that address out as well.
+		alias := ""
+		if sender.from != "" {
+			a, err := mail.ParseAddress(sender.from)
+			if err != nil {
+				return fmt.Errorf("invalid --from %q: %w", sender.from, err)
+			}
+			alias = a.Address
+		}
+		to = withoutAddress(replyTo, self, alias)
+		if len(to) == 0 {
+			// Replying to our own message: write to its original
+			// recipients again rather than to ourselves.
+			origTo, err := optionalHeader(ctx, msg, "To")
+			if err != nil {
+				return fmt.Errorf("failed to get reply recipients: %w", err)
+			}
+			if replyCc, err = optionalHeader(ctx, msg, "Cc"); err != nil {
+				return fmt.Errorf("failed to get reply recipients: %w", err)
+			}
+			to = withoutAddress(origTo, self, alias)
+		}
+		if len(to) == 0 {
+			// A note to self.
+			to = []string{replyTo}
+		}
+		cc = append(withoutAddress(replyCc, self, alias), cc...)
+	} else {
+		replyTo, err := msg.GetReplyTo(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get reply recipient: %w", err)
+		}
+		to = []string{replyTo}
+	}
+
+	subject, err := optionalHeader(ctx, msg, "Subject")
+	if err != nil {
+		return fmt.Errorf("failed to get subject: %w", err)
+	}
+	from, _ := optionalHeader(ctx, msg, "From")
+	date, _ := optionalHeader(ctx, msg, "Date")
+
+	if body == "" {
+		if body, err = readBodyFromStdin(); err != nil {
+			return err
+		}
+	}
+	// The signature goes below the reply and above the quote, as in Gmail.
+	body, extra, err := prepareSender(ctx, conn, sender, body, composeOptions{html: html})
+	if err != nil {
+		return err
+	}
+
+	attribution := fmt.Sprintf("On %s, %s wrote:", date, from)
+	if html {
+		quoted := extractHTMLFromPart(msg.Response.Payload)
+		if quoted == "" {
+			quoted = fmt.Sprintf("<pre>%s</pre>", escapeHTML(originalText(msg)))
+		}
+		body = fmt.Sprintf("%s\n<div class=\"gmail_quote\">%s<br>\n<blockquote class=\"gmail_quote\" style=\"margin:0 0 0 .8ex;border-left:1px #ccc solid;padding-left:1ex\">\n%s\n</blockquote></div>\n",
+			body, escapeHTML(attribution), quoted)
+	} else {
+		body = fmt.Sprintf("%s\n\n%s\n%s\n", body, attribution, quoteText(originalText(msg)))
+	}
+
+	headers, parts, err := buildOutgoingMessage(to, cc, bcc, prefixSubject("Re:", subject), body, attachments, nil, composeOptions{html: html})
+	if err != nil {
+		return err
+	}
+	mergeHeaders(headers, extra)
+	inReplyTo, references, err := replyHeaders(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to get threading headers: %w", err)
+	}
+	if inReplyTo != "" {
+		headers["In-Reply-To"] = []string{inReplyTo}
+	}
+	if references != "" {
+		headers["References"] = []string{references}
+	}
+
+	threadID := msg.Response.ThreadId
+	out.writeVerbose("Replying to %s in thread %s (to=%v cc=%v)", messageID, threadID, to, cc)
+	sent, err := conn.SendParts(ctx, gwcli.ThreadID(threadID), "mixed", headers, parts)
+	if err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]string{"status": "sent", "id": sent.Id, "threadId": sent.ThreadId})
+	}
+
+	out.writeMessage(fmt.Sprintf("Reply sent successfully (ID: %s)", sent.Id))
+	return nil
+}
+
+// collectAttachmentParts walks a payload and returns every part that
+// carries a named attachment.
+func collectAttachmentParts(part *gmail.MessagePart, found *[]*gmail.MessagePart) {
+	if part == nil {
+		return
+	}
+	if part.Filename != "" && part.Body != nil && (part.Body.AttachmentId != "" || part.Body.Data != "") {
+		*found = append(*found, part)
+	}
+	for _, p := range part.Parts {
+		collectAttachmentParts(p, found)
+	}
+}
+
+// originalAttachmentParts downloads the files in the payload of message
+// msgID and returns them as parts ready to be re-sent. With keepInline,
+// files embedded in the HTML body (those with a Content-ID) are returned
+// separately, as inline parts that keep their Content-ID; otherwise they are
+// attachments like the rest.
+func originalAttachmentParts(ctx context.Context, conn *gwcli.CmdG, msgID string, payload *gmail.MessagePart, keepInline bool, out *outputWriter) (attached, inline []*gwcli.Part, err error) {
+	var found []*gmail.MessagePart
+	collectAttachmentParts(payload, &found)
+	if len(found) == 0 {
+		return nil, nil, nil
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return nil, nil, fmt.Errorf("gmail service not initialized")
+	}
+
+	for _, p := range found {
+		data := p.Body.Data
+		if p.Body.AttachmentId != "" {
+			out.writeVerbose("Downloading attachment %q", p.Filename)
+			body, err := svc.Users.Messages.Attachments.Get("me", msgID, p.Body.AttachmentId).Context(ctx).Do()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to download attachment %s: %w", p.Filename, err)
+			}
+			data = body.Data
+		}
+		contents, err := gwcli.MIMEDecode(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode attachment %s: %w", p.Filename, err)
+		}
+		part := attachmentPart(p.Filename, p.MimeType, []byte(contents))
+		cid := headerValue(p, "Content-ID")
+		if !keepInline || cid == "" || strings.HasPrefix(strings.ToLower(headerValue(p, "Content-Disposition")), "attachment") {
+			attached = append(attached, part)
+			continue
+		}
+		part.Header.Set("Content-Disposition", fmt.Sprintf(`inline; filename=%q`, p.Filename))
+		part.Header.Set("Content-ID", cid)
+		inline = append(inline, part)
+	}
+	return attached, inline, nil
+}
+
+// runMessagesForward forwards a message with its original attachments.
+// body is an optional note placed above the forwarded message.
+func runMessagesForward(ctx context.Context, conn *gwcli.CmdG, messageID string, to, cc, bcc []string, body string, attachments []string, sender senderOptions, out *outputWriter) error {
+	sent, origParts, err := forwardMessage(ctx, conn, messageID, to, cc, bcc, body, attachments, sender, out)
+	if err != nil {
+		return err
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]interface{}{
+			"status":          "sent",
+			"id":              sent.Id,
+			"threadId":        sent.ThreadId,
+			"attachmentCount": origParts,
+		})
+	}
+
+	out.writeMessage(fmt.Sprintf("Message forwarded successfully (ID: %s)", sent.Id))
+	return nil
+}
+
+// forwardMessage forwards a message with its attachments and returns the
+// sent message and the number of original attachments included. The alias
+// signature, if any, follows the note above the forwarded message.
+func forwardMessage(ctx context.Context, conn *gwcli.CmdG, messageID string, to, cc, bcc []string, body string, attachments []string, sender senderOptions, out *outputWriter) (*gmail.Message, int, error) {
+	msg := gwcli.NewMessage(conn, messageID)
+	if err := msg.Preload(ctx, gwcli.LevelFull); err != nil {
+		return nil, 0, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	var lines []string
+	for _, h := range []string{"From", "Date", "Subject", "To", "Cc"} {
+		v, err := optionalHeader(ctx, msg, h)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get %s header: %w", h, err)
+		}
+		if v != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", h, v))
+		}
+	}
+	subject, _ := optionalHeader(ctx, msg, "Subject")
+
+	body, extra, err := prepareSender(ctx, conn, sender, body, composeOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	body = strings.TrimLeft(body, "\n")
+	forwarded := fmt.Sprintf("---------- Forwarded message ---------\n%s\n\n%s\n",
+		strings.Join(lines, "\n"), originalText(msg))
+	if body != "" {
+		forwarded = body + "\n\n" + forwarded
+	}
+
+	headers, parts, err := buildOutgoingMessage(to, cc, bcc, prefixSubject("Fwd:", subject), forwarded, attachments, nil, composeOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	mergeHeaders(headers, extra)
+
+	origParts, _, err := originalAttachmentParts(ctx, conn, msg.ID, msg.Response.Payload, false, out)
+	if err != nil {
+		return nil, 0, err
+	}
+	parts = append(parts, origParts...)
+
+	out.writeVerbose("Forwarding %s with %d original attachment(s)", messageID, len(origParts))
+	sent, err := conn.SendParts(ctx, gwcli.NewThread, "mixed", headers, parts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to forward message: %w", err)
+	}
+	return sent, len(origParts), nil
+}
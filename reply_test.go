@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+const replyTestMessage = `{"id":"M1","threadId":"T1","payload":{"mimeType":"multipart/mixed","headers":[
+	{"name":"From","value":"Alice <alice@example.com>"},
+	{"name":"To","value":"me@example.com, bob@example.com"},
+	{"name":"Cc","value":"carol@example.com"},
+	{"name":"Subject","value":"Quarterly report"},
+	{"name":"Date","value":"Mon, 2 Jun 2025 10:00:00 +0000"},
+	{"name":"Message-ID","value":"<orig@example.com>"},
+	{"name":"References","value":"<root@example.com>"}],
+	"parts":[
+		{"partId":"0","mimeType":"text/plain","body":{"data":"bGluZSBvbmUKbGluZSB0d28="}},
+		{"partId":"1","mimeType":"application/pdf","filename":"report.pdf","headers":[{"name":"Content-Disposition","value":"attachment"}],"body":{"attachmentId":"A1","size":3}}]}}`
+
+// newFakeReplyConn serves message as M1, a default alias signed "Me", and
+// records the message sent.
+func newFakeReplyConn(t *testing.T, message string, sent **gmail.Message) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch path {
+		case "profile":
+			return `{"emailAddress":"me@example.com"}`
+		case "settings/sendAs":
+			return `{"sendAs":[{"sendAsEmail":"me@example.com","isPrimary":true,"isDefault":true,"signature":"Me"},{"sendAsEmail":"team@example.com","verificationStatus":"accepted"}]}`
+		case "messages/M1/attachments/A1":
+			return `{"data":"UERG","size":3}`
+		case "messages/M1":
+			return message
+		case "messages/send":
+			*sent = &gmail.Message{}
+			decodeRequest(t, req, *sent)
+			return `{"id":"S1","threadId":"T1"}`
+		}
+		return nil
+	})
+}
+
+func decodeSent(t *testing.T, sent *gmail.Message) string {
+	t.Helper()
+	if sent == nil {
+		t.Fatal("no message was sent")
+	}
+	raw, err := gwcli.MIMEDecode(sent.Raw)
+	if err != nil {
+		t.Fatalf("decode raw: %v", err)
+	}
+	return raw
+}
+
+func TestRunMessagesReplyAll_ThreadsAndQuotes(t *testing.T) {
+	var sent *gmail.Message
+	conn := newFakeReplyConn(t, replyTestMessage, &sent)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesReply(context.Background(), conn, "M1", true, "Thanks!", nil, nil, nil, false, senderOptions{signature: true}, out); err != nil {
+		t.Fatalf("runMessagesReply() error = %v", err)
+	}
+
+	raw := decodeSent(t, sent)
+	if sent.ThreadId != "T1" {
+		t.Errorf("threadId = %q, want T1", sent.ThreadId)
+	}
+	var res map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res["id"] != "S1" || res["threadId"] != "T1" {
+		t.Errorf("output = %v, want id S1 and threadId T1", res)
+	}
+	for _, want := range []string{
+		"In-Reply-To: <orig@example.com>",
+		"References: <root@example.com> <orig@example.com>",
+		"Subject: Re: Quarterly report",
+		"To: \"Alice\" <alice@example.com>",
+		"Thanks!",
+		"> line one\r\n> line two",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("sent message missing %q:\n%s", want, raw)
+		}
+	}
+	sig, quote := strings.Index(raw, "--=20\r\nMe\r\n"), strings.Index(raw, "> line one")
+	if sig < 0 || sig > quote {
+		t.Errorf("want the alias signature between the reply and the quote:\n%s", raw)
+	}
+	ccLine := ""
+	for _, l := range strings.Split(raw, "\r\n") {
+		if strings.HasPrefix(l, "Cc: ") {
+			ccLine = l
+		}
+	}
+	if !strings.Contains(ccLine, "bob@example.com") || !strings.Contains(ccLine, "carol@example.com") {
+		t.Errorf("Cc = %q, want bob and carol", ccLine)
+	}
+	if strings.Contains(ccLine, "me@example.com") {
+		t.Errorf("Cc = %q, should not include own address", ccLine)
+	}
+}
+
+func TestRunMessagesReplyAll_OwnMessage(t *testing.T) {
+	own := strings.NewReplacer(
+		`"From","value":"Alice <alice@example.com>"`, `"From","value":"Me <me@example.com>"`,
+		`"To","value":"me@example.com, bob@example.com"`, `"To","value":"bob@example.com, dave@example.com"`,
+	).Replace(replyTestMessage)
+	var sent *gmail.Message
+	conn := newFakeReplyConn(t, own, &sent)
+
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	if err := runMessagesReply(context.Background(), conn, "M1", true, "Following up", nil, nil, nil, false, senderOptions{}, out); err != nil {
+		t.Fatalf("runMessagesReply() error = %v", err)
+	}
+
+	var toLine, ccLine string
+	for _, l := range strings.Split(decodeSent(t, sent), "\r\n") {
+		switch {
+		case strings.HasPrefix(l, "To: "):
+			toLine = l
+		case strings.HasPrefix(l, "Cc: "):
+			ccLine = l
+		}
+	}
+	if toLine != "To: bob@example.com, dave@example.com" {
+		t.Errorf("To = %q, want the original recipients", toLine)
+	}
+	if ccLine != "Cc: carol@example.com" {
+		t.Errorf("Cc = %q, want the original Cc", ccLine)
+	}
+}
+
+func TestRunMessagesReplyAll_FromAlias(t *testing.T) {
+	toAlias := strings.Replace(replyTestMessage,
+		`"To","value":"me@example.com, bob@example.com"`, `"To","value":"Team <team@example.com>, bob@example.com"`, 1)
+	var sent *gmail.Message
+	conn := newFakeReplyConn(t, toAlias, &sent)
+
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	if err := runMessagesReply(context.Background(), conn, "M1", true, "Thanks!", nil, nil, nil, false, senderOptions{from: "Team@example.com"}, out); err != nil {
+		t.Fatalf("runMessagesReply() error = %v", err)
+	}
+
+	for _, l := range strings.Split(decodeSent(t, sent), "\r\n") {
+		if strings.HasPrefix(l, "Cc: ") && (strings.Contains(l, "team@example.com") || !strings.Contains(l, "bob@example.com")) {
+			t.Errorf("Cc = %q, want bob and carol without the alias", l)
+		}
+	}
+}
+
+func TestRunMessagesForward_ReattachesOriginals(t *testing.T) {
+	var sent *gmail.Message
+	conn := newFakeReplyConn(t, replyTestMessage, &sent)
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesForward(context.Background(), conn, "M1", []string{"dave@example.com"}, nil, nil, "FYI", nil, senderOptions{}, out); err != nil {
+		t.Fatalf("runMessagesForward() error = %v", err)
+	}
+
+	raw := decodeSent(t, sent)
+	var res map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if res["attachmentCount"] != float64(1) {
+		t.Errorf("attachmentCount = %v, want 1", res["attachmentCount"])
+	}
+	if sent.ThreadId != "" {
+		t.Errorf("forward should start a new thread, got threadId %q", sent.ThreadId)
+	}
+	for _, want := range []string{
+		"Subject: Fwd: Quarterly report",
+		"To: dave@example.com",
+		"FYI",
+		"---------- Forwarded message ---------",
+		"From: Alice <alice@example.com>",
+		`filename="report.pdf"`,
+		"Content-Transfer-Encoding: base64",
+		"UERG", // "PDF"
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("forwarded message missing %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestPrefixSubject(t *testing.T) {
+	tests := []struct{ prefix, in, want string }{
+		{"Re:", "Hello", "Re: Hello"},
+		{"Re:", "RE: Hello", "RE: Hello"},
+		{"Fwd:", "Re: Hello", "Fwd: Re: Hello"},
+		{"Fwd:", "", "Fwd:"},
+	}
+	for _, tt := range tests {
+		if got := prefixSubject(tt.prefix, tt.in); got != tt.want {
+			t.Errorf("prefixSubject(%q, %q) = %q, want %q", tt.prefix, tt.in, got, tt.want)
+		}
+	}
+}
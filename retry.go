@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// apiRetries is how many times a rate limited or failed Gmail API call is
+// retried, waiting apiRetryDelay and then twice as long each time.
+const apiRetries = 4
+
+var apiRetryDelay = time.Second
+
+// isRetryable reports whether a Gmail API error is worth retrying: rate
+// limits and server errors.
+func isRetryable(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	if gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500 {
+		return true
+	}
+	for _, e := range gerr.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAPI calls fn until it succeeds, fails with an error that is not
+// retryable, or has been retried apiRetries times, backing off in between.
+// onRetry, if set, is told about each retry.
+func retryAPI(ctx context.Context, fn func() error, onRetry func(err error, delay time.Duration)) error {
+	delay := apiRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == apiRetries || !isRetryable(err) {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(err, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
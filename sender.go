@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// senderOptions are the sender-related flags of the commands that send
+// mail.
+type senderOptions struct {
+	from      string
+	replyTo   string
+	headers   []string
+	signature bool
+}
+
+// senderFlags declares the sender flags for kong; embed it in a command.
+type senderFlags struct {
+	From      string   `help:"Send from this send-as address (see 'settings sendas list')"`
+	ReplyTo   string   `help:"Reply-To address" name:"reply-to"`
+	Header    []string `help:"Extra header as 'Name: value' (repeatable)" sep:"none"`
+	Signature bool     `help:"Append the signature of the --from address (the default send-as address without --from)"`
+}
+
+func (f senderFlags) options() senderOptions {
+	return senderOptions{from: f.From, replyTo: f.ReplyTo, headers: f.Header, signature: f.Signature}
+}
+
+// reservedHeaders are set by gwcli itself and cannot be passed to --header.
+var reservedHeaders = map[string]string{
+	"From":                      "--from",
+	"To":                        "--to",
+	"Cc":                        "--cc",
+	"Bcc":                       "--bcc",
+	"Subject":                   "--subject",
+	"Reply-To":                  "--reply-to",
+	"Mime-Version":              "",
+	"Content-Type":              "",
+	"Content-Transfer-Encoding": "",
+	"Content-Disposition":       "",
+}
+
+// parseHeaderFlags parses repeatable --header "Name: value" flags.
+func parseHeaderFlags(flags []string) (mail.Header, error) {
+	head := mail.Header{}
+	for _, f := range flags {
+		name, value, ok := strings.Cut(f, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --header %q (want Name: value)", f)
+		}
+		if strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid --header %q", f)
+		}
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		if flag, ok := reservedHeaders[key]; ok {
+			if flag != "" {
+				return nil, fmt.Errorf("use %s instead of --header %s", flag, name)
+			}
+			return nil, fmt.Errorf("--header cannot set %s", name)
+		}
+		head[key] = append(head[key], strings.TrimSpace(value))
+	}
+	return head, nil
+}
+
+// resolveSender finds the send-as alias to send from. With from empty it
+// returns the default alias, or nil if there is none. With from set it must
+// name a verified send-as address of the account.
+func resolveSender(ctx context.Context, conn *gwcli.CmdG, from string) (*gmail.SendAs, string, error) {
+	var addr *mail.Address
+	if from != "" {
+		var err error
+		if addr, err = mail.ParseAddress(from); err != nil {
+			return nil, "", fmt.Errorf("invalid --from %q: %w", from, err)
+		}
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return nil, "", fmt.Errorf("gmail service not initialized")
+	}
+	resp, err := svc.Users.Settings.SendAs.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list send-as addresses: %w", err)
+	}
+
+	if addr == nil {
+		for _, s := range resp.SendAs {
+			if s.IsDefault {
+				return s, "", nil
+			}
+		}
+		return nil, "", nil
+	}
+
+	for _, s := range resp.SendAs {
+		if !strings.EqualFold(s.SendAsEmail, addr.Address) {
+			continue
+		}
+		if !s.IsPrimary && s.VerificationStatus != "accepted" {
+			return nil, "", fmt.Errorf("send-as address %s is not verified (status: %s)", s.SendAsEmail, s.VerificationStatus)
+		}
+		name := addr.Name
+		if name == "" {
+			name = s.DisplayName
+		}
+		return s, (&mail.Address{Name: name, Address: s.SendAsEmail}).String(), nil
+	}
+	return nil, "", fmt.Errorf("%s is not a send-as address of this account (see 'gwcli settings sendas list')", addr.Address)
+}
+
+// senderHeaders resolves the sender options once: it validates --from and
+// returns the extra headers to merge into the message headers and the alias
+// signature to append ("" for none).
+func senderHeaders(ctx context.Context, conn *gwcli.CmdG, opts senderOptions) (mail.Header, string, error) {
+	head, err := parseHeaderFlags(opts.headers)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.replyTo != "" {
+		if _, err := mail.ParseAddressList(opts.replyTo); err != nil {
+			return nil, "", fmt.Errorf("invalid --reply-to %q: %w", opts.replyTo, err)
+		}
+		head["Reply-To"] = []string{opts.replyTo}
+	}
+
+	// Without --from or --signature there is nothing to look up.
+	if opts.from == "" && !opts.signature {
+		return head, "", nil
+	}
+	alias, fromHeader, err := resolveSender(ctx, conn, opts.from)
+	if err != nil {
+		return nil, "", err
+	}
+	if fromHeader != "" {
+		head["From"] = []string{fromHeader}
+	}
+	if alias == nil || !opts.signature {
+		return head, "", nil
+	}
+	return head, alias.Signature, nil
+}
+
+// prepareSender applies the sender options to an outgoing message: it
+// validates --from, appends the alias signature to body, and returns the
+// extra headers to merge into the message headers. Every command that
+// composes mail for the user goes through it.
+func prepareSender(ctx context.Context, conn *gwcli.CmdG, opts senderOptions, body string, compose composeOptions) (string, mail.Header, error) {
+	head, signature, err := senderHeaders(ctx, conn, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	if signature != "" {
+		body = appendSignature(body, signature, compose.html, compose.markdown)
+	}
+	return body, head, nil
+}
+
+// mergeHeaders adds extra headers to head.
+func mergeHeaders(head, extra mail.Header) {
+	for k, vs := range extra {
+		head[k] = append(head[k], vs...)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+const testSendAs = `{"sendAs":[
+	{"sendAsEmail":"me@example.com","displayName":"Me","isPrimary":true,"isDefault":true,"signature":"<b>Me</b><br>Example Inc."},
+	{"sendAsEmail":"support@example.com","displayName":"Jörg Support","verificationStatus":"accepted","signature":"Support team"},
+	{"sendAsEmail":"pending@example.com","verificationStatus":"pending"}
+]}`
+
+// newFakeSenderConn serves the send-as list and records the raw sent message.
+func newFakeSenderConn(t *testing.T, sent *string) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch path {
+		case "settings/sendAs":
+			return testSendAs
+		case "messages/send":
+			var msg gmail.Message
+			decodeRequest(t, req, &msg)
+			*sent = decodeSent(t, &msg)
+			return `{"id":"S1","threadId":"S1"}`
+		}
+		return nil
+	})
+}
+
+func TestRunMessagesSend_FromAlias(t *testing.T) {
+	var sent string
+	conn := newFakeSenderConn(t, &sent)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	err := runMessagesSend(context.Background(), conn, []string{"Zoë <zoe@example.com>"}, nil, nil, "Grüße aus Köln", "Hello\n",
+		nil, nil, "", composeOptions{}, senderOptions{
+			from:      "support@example.com",
+			replyTo:   "help@example.com",
+			headers:   []string{"X-Ticket: 1234", "List-Unsubscribe: <mailto:u@example.com>"},
+			signature: true,
+		}, out)
+	if err != nil {
+		t.Fatalf("runMessagesSend() error = %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(sent))
+	if err != nil {
+		t.Fatalf("parse sent message: %v", err)
+	}
+	for name, want := range map[string]string{
+		"From":             "=?utf-8?q?J=C3=B6rg_Support?= <support@example.com>",
+		"To":               "=?utf-8?q?Zo=C3=AB?= <zoe@example.com>",
+		"Subject":          "=?utf-8?q?Gr=C3=BC=C3=9Fe_aus_K=C3=B6ln?=",
+		"Reply-To":         "help@example.com",
+		"X-Ticket":         "1234",
+		"List-Unsubscribe": "<mailto:u@example.com>",
+	} {
+		if got := msg.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	// The "-- " separator's trailing space makes the part quoted-printable;
+	// multipart.Reader decodes it.
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parse Content-Type: %v", err)
+	}
+	part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("read body part: %v", err)
+	}
+	body, _ := io.ReadAll(part)
+	if !strings.Contains(string(body), "Hello\r\n\r\n-- \r\nSupport team\r\n") {
+		t.Errorf("signature not appended:\n%s", sent)
+	}
+}
+
+func TestRunMessagesSend_DefaultSignatureHTML(t *testing.T) {
+	var sent string
+	conn := newFakeSenderConn(t, &sent)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runMessagesSend(context.Background(), conn, []string{"a@example.com"}, nil, nil, "Hi", "<p>Hello</p>",
+		nil, nil, "", composeOptions{html: true}, senderOptions{signature: true}, out); err != nil {
+		t.Fatalf("runMessagesSend() error = %v", err)
+	}
+	if !strings.Contains(sent, `<p>Hello</p><br><br><div class="gmail_signature"><b>Me</b><br>Example Inc.</div>`) {
+		t.Errorf("HTML signature not appended:\n%s", sent)
+	}
+	if strings.Contains(sent, "\nFrom:") {
+		t.Errorf("From should be left to Gmail without --from:\n%s", sent)
+	}
+}
+
+func TestRunMessagesSend_NoSignatureByDefault(t *testing.T) {
+	var sent string
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "messages/send" {
+			return nil
+		}
+		var msg gmail.Message
+		decodeRequest(t, req, &msg)
+		sent = decodeSent(t, &msg)
+		return `{"id":"S1","threadId":"S1"}`
+	})
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runMessagesSend(context.Background(), conn, []string{"a@example.com"}, nil, nil, "Hi", "Hello\n",
+		nil, nil, "", composeOptions{}, senderOptions{}, out); err != nil {
+		t.Fatalf("runMessagesSend() error = %v", err)
+	}
+	if strings.Contains(sent, "Example Inc.") || strings.Contains(sent, "-- ") {
+		t.Errorf("signature appended without --signature:\n%s", sent)
+	}
+}
+
+func TestPrepareSender_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts senderOptions
+		want string
+	}{
+		{"unknown alias", senderOptions{from: "other@example.com"}, "not a send-as address"},
+		{"unverified alias", senderOptions{from: "pending@example.com"}, "not verified"},
+		{"reserved header", senderOptions{headers: []string{"subject: x"}}, "use --subject instead"},
+		{"content header", senderOptions{headers: []string{"Content-Type: text/html"}}, "cannot set Content-Type"},
+		{"malformed header", senderOptions{headers: []string{"X-Foo"}}, "want Name: value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent string
+			_, _, err := prepareSender(context.Background(), newFakeSenderConn(t, &sent), tt.opts, "body", composeOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// settingsVacationSetCmd holds the flags for `gwcli settings vacation set`.
+type settingsVacationSetCmd struct {
+	Subject      string `help:"Auto-reply subject (default: Re: original subject)"`
+	Message      string `help:"Auto-reply message"`
+	MessageFile  string `help:"Read the auto-reply message from a file" name:"message-file" type:"existingfile"`
+	HTML         bool   `help:"Message is HTML"`
+	Start        string `help:"First day of the responder (YYYY-MM-DD or RFC3339)"`
+	End          string `help:"Last day of the responder, inclusive (YYYY-MM-DD or RFC3339)"`
+	ContactsOnly bool   `help:"Only reply to people in your contacts" name:"contacts-only"`
+	DomainOnly   bool   `help:"Only reply to people in your Workspace domain" name:"domain-only"`
+}
+
+// settingsSendAsUpdateCmd holds the flags for `gwcli settings sendas update`.
+type settingsSendAsUpdateCmd struct {
+	Email          string `arg:"" name:"email" help:"Send-as address to update"`
+	DisplayName    string `help:"Name shown in the From header" name:"display-name"`
+	ReplyTo        string `help:"Reply-To address for mail sent from this alias" name:"reply-to"`
+	Signature      string `help:"HTML signature"`
+	SignatureFile  string `help:"Read the HTML signature from a file" name:"signature-file" type:"existingfile"`
+	ClearSignature bool   `help:"Remove the signature" name:"clear-signature"`
+	Default        bool   `help:"Make this the default send-as address"`
+}
+
+// settingsImapSetCmd holds the flags for `gwcli settings imap set`.
+type settingsImapSetCmd struct {
+	Enable          bool   `help:"Enable IMAP access"`
+	Disable         bool   `help:"Disable IMAP access"`
+	AutoExpunge     string `help:"Expunge immediately when a message is deleted in IMAP (on or off)" name:"auto-expunge"`
+	ExpungeBehavior string `help:"What happens to expunged messages (archive, trash or delete)" name:"expunge-behavior"`
+	MaxFolderSize   int64  `help:"Max messages per IMAP folder (0, 1000, 2000, 5000 or 10000; 0 = no limit, -1 = unchanged)" name:"max-folder-size" default:"-1"`
+}
+
+// settingsPopSetCmd holds the flags for `gwcli settings pop set`.
+type settingsPopSetCmd struct {
+	Access      string `help:"Which messages POP can fetch (all, from-now-on or disabled)"`
+	Disposition string `help:"What happens to fetched messages (keep, archive, trash or mark-read)"`
+}
+
+// vacationOutput is the JSON output format for the vacation responder.
+type vacationOutput struct {
+	Enabled      bool   `json:"enabled"`
+	Subject      string `json:"subject,omitempty"`
+	Message      string `json:"message,omitempty"`
+	HTML         bool   `json:"html"`
+	Start        string `json:"start,omitempty"`
+	End          string `json:"end,omitempty"`
+	ContactsOnly bool   `json:"contactsOnly"`
+	DomainOnly   bool   `json:"domainOnly"`
+}
+
+// sendAsOutput is the JSON output format for a send-as alias.
+type sendAsOutput struct {
+	Email              string `json:"email"`
+	DisplayName        string `json:"displayName,omitempty"`
+	ReplyTo            string `json:"replyTo,omitempty"`
+	Signature          string `json:"signature,omitempty"`
+	IsPrimary          bool   `json:"isPrimary"`
+	IsDefault          bool   `json:"isDefault"`
+	TreatAsAlias       bool   `json:"treatAsAlias"`
+	VerificationStatus string `json:"verificationStatus,omitempty"`
+}
+
+// imapOutput is the JSON output format for IMAP settings.
+type imapOutput struct {
+	Enabled         bool   `json:"enabled"`
+	AutoExpunge     bool   `json:"autoExpunge"`
+	ExpungeBehavior string `json:"expungeBehavior,omitempty"`
+	MaxFolderSize   int64  `json:"maxFolderSize"`
+}
+
+// popOutput is the JSON output format for POP settings.
+type popOutput struct {
+	Access      string `json:"access"`
+	Disposition string `json:"disposition,omitempty"`
+}
+
+// Flag values for IMAP and POP settings mapped to their API enums.
+var (
+	expungeBehaviors = map[string]string{
+		"archive": "archive",
+		"trash":   "trash",
+		"delete":  "deleteForever",
+	}
+	popAccessWindows = map[string]string{
+		"all":         "allMail",
+		"from-now-on": "fromNowOn",
+		"disabled":    "disabled",
+	}
+	popDispositions = map[string]string{
+		"keep":      "leaveInInbox",
+		"archive":   "archive",
+		"trash":     "trash",
+		"mark-read": "markRead",
+	}
+)
+
+// flagValueFor returns the flag value that maps to an API enum value, so
+// output uses the same words as the flags.
+func flagValueFor(values map[string]string, apiValue string) string {
+	for k, v := range values {
+		if v == apiValue {
+			return k
+		}
+	}
+	return apiValue
+}
+
+// lookupFlagValue maps a flag value to its API enum value.
+func lookupFlagValue(flag, value string, values map[string]string) (string, error) {
+	if v, ok := values[value]; ok {
+		return v, nil
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return "", fmt.Errorf("invalid --%s %q (want one of: %s)", flag, value, strings.Join(keys, ", "))
+}
+
+// parseVacationTime parses a --start or --end value. A bare date is the
+// start of that day in local time; with endOfDay it is the start of the
+// following day, so the end date is inclusive.
+func parseVacationTime(s string, endOfDay bool) (int64, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UnixMilli(), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC3339)", s)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.UnixMilli(), nil
+}
+
+func toVacationOutput(v *gmail.VacationSettings) vacationOutput {
+	vo := vacationOutput{
+		Enabled:      v.EnableAutoReply,
+		Subject:      v.ResponseSubject,
+		Message:      v.ResponseBodyPlainText,
+		ContactsOnly: v.RestrictToContacts,
+		DomainOnly:   v.RestrictToDomain,
+	}
+	if v.ResponseBodyHtml != "" {
+		vo.Message = v.ResponseBodyHtml
+		vo.HTML = true
+	}
+	if v.StartTime != 0 {
+		vo.Start = time.UnixMilli(v.StartTime).Format(time.RFC3339)
+	}
+	if v.EndTime != 0 {
+		vo.End = time.UnixMilli(v.EndTime).Format(time.RFC3339)
+	}
+	return vo
+}
+
+func writeVacation(v *gmail.VacationSettings, out *outputWriter) error {
+	vo := toVacationOutput(v)
+	if out.json {
+		return out.writeJSON(vo)
+	}
+	headers := []string{"FIELD", "VALUE"}
+	rows := [][]string{
+		{"Enabled", fmt.Sprintf("%t", vo.Enabled)},
+		{"Subject", vo.Subject},
+		{"Message", truncateString(vo.Message, 60)},
+		{"HTML", fmt.Sprintf("%t", vo.HTML)},
+		{"Start", vo.Start},
+		{"End", vo.End},
+		{"ContactsOnly", fmt.Sprintf("%t", vo.ContactsOnly)},
+		{"DomainOnly", fmt.Sprintf("%t", vo.DomainOnly)},
+	}
+	return out.writeTable(headers, rows)
+}
+
+// runSettingsVacationGet shows the vacation responder.
+func runSettingsVacationGet(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) error {
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	v, err := svc.Users.Settings.GetVacation("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get vacation settings: %w", err)
+	}
+	return writeVacation(v, out)
+}
+
+// runSettingsVacationSet turns on the vacation responder, replacing any
+// previous responder settings.
+func runSettingsVacationSet(ctx context.Context, conn *gwcli.CmdG, c settingsVacationSetCmd, out *outputWriter) error {
+	message := c.Message
+	if c.MessageFile != "" {
+		if message != "" {
+			return fmt.Errorf("--message and --message-file cannot be used together")
+		}
+		data, err := os.ReadFile(c.MessageFile)
+		if err != nil {
+			return fmt.Errorf("failed to read message file: %w", err)
+		}
+		message = string(data)
+	}
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("an auto-reply message is required (--message or --message-file)")
+	}
+
+	v := &gmail.VacationSettings{
+		EnableAutoReply:    true,
+		ResponseSubject:    c.Subject,
+		RestrictToContacts: c.ContactsOnly,
+		RestrictToDomain:   c.DomainOnly,
+		ForceSendFields:    []string{"EnableAutoReply", "RestrictToContacts", "RestrictToDomain"},
+	}
+	if c.HTML {
+		v.ResponseBodyHtml = message
+	} else {
+		v.ResponseBodyPlainText = message
+	}
+	var err error
+	if c.Start != "" {
+		if v.StartTime, err = parseVacationTime(c.Start, false); err != nil {
+			return fmt.Errorf("--start: %w", err)
+		}
+	}
+	if c.End != "" {
+		if v.EndTime, err = parseVacationTime(c.End, true); err != nil {
+			return fmt.Errorf("--end: %w", err)
+		}
+	}
+	if v.StartTime != 0 && v.EndTime != 0 && v.EndTime <= v.StartTime {
+		return fmt.Errorf("--end must be after --start")
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	out.writeVerbose("Updating vacation responder...")
+	updated, err := svc.Users.Settings.UpdateVacation("me", v).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update vacation settings: %w", err)
+	}
+	if out.json {
+		return out.writeJSON(toVacationOutput(updated))
+	}
+	out.writeMessage("Vacation responder enabled")
+	return nil
+}
+
+// runSettingsVacationOff turns off the vacation responder, keeping its
+// message for next time.
+func runSettingsVacationOff(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) error {
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	v, err := svc.Users.Settings.GetVacation("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get vacation settings: %w", err)
+	}
+	v.EnableAutoReply = false
+	v.ForceSendFields = append(v.ForceSendFields, "EnableAutoReply")
+
+	out.writeVerbose("Turning off vacation responder...")
+	updated, err := svc.Users.Settings.UpdateVacation("me", v).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update vacation settings: %w", err)
+	}
+	if out.json {
+		return out.writeJSON(toVacationOutput(updated))
+	}
+	out.writeMessage("Vacation responder disabled")
+	return nil
+}
+
+func toSendAsOutput(s *gmail.SendAs) sendAsOutput {
+	return sendAsOutput{
+		Email:              s.SendAsEmail,
+		DisplayName:        s.DisplayName,
+		ReplyTo:            s.ReplyToAddress,
+		Signature:          s.Signature,
+		IsPrimary:          s.IsPrimary,
+		IsDefault:          s.IsDefault,
+		TreatAsAlias:       s.TreatAsAlias,
+		VerificationStatus: s.VerificationStatus,
+	}
+}
+
+func writeSendAs(s *gmail.SendAs, out *outputWriter) error {
+	so := toSendAsOutput(s)
+	if out.json {
+		return out.writeJSON(so)
+	}
+	headers := []string{"FIELD", "VALUE"}
+	rows := [][]string{
+		{"Email", so.Email},
+		{"DisplayName", so.DisplayName},
+		{"ReplyTo", so.ReplyTo},
+		{"Signature", truncateString(so.Signature, 60)},
+		{"IsPrimary", fmt.Sprintf("%t", so.IsPrimary)},
+		{"IsDefault", fmt.Sprintf("%t", so.IsDefault)},
+		{"TreatAsAlias", fmt.Sprintf("%t", so.TreatAsAlias)},
+		{"VerificationStatus", so.VerificationStatus},
+	}
+	return out.writeTable(headers, rows)
+}
+
+// runSettingsSendAsList lists the send-as aliases.
+func runSettingsSendAsList(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) error {
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	resp, err := svc.Users.Settings.SendAs.List("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list send-as addresses: %w", err)
+	}
+
+	if out.json {
+		output := make([]sendAsOutput, len(resp.SendAs))
+		for i, s := range resp.SendAs {
+			output[i] = toSendAsOutput(s)
+		}
+		return out.writeJSON(output)
+	}
+
+	if len(resp.SendAs) == 0 {
+		return out.WriteEmptyList("No send-as addresses found")
+	}
+
+	headers := []string{"EMAIL", "NAME", "DEFAULT", "PRIMARY", "VERIFICATION"}
+	rows := make([][]string, len(resp.SendAs))
+	for i, s := range resp.SendAs {
+		rows[i] = []string{
+			s.SendAsEmail,
+			s.DisplayName,
+			fmt.Sprintf("%t", s.IsDefault),
+			fmt.Sprintf("%t", s.IsPrimary),
+			s.VerificationStatus,
+		}
+	}
+	return out.writeTable(headers, rows)
+}
+
+// runSettingsSendAsGet shows a single send-as alias, including its signature.
+func runSettingsSendAsGet(ctx context.Context, conn *gwcli.CmdG, email string, out *outputWriter) error {
+	if email == "" {
+		return fmt.Errorf("send-as email is required")
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	s, err := svc.Users.Settings.SendAs.Get("me", email).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get send-as address: %w", err)
+	}
+	return writeSendAs(s, out)
+}
+
+// runSettingsSendAsUpdate updates a send-as alias. Unset flags leave the
+// existing values alone.
+func runSettingsSendAsUpdate(ctx context.Context, conn *gwcli.CmdG, c settingsSendAsUpdateCmd, out *outputWriter) error {
+	if c.Email == "" {
+		return fmt.Errorf("send-as email is required")
+	}
+
+	patch := &gmail.SendAs{
+		DisplayName:    c.DisplayName,
+		ReplyToAddress: c.ReplyTo,
+		Signature:      c.Signature,
+	}
+	if c.SignatureFile != "" {
+		if c.Signature != "" {
+			return fmt.Errorf("--signature and --signature-file cannot be used together")
+		}
+		data, err := os.ReadFile(c.SignatureFile)
+		if err != nil {
+			return fmt.Errorf("failed to read signature file: %w", err)
+		}
+		patch.Signature = string(data)
+	}
+	if c.ClearSignature {
+		if patch.Signature != "" {
+			return fmt.Errorf("--clear-signature cannot be used with --signature or --signature-file")
+		}
+		patch.ForceSendFields = append(patch.ForceSendFields, "Signature")
+	}
+	if c.Default {
+		patch.IsDefault = true
+	}
+	if patch.DisplayName == "" && patch.ReplyToAddress == "" && patch.Signature == "" && !c.ClearSignature && !c.Default {
+		return fmt.Errorf("nothing to update (use --display-name, --reply-to, --signature, --signature-file, --clear-signature or --default)")
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	out.writeVerbose("Updating send-as address %s...", c.Email)
+	updated, err := svc.Users.Settings.SendAs.Patch("me", c.Email, patch).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update send-as address: %w", err)
+	}
+	if out.json {
+		return out.writeJSON(toSendAsOutput(updated))
+	}
+	out.writeMessage(fmt.Sprintf("Updated send-as address %s", updated.SendAsEmail))
+	return nil
+}
+
+func toImapOutput(s *gmail.ImapSettings) imapOutput {
+	return imapOutput{
+		Enabled:         s.Enabled,
+		AutoExpunge:     s.AutoExpunge,
+		ExpungeBehavior: flagValueFor(expungeBehaviors, s.ExpungeBehavior),
+		MaxFolderSize:   s.MaxFolderSize,
+	}
+}
+
+func writeImap(s *gmail.ImapSettings, out *outputWriter) error {
+	imap := toImapOutput(s)
+	if out.json {
+		return out.writeJSON(imap)
+	}
+	headers := []string{"FIELD", "VALUE"}
+	rows := [][]string{
+		{"Enabled", fmt.Sprintf("%t", imap.Enabled)},
+		{"AutoExpunge", fmt.Sprintf("%t", imap.AutoExpunge)},
+		{"ExpungeBehavior", imap.ExpungeBehavior},
+		{"MaxFolderSize", fmt.Sprintf("%d", imap.MaxFolderSize)},
+	}
+	return out.writeTable(headers, rows)
+}
+
+// runSettingsImapGet shows the IMAP settings.
+func runSettingsImapGet(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) error {
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	s, err := svc.Users.Settings.GetImap("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get IMAP settings: %w", err)
+	}
+	return writeImap(s, out)
+}
+
+// runSettingsImapSet updates the IMAP settings. Unset flags leave the
+// existing values alone.
+func runSettingsImapSet(ctx context.Context, conn *gwcli.CmdG, c settingsImapSetCmd, out *outputWriter) error {
+	if c.Enable && c.Disable {
+		return fmt.Errorf("--enable and --disable cannot be used together")
+	}
+	if c.AutoExpunge != "" && c.AutoExpunge != "on" && c.AutoExpunge != "off" {
+		return fmt.Errorf("invalid --auto-expunge %q (want on or off)", c.AutoExpunge)
+	}
+	var behavior string
+	if c.ExpungeBehavior != "" {
+		var err error
+		if behavior, err = lookupFlagValue("expunge-behavior", c.ExpungeBehavior, expungeBehaviors); err != nil {
+			return err
+		}
+	}
+	switch c.MaxFolderSize {
+	case -1, 0, 1000, 2000, 5000, 10000:
+	default:
+		return fmt.Errorf("invalid --max-folder-size %d (want 0, 1000, 2000, 5000 or 10000)", c.MaxFolderSize)
+	}
+	if !c.Enable && !c.Disable && c.AutoExpunge == "" && behavior == "" && c.MaxFolderSize == -1 {
+		return fmt.Errorf("nothing to update (use --enable, --disable, --auto-expunge, --expunge-behavior or --max-folder-size)")
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	s, err := svc.Users.Settings.GetImap("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get IMAP settings: %w", err)
+	}
+	if c.Enable || c.Disable {
+		s.Enabled = c.Enable
+	}
+	if c.AutoExpunge != "" {
+		s.AutoExpunge = c.AutoExpunge == "on"
+	}
+	if behavior != "" {
+		s.ExpungeBehavior = behavior
+	}
+	if c.MaxFolderSize != -1 {
+		s.MaxFolderSize = c.MaxFolderSize
+	}
+	s.ForceSendFields = []string{"Enabled", "AutoExpunge", "MaxFolderSize"}
+
+	out.writeVerbose("Updating IMAP settings...")
+	updated, err := svc.Users.Settings.UpdateImap("me", s).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update IMAP settings: %w", err)
+	}
+	if out.json {
+		return out.writeJSON(toImapOutput(updated))
+	}
+	out.writeMessage("Updated IMAP settings")
+	return nil
+}
+
+func toPopOutput(s *gmail.PopSettings) popOutput {
+	return popOutput{
+		Access:      flagValueFor(popAccessWindows, s.AccessWindow),
+		Disposition: flagValueFor(popDispositions, s.Disposition),
+	}
+}
+
+// runSettingsPopGet shows the POP settings.
+func runSettingsPopGet(ctx context.Context, conn *gwcli.CmdG, out *outputWriter) error {
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	s, err := svc.Users.Settings.GetPop("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get POP settings: %w", err)
+	}
+	po := toPopOutput(s)
+	if out.json {
+		return out.writeJSON(po)
+	}
+	return out.writeTable([]string{"FIELD", "VALUE"}, [][]string{
+		{"Access", po.Access},
+		{"Disposition", po.Disposition},
+	})
+}
+
+// runSettingsPopSet updates the POP settings. Unset flags leave the
+// existing values alone.
+func runSettingsPopSet(ctx context.Context, conn *gwcli.CmdG, c settingsPopSetCmd, out *outputWriter) error {
+	if c.Access == "" && c.Disposition == "" {
+		return fmt.Errorf("nothing to update (use --access or --disposition)")
+	}
+	var access, disposition string
+	var err error
+	if c.Access != "" {
+		if access, err = lookupFlagValue("access", c.Access, popAccessWindows); err != nil {
+			return err
+		}
+	}
+	if c.Disposition != "" {
+		if disposition, err = lookupFlagValue("disposition", c.Disposition, popDispositions); err != nil {
+			return err
+		}
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	s, err := svc.Users.Settings.GetPop("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get POP settings: %w", err)
+	}
+	if access != "" {
+		s.AccessWindow = access
+	}
+	if disposition != "" {
+		s.Disposition = disposition
+	}
+
+	out.writeVerbose("Updating POP settings...")
+	updated, err := svc.Users.Settings.UpdatePop("me", s).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update POP settings: %w", err)
+	}
+	if out.json {
+		return out.writeJSON(toPopOutput(updated))
+	}
+	out.writeMessage("Updated POP settings")
+	return nil
+}
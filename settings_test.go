@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+)
+
+// newFakeSettingsConn answers GETs with current and records the body of
+// the last PUT or PATCH, echoing it back as the response.
+func newFakeSettingsConn(t *testing.T, current string, sent *map[string]interface{}) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if req.Method == http.MethodGet {
+			return current
+		}
+		b, _ := io.ReadAll(req.Body)
+		*sent = map[string]interface{}{}
+		if err := json.Unmarshal(b, sent); err != nil {
+			t.Fatalf("decode %s body: %v", req.Method, err)
+		}
+		return string(b)
+	})
+}
+
+func TestRunSettingsVacationSet(t *testing.T) {
+	var sent map[string]interface{}
+	conn := newFakeSettingsConn(t, `{}`, &sent)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	err := runSettingsVacationSet(context.Background(), conn, settingsVacationSetCmd{
+		Subject:    "Away",
+		Message:    "<p>Back soon</p>",
+		HTML:       true,
+		Start:      "2026-07-01",
+		End:        "2026-07-14",
+		DomainOnly: true,
+	}, out)
+	if err != nil {
+		t.Fatalf("runSettingsVacationSet() error = %v", err)
+	}
+
+	if sent["enableAutoReply"] != true || sent["responseBodyHtml"] != "<p>Back soon</p>" || sent["restrictToContacts"] != false {
+		t.Errorf("unexpected request: %v", sent)
+	}
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.Local).UnixMilli()
+	end := time.Date(2026, 7, 15, 0, 0, 0, 0, time.Local).UnixMilli()
+	// int64 fields are sent as JSON strings by the API client.
+	if sent["startTime"] != fmt.Sprint(start) || sent["endTime"] != fmt.Sprint(end) {
+		t.Errorf("startTime/endTime = %v/%v, want %d/%d", sent["startTime"], sent["endTime"], start, end)
+	}
+
+	var got vacationOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if !got.Enabled || !got.HTML || !got.DomainOnly || got.Subject != "Away" {
+		t.Errorf("output = %+v", got)
+	}
+}
+
+func TestRunSettingsVacationSet_Validation(t *testing.T) {
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	tests := []struct {
+		name string
+		cmd  settingsVacationSetCmd
+		want string
+	}{
+		{"no message", settingsVacationSetCmd{Subject: "Away"}, "message is required"},
+		{"bad date", settingsVacationSetCmd{Message: "x", Start: "July"}, "--start"},
+		{"end before start", settingsVacationSetCmd{Message: "x", Start: "2026-07-10", End: "2026-07-01"}, "--end must be after --start"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runSettingsVacationSet(context.Background(), nil, tt.cmd, out)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSettingsVacationOff_KeepsMessage(t *testing.T) {
+	var sent map[string]interface{}
+	conn := newFakeSettingsConn(t, `{"enableAutoReply":true,"responseBodyPlainText":"Back soon"}`, &sent)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runSettingsVacationOff(context.Background(), conn, out); err != nil {
+		t.Fatalf("runSettingsVacationOff() error = %v", err)
+	}
+	if sent["enableAutoReply"] != false || sent["responseBodyPlainText"] != "Back soon" {
+		t.Errorf("unexpected request: %v", sent)
+	}
+}
+
+func TestRunSettingsSendAsUpdate_ClearSignature(t *testing.T) {
+	var sent map[string]interface{}
+	conn := newFakeSettingsConn(t, `{}`, &sent)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	err := runSettingsSendAsUpdate(context.Background(), conn, settingsSendAsUpdateCmd{
+		Email:          "me@example.com",
+		DisplayName:    "Me",
+		ClearSignature: true,
+	}, out)
+	if err != nil {
+		t.Fatalf("runSettingsSendAsUpdate() error = %v", err)
+	}
+	sig, ok := sent["signature"]
+	if !ok || sig != "" || sent["displayName"] != "Me" {
+		t.Errorf("unexpected request: %v", sent)
+	}
+	if _, ok := sent["replyToAddress"]; ok {
+		t.Errorf("unset fields should not be sent: %v", sent)
+	}
+}
+
+func TestRunSettingsImapSet_KeepsUnsetFields(t *testing.T) {
+	var sent map[string]interface{}
+	conn := newFakeSettingsConn(t, `{"enabled":true,"autoExpunge":true,"expungeBehavior":"archive","maxFolderSize":1000}`, &sent)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	err := runSettingsImapSet(context.Background(), conn, settingsImapSetCmd{
+		AutoExpunge:     "off",
+		ExpungeBehavior: "delete",
+		MaxFolderSize:   -1,
+	}, out)
+	if err != nil {
+		t.Fatalf("runSettingsImapSet() error = %v", err)
+	}
+	if sent["enabled"] != true || sent["autoExpunge"] != false || sent["expungeBehavior"] != "deleteForever" || sent["maxFolderSize"] != float64(1000) {
+		t.Errorf("unexpected request: %v", sent)
+	}
+	var got imapOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ExpungeBehavior != "delete" {
+		t.Errorf("expungeBehavior = %q, want the flag value %q", got.ExpungeBehavior, "delete")
+	}
+}
+
+func TestRunSettingsPopSet_InvalidValue(t *testing.T) {
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	err := runSettingsPopSet(context.Background(), nil, settingsPopSetCmd{Access: "some"}, out)
+	if err == nil || !strings.Contains(err.Error(), "all, disabled, from-now-on") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// threadListOutput is JSON output format for thread lists. Header fields are
+// taken from the first message in the thread.
+type threadListOutput struct {
+	ID           string   `json:"id"`
+	MessageCount int      `json:"messageCount"`
+	Labels       []string `json:"labels"`
+	Date         string   `json:"date"`
+	From         string   `json:"from"`
+	Subject      string   `json:"subject"`
+	Snippet      string   `json:"snippet"`
+}
+
+// threadListPage is JSON output format for thread list results, mirroring
+// messageListPage.
+type threadListPage struct {
+	Threads       []threadListOutput `json:"threads"`
+	NextPageToken string             `json:"nextPageToken,omitempty"`
+}
+
+// threadReadOutput is JSON output format for reading a whole thread.
+type threadReadOutput struct {
+	ID       string              `json:"id"`
+	Messages []messageReadOutput `json:"messages"`
+}
+
+// headerValue returns the first value of the named header in a payload.
+func headerValue(part *gmail.MessagePart, name string) string {
+	if part == nil {
+		return ""
+	}
+	for _, h := range part.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// threadLabels returns the union of label IDs across a thread's messages.
+func threadLabels(t *gmail.Thread) []string {
+	seen := map[string]bool{}
+	labels := []string{}
+	for _, m := range t.Messages {
+		for _, l := range m.LabelIds {
+			if !seen[l] {
+				seen[l] = true
+				labels = append(labels, l)
+			}
+		}
+	}
+	return labels
+}
+
+// loadThreadSummaries fetches thread metadata for ids concurrently,
+// preserving order. Threads that fail to load are reported in verbose mode
+// and returned with only their ID set.
+func loadThreadSummaries(ctx context.Context, svc *gmail.Service, ids []string, out *outputWriter) []threadListOutput {
+	const conc = 20
+	result := make([]threadListOutput, len(ids))
+	sem := make(chan struct{}, conc)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result[i] = threadListOutput{ID: id, Labels: []string{}}
+			t, err := svc.Users.Threads.Get("me", id).
+				Format("metadata").
+				MetadataHeaders("From", "Subject", "Date").
+				Context(ctx).
+				Do()
+			if err != nil {
+				out.writeVerbose("Failed to load thread %s: %v", id, err)
+				return
+			}
+			result[i].MessageCount = len(t.Messages)
+			result[i].Labels = threadLabels(t)
+			result[i].Snippet = t.Snippet
+			if len(t.Messages) > 0 {
+				first := t.Messages[0]
+				result[i].From = headerValue(first.Payload, "From")
+				result[i].Subject = headerValue(first.Payload, "Subject")
+				result[i].Date = headerValue(first.Payload, "Date")
+				if result[i].Snippet == "" {
+					result[i].Snippet = first.Snippet
+				}
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	return result
+}
+
+// runThreadsList lists threads in a label and/or matching a query, following
+// next-page tokens until limit threads have been collected.
+func runThreadsList(ctx context.Context, conn *gwcli.CmdG, label, query string, limit int, pageToken string, out *outputWriter) error {
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	labelID := ""
+	if label != "" {
+		if err := conn.LoadLabels(ctx, out.verbose); err != nil {
+			return fmt.Errorf("failed to load labels: %w", err)
+		}
+		id, err := resolveLabelID(conn, label)
+		if err != nil {
+			return err
+		}
+		labelID = id
+	}
+
+	var ids []string
+	for {
+		want := int64(100)
+		if limit > 0 && int64(limit-len(ids)) < want {
+			want = int64(limit - len(ids))
+		}
+		call := svc.Users.Threads.List("me").MaxResults(want).Context(ctx)
+		if labelID != "" {
+			call = call.LabelIds(labelID)
+		}
+		if query != "" {
+			call = call.Q(query)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return fmt.Errorf("failed to list threads: %w", err)
+		}
+		for _, t := range resp.Threads {
+			ids = append(ids, t.Id)
+		}
+		pageToken = resp.NextPageToken
+		out.writeVerbose("Fetched %d threads so far", len(ids))
+		if pageToken == "" || (limit > 0 && len(ids) >= limit) {
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		if out.json {
+			return out.writeJSON(threadListPage{Threads: []threadListOutput{}})
+		}
+		out.writeMessage("No threads found")
+		return nil
+	}
+
+	threads := loadThreadSummaries(ctx, svc, ids, out)
+
+	if out.json {
+		return out.writeJSON(threadListPage{Threads: threads, NextPageToken: pageToken})
+	}
+
+	headers := []string{"ID", "MSGS", "FROM", "SUBJECT", "DATE"}
+	rows := make([][]string, len(threads))
+	for i, t := range threads {
+		rows[i] = []string{
+			t.ID,
+			fmt.Sprintf("%d", t.MessageCount),
+			truncateString(t.From, 30),
+			truncateString(t.Subject, 40),
+			t.Date,
+		}
+	}
+	if err := out.writeTable(headers, rows); err != nil {
+		return err
+	}
+	writeNextPageHint(pageToken)
+	return nil
+}
+
+// threadMessageBody picks the body for one message of a thread in the
+// requested format, using the same fallbacks as runMessagesRead.
+func threadMessageBody(m *gmail.Message, format OutputFormat) (string, string, error) {
+	htmlBody := extractHTMLFromPart(m.Payload)
+	plainText := extractPlainTextFromPart(m.Payload)
+
+	switch format {
+	case FormatHTML:
+		if htmlBody != "" {
+			return htmlBody, "", nil
+		}
+		if plainText != "" {
+			return fmt.Sprintf("<pre>%s</pre>", escapeHTML(plainText)), "HTML body not available, showing plain text", nil
+		}
+	case FormatPlainText:
+		if plainText != "" {
+			return plainText, "", nil
+		}
+		if htmlBody != "" {
+			return stripHTMLTags(htmlBody), "Plain text body not available, converted from HTML", nil
+		}
+		return m.Snippet, "Neither plain text nor HTML body available, showing snippet", nil
+	default:
+		if htmlBody != "" {
+			md, err := convertHTMLToMarkdown(htmlBody)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to convert HTML to markdown: %w", err)
+			}
+			return md, "", nil
+		}
+		if plainText != "" {
+			return plainText, "HTML body not available, showing plain text", nil
+		}
+	}
+	return "<!-- No body found in this message -->", "Neither HTML nor plain text body available", nil
+}
+
+// runThreadsRead prints every message of a thread in chronological order.
+func runThreadsRead(ctx context.Context, conn *gwcli.CmdG, threadID string, rawHTML, preferPlain bool, out *outputWriter) error {
+	if rawHTML && preferPlain {
+		return fmt.Errorf("--raw-html and --prefer-plain are mutually exclusive")
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	t, err := svc.Users.Threads.Get("me", threadID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get thread: %w", err)
+	}
+
+	messages := t.Messages
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].InternalDate < messages[j].InternalDate
+	})
+
+	if out.json {
+		output := threadReadOutput{ID: t.Id, Messages: make([]messageReadOutput, len(messages))}
+		for i, m := range messages {
+			mo := messageReadOutput{
+				ID:       m.Id,
+				ThreadID: m.ThreadId,
+				LabelIDs: m.LabelIds,
+				Snippet:  m.Snippet,
+				Headers:  make(map[string]string),
+			}
+			for _, h := range []string{"From", "To", "Cc", "Subject", "Date"} {
+				if v := headerValue(m.Payload, h); v != "" {
+					mo.Headers[h] = v
+				}
+			}
+			mo.Body = extractPlainTextFromPart(m.Payload)
+			mo.BodyHTML = extractHTMLFromPart(m.Payload)
+			if mo.BodyHTML != "" {
+				if md, err := convertHTMLToMarkdown(mo.BodyHTML); err == nil {
+					mo.BodyMarkdown = md
+				}
+			}
+			var attachments []attachmentInfo
+			extractAttachmentsFromPart(m.Payload, &attachments)
+			for j := range attachments {
+				attachments[j].Index = j
+			}
+			if len(attachments) > 0 {
+				mo.Attachments = attachments
+			}
+			if artifacts := detectDriveArtifacts(mo.BodyHTML, headerValue(m.Payload, meetArtifactHeader)); len(artifacts) > 0 {
+				mo.DriveArtifacts = artifacts
+			}
+			output.Messages[i] = mo
+		}
+		return out.writeJSON(output)
+	}
+
+	format := FormatMarkdown
+	if rawHTML {
+		format = FormatHTML
+	} else if preferPlain {
+		format = FormatPlainText
+	}
+
+	var sections []string
+	for _, m := range messages {
+		body, note, err := threadMessageBody(m, format)
+		if err != nil {
+			return err
+		}
+
+		frontmatter := EmailFrontmatter{
+			MessageID:      m.Id,
+			ThreadID:       m.ThreadId,
+			From:           headerValue(m.Payload, "From"),
+			To:             headerValue(m.Payload, "To"),
+			Cc:             headerValue(m.Payload, "Cc"),
+			Subject:        headerValue(m.Payload, "Subject"),
+			Date:           headerValue(m.Payload, "Date"),
+			Labels:         m.LabelIds,
+			Note:           note,
+			DriveArtifacts: detectDriveArtifacts(extractHTMLFromPart(m.Payload), headerValue(m.Payload, meetArtifactHeader)),
+		}
+
+		var infos []attachmentInfo
+		extractAttachmentsFromPart(m.Payload, &infos)
+		var attachmentsMeta []AttachmentMeta
+		for j, att := range infos {
+			attachmentsMeta = append(attachmentsMeta, AttachmentMeta{
+				Index:    j,
+				Filename: att.Filename,
+				MimeType: att.MimeType,
+				Size:     att.Size,
+			})
+		}
+
+		var formatted string
+		switch format {
+		case FormatHTML:
+			formatted = formatEmailAsHTML(frontmatter, body, attachmentsMeta)
+		case FormatPlainText:
+			formatted, err = formatEmailAsPlainText(frontmatter, body, attachmentsMeta)
+		default:
+			formatted, err = formatEmailAsMarkdown(frontmatter, body, attachmentsMeta)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to format message %s: %w", m.Id, err)
+		}
+		sections = append(sections, formatted)
+	}
+
+	separator := "\n"
+	if format == FormatHTML {
+		separator = "\n<hr class=\"email-thread-separator\">\n\n"
+	}
+	fmt.Fprint(out.writer, strings.Join(sections, separator))
+	return nil
+}
+
+// runThreadsModify applies label changes to every message of a thread in a
+// single call, and optionally trashes it.
+func runThreadsModify(ctx context.Context, conn *gwcli.CmdG, threadID string, addLabels, removeLabels []string, archive, markRead, markUnread, trash bool, out *outputWriter) error {
+	if markRead && markUnread {
+		return fmt.Errorf("--mark-read and --mark-unread are mutually exclusive")
+	}
+
+	svc := conn.GmailService()
+	if svc == nil {
+		return fmt.Errorf("gmail service not initialized")
+	}
+
+	if err := conn.LoadLabels(ctx, out.verbose); err != nil {
+		return fmt.Errorf("failed to load labels: %w", err)
+	}
+
+	addIDs := []string{}
+	removeIDs := []string{}
+	for _, name := range addLabels {
+		id, err := resolveLabelID(conn, name)
+		if err != nil {
+			return err
+		}
+		addIDs = append(addIDs, id)
+	}
+	for _, name := range removeLabels {
+		id, err := resolveLabelID(conn, name)
+		if err != nil {
+			return err
+		}
+		removeIDs = append(removeIDs, id)
+	}
+	if archive {
+		removeIDs = append(removeIDs, gwcli.Inbox)
+	}
+	if markRead {
+		removeIDs = append(removeIDs, gwcli.Unread)
+	}
+	if markUnread {
+		addIDs = append(addIDs, gwcli.Unread)
+	}
+	addIDs = dedupe(addIDs)
+	removeIDs = dedupe(removeIDs)
+
+	if len(addIDs) == 0 && len(removeIDs) == 0 && !trash {
+		return fmt.Errorf("at least one change is required (--add-label, --remove-label, --archive, --mark-read, --mark-unread, --trash)")
+	}
+
+	if len(addIDs) > 0 || len(removeIDs) > 0 {
+		out.writeVerbose("Modifying thread %s: add=%v remove=%v", threadID, addIDs, removeIDs)
+		if _, err := svc.Users.Threads.Modify("me", threadID, &gmail.ModifyThreadRequest{
+			AddLabelIds:    addIDs,
+			RemoveLabelIds: removeIDs,
+		}).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to modify thread: %w", err)
+		}
+	}
+
+	if trash {
+		out.writeVerbose("Trashing thread %s", threadID)
+		if _, err := svc.Users.Threads.Trash("me", threadID).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to trash thread: %w", err)
+		}
+	}
+
+	if out.json {
+		return out.writeJSON(map[string]interface{}{
+			"threadId":       threadID,
+			"addLabelIds":    addIDs,
+			"removeLabelIds": removeIDs,
+			"trashed":        trash,
+		})
+	}
+
+	out.writeMessage(fmt.Sprintf("Thread %s updated", threadID))
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// threadsTestLabels are the labels the threads tests resolve names with.
+const threadsTestLabels = `{"labels":[{"id":"INBOX","name":"INBOX","type":"system"},{"id":"UNREAD","name":"UNREAD","type":"system"},{"id":"Label_1","name":"Work","type":"user"}]}`
+
+func TestRunThreadsList_FollowsPagesAndLoadsMetadata(t *testing.T) {
+	var listCalls int
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "labels":
+			return threadsTestLabels
+		case path == "threads":
+			listCalls++
+			if got := req.URL.Query().Get("labelIds"); got != "Label_1" {
+				t.Errorf("labelIds = %q, want Label_1", got)
+			}
+			if req.URL.Query().Get("pageToken") == "" {
+				return `{"threads":[{"id":"T1"},{"id":"T2"}],"nextPageToken":"p2"}`
+			}
+			return `{"threads":[{"id":"T3"}],"nextPageToken":"p3"}`
+		case strings.HasPrefix(path, "threads/"):
+			id := strings.TrimPrefix(path, "threads/")
+			return fmt.Sprintf(`{"id":%q,"snippet":"snip %s","messages":[
+				{"id":"%s-a","labelIds":["INBOX","UNREAD"],"payload":{"headers":[{"name":"From","value":"a@example.com"},{"name":"Subject","value":"Hello %s"}]}},
+				{"id":"%s-b","labelIds":["INBOX","SENT"]}]}`, id, id, id, id, id)
+		}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runThreadsList(context.Background(), conn, "Work", "", 3, "", out); err != nil {
+		t.Fatalf("runThreadsList() error = %v", err)
+	}
+
+	var got threadListPage
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if listCalls != 2 {
+		t.Errorf("expected 2 list calls, got %d", listCalls)
+	}
+	if len(got.Threads) != 3 || got.Threads[2].ID != "T3" {
+		t.Fatalf("unexpected threads: %+v", got.Threads)
+	}
+	if got.NextPageToken != "p3" {
+		t.Errorf("nextPageToken = %q, want p3", got.NextPageToken)
+	}
+	first := got.Threads[0]
+	if first.MessageCount != 2 || first.Subject != "Hello T1" || first.From != "a@example.com" {
+		t.Errorf("unexpected summary: %+v", first)
+	}
+	if strings.Join(first.Labels, ",") != "INBOX,UNREAD,SENT" {
+		t.Errorf("labels = %v, want union INBOX,UNREAD,SENT", first.Labels)
+	}
+}
+
+func TestRunThreadsRead_OrdersMessages(t *testing.T) {
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "threads/T1" {
+			return nil
+		}
+		if req.URL.Query().Get("format") != "full" {
+			t.Errorf("format = %q, want full", req.URL.Query().Get("format"))
+		}
+		// Bodies are base64url-encoded "second" and "first".
+		return `{"id":"T1","messages":[
+			{"id":"M2","threadId":"T1","internalDate":"2000","payload":{"mimeType":"text/plain","headers":[{"name":"Subject","value":"Re: Hi"}],"body":{"data":"c2Vjb25k"}}},
+			{"id":"M1","threadId":"T1","internalDate":"1000","payload":{"mimeType":"text/plain","headers":[{"name":"Subject","value":"Hi"}],"body":{"data":"Zmlyc3Q="}}}]}`
+	})
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runThreadsRead(context.Background(), conn, "T1", false, false, out); err != nil {
+		t.Fatalf("runThreadsRead() error = %v", err)
+	}
+
+	var got threadReadOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got.Messages) != 2 || got.Messages[0].ID != "M1" || got.Messages[1].ID != "M2" {
+		t.Fatalf("messages not in chronological order: %+v", got.Messages)
+	}
+	if got.Messages[0].Body != "first" || got.Messages[0].Headers["Subject"] != "Hi" {
+		t.Errorf("unexpected first message: %+v", got.Messages[0])
+	}
+}
+
+func TestRunThreadsModify_SingleCall(t *testing.T) {
+	var modify *gmail.ModifyThreadRequest
+	var trashed bool
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch path {
+		case "labels":
+			return threadsTestLabels
+		case "threads/T1/modify":
+			if modify != nil {
+				t.Errorf("modify called more than once")
+			}
+			modify = &gmail.ModifyThreadRequest{}
+			decodeRequest(t, req, modify)
+			return `{"id":"T1"}`
+		case "threads/T1/trash":
+			trashed = true
+			return `{"id":"T1"}`
+		}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	err := runThreadsModify(context.Background(), conn, "T1", []string{"Work"}, nil, true, true, false, true, out)
+	if err != nil {
+		t.Fatalf("runThreadsModify() error = %v", err)
+	}
+	if modify == nil {
+		t.Fatal("expected a modify call")
+	}
+	if strings.Join(modify.AddLabelIds, ",") != "Label_1" {
+		t.Errorf("addLabelIds = %v, want [Label_1]", modify.AddLabelIds)
+	}
+	if strings.Join(modify.RemoveLabelIds, ",") != "INBOX,UNREAD" {
+		t.Errorf("removeLabelIds = %v, want [INBOX UNREAD]", modify.RemoveLabelIds)
+	}
+	if !trashed {
+		t.Error("expected thread to be trashed")
+	}
+
+	if err := runThreadsModify(context.Background(), conn, "T1", nil, nil, false, false, false, false, out); err == nil {
+		t.Error("expected error when no change is requested")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// messageAction is a reversible bulk change offered as its own messages
+// subcommand, such as trash and its undo, untrash.
+type messageAction struct {
+	name   string // subcommand, reported by --dry-run
+	verb   string // text for --dry-run, with %s for the message count
+	key    string // JSON key counting the changed messages
+	single string // text when one message changed
+	// scope limits --query to where the messages to act on live. Searches
+	// skip the trash and spam unless the query names them.
+	scope string
+	op    func(conn *gwcli.CmdG) batchOp
+}
+
+var (
+	trashAction = messageAction{
+		name:   "trash",
+		verb:   "move %s messages to the trash",
+		key:    "trashed",
+		single: "Message moved to trash",
+		op: func(conn *gwcli.CmdG) batchOp {
+			return conn.BatchTrash
+		},
+	}
+	untrashAction = messageAction{
+		name:   "untrash",
+		verb:   "restore %s messages from the trash",
+		key:    "restored",
+		single: "Message restored from trash",
+		scope:  "in:trash",
+		op: func(conn *gwcli.CmdG) batchOp {
+			return func(ctx context.Context, ids []string) error {
+				return conn.BatchUnlabel(ctx, ids, gwcli.Trash)
+			}
+		},
+	}
+	spamAction = messageAction{
+		name:   "spam",
+		verb:   "report %s messages as spam",
+		key:    "reported",
+		single: "Message reported as spam",
+		op: func(conn *gwcli.CmdG) batchOp {
+			return func(ctx context.Context, ids []string) error {
+				return conn.BatchModify(ctx, ids, []string{gwcli.Spam}, []string{gwcli.Inbox})
+			}
+		},
+	}
+	notSpamAction = messageAction{
+		name:   "not-spam",
+		verb:   "move %s messages from spam to the inbox",
+		key:    "restored",
+		single: "Message moved to inbox",
+		scope:  "in:spam",
+		op: func(conn *gwcli.CmdG) batchOp {
+			return func(ctx context.Context, ids []string) error {
+				return conn.BatchModify(ctx, ids, []string{gwcli.Inbox}, []string{gwcli.Spam})
+			}
+		},
+	}
+	archiveAction = messageAction{
+		name:   "archive",
+		verb:   "archive %s messages",
+		key:    "archived",
+		single: "Message archived",
+		op: func(conn *gwcli.CmdG) batchOp {
+			return conn.BatchArchive
+		},
+	}
+)
+
+// runMessagesAction applies a messageAction to one message, the IDs on
+// stdin or the messages matching a query.
+func runMessagesAction(ctx context.Context, conn *gwcli.CmdG, a messageAction, messageID string, sel bulkFlags, verbose bool, out *outputWriter) error {
+	if a.scope != "" && sel.Query != "" {
+		sel.Query = a.scope + " (" + sel.Query + ")"
+	}
+	ids, err := sel.messageIDs(ctx, conn, messageID, "message ID", out)
+	if err != nil {
+		return err
+	}
+	if sel.DryRun {
+		return sel.preview(ctx, conn, ids, a.name, fmt.Sprintf(a.verb, sel.matched(ids)), out)
+	}
+
+	bp := newBatchProcessor(len(ids), verbose)
+	bp.run(ctx, ids, a.op(conn))
+	return bp.finish(out, a.key, a.single)
+}
+
+// gmailAge matches the ages Gmail's older_than: operator accepts.
+var gmailAge = regexp.MustCompile(`^[0-9]+[dmy]$`)
+
+// runTrashEmpty permanently deletes the messages in the trash, or with
+// olderThan only those older than an age such as "30d", "6m" or "1y".
+func runTrashEmpty(ctx context.Context, conn *gwcli.CmdG, olderThan string, force, dryRun bool, verbose bool, out *outputWriter) error {
+	if !force && !dryRun {
+		return fmt.Errorf("--force is required to permanently delete messages")
+	}
+	sel := bulkFlags{Query: "in:trash", DryRun: dryRun}
+	if olderThan != "" {
+		if !gmailAge.MatchString(olderThan) {
+			return fmt.Errorf("invalid --older-than %q (want a number of days, months or years, such as 30d, 6m or 1y)", olderThan)
+		}
+		sel.Query += " older_than:" + olderThan
+	}
+
+	ids, err := sel.messageIDs(ctx, conn, "", "message ID", out)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return sel.preview(ctx, conn, ids, "empty", fmt.Sprintf("permanently delete %s messages", sel.matched(ids)), out)
+	}
+
+	bp := newBatchProcessor(len(ids), verbose)
+	bp.run(ctx, ids, conn.BatchDelete)
+	err = bp.finish(out, "deleted", "Message permanently deleted")
+	if err != nil && len(bp.failed) > 0 && isPurgeDenied(bp.failed[0].err) {
+		return fmt.Errorf("%s (underlying: %w)", purgeScopeHelp, err)
+	}
+	return err
+}
+
+// purgeScopeHelp is the actionable message shown when permanent deletion is
+// denied because the credentials lack the https://mail.google.com/ scope.
+const purgeScopeHelp = "Permanently deleting messages is not authorized. " +
+	"For an OAuth account, re-run `gwcli configure --allow-purge` to grant " +
+	"the https://mail.google.com/ scope. For a service account, authorize " +
+	"https://mail.google.com/ via domain-wide delegation. Gmail also " +
+	"deletes messages that have been in the trash for 30 days by itself."
+
+// isPurgeDenied reports whether a BatchDelete error is a missing scope:
+// the connection has no purge client, Gmail rejects the token's scopes, or
+// domain-wide delegation refuses to issue a token for the scope.
+func isPurgeDenied(err error) bool {
+	if errors.Is(err, gwcli.ErrPurgeNotAllowed) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		for _, e := range apiErr.Errors {
+			if e.Reason == "insufficientPermissions" {
+				return true
+			}
+		}
+		for _, d := range apiErr.Details {
+			if info, ok := d.(map[string]interface{}); ok && info["reason"] == "ACCESS_TOKEN_SCOPE_INSUFFICIENT" {
+				return true
+			}
+		}
+	}
+	var tokErr *oauth2.RetrieveError
+	return errors.As(err, &tokErr) && tokErr.ErrorCode == "unauthorized_client"
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	"golang.org/x/oauth2"
+	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeTrashAPI serves message searches and records batchModify and
+// batchDelete calls.
+type fakeTrashAPI struct {
+	t        *testing.T
+	matches  map[string][]string // search query -> message IDs
+	modified []gmail.BatchModifyMessagesRequest
+	deleted  []string
+}
+
+func newFakeTrashAPI(t *testing.T) *fakeTrashAPI {
+	return &fakeTrashAPI{t: t, matches: map[string][]string{}}
+}
+
+func (f *fakeTrashAPI) conn() *gwcli.CmdG {
+	return newFakeGmail(f.t, func(req *http.Request, path string) interface{} {
+		switch path {
+		case "messages":
+			return messageList(f.matches[req.URL.Query().Get("q")]...)
+		case "messages/batchModify":
+			var r gmail.BatchModifyMessagesRequest
+			decodeRequest(f.t, req, &r)
+			f.modified = append(f.modified, r)
+			return "{}"
+		case "messages/batchDelete":
+			var r gmail.BatchDeleteMessagesRequest
+			decodeRequest(f.t, req, &r)
+			f.deleted = append(f.deleted, r.Ids...)
+			return "{}"
+		}
+		return nil
+	})
+}
+
+func TestRunMessagesAction_SpamAndBack(t *testing.T) {
+	api := newFakeTrashAPI(t)
+	conn := api.conn()
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runMessagesAction(context.Background(), conn, spamAction, "m1", bulkFlags{}, false, out); err != nil {
+		t.Fatalf("spam error = %v", err)
+	}
+	if err := runMessagesAction(context.Background(), conn, notSpamAction, "m1", bulkFlags{}, false, out); err != nil {
+		t.Fatalf("not-spam error = %v", err)
+	}
+	if len(api.modified) != 2 {
+		t.Fatalf("expected 2 batchModify calls, got %d", len(api.modified))
+	}
+	spam, notSpam := api.modified[0], api.modified[1]
+	if spam.AddLabelIds[0] != "SPAM" || spam.RemoveLabelIds[0] != "INBOX" {
+		t.Errorf("spam = %+v", spam)
+	}
+	if notSpam.AddLabelIds[0] != "INBOX" || notSpam.RemoveLabelIds[0] != "SPAM" {
+		t.Errorf("not-spam = %+v", notSpam)
+	}
+}
+
+func TestRunMessagesAction_ArchiveQuery(t *testing.T) {
+	api := newFakeTrashAPI(t)
+	api.matches["in:inbox is:read"] = []string{"m1", "m2"}
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	sel := bulkFlags{Query: "in:inbox is:read", Max: 1000}
+	if err := runMessagesAction(context.Background(), api.conn(), archiveAction, "", sel, false, out); err != nil {
+		t.Fatalf("runMessagesAction() error = %v", err)
+	}
+	if len(api.modified) != 1 || strings.Join(api.modified[0].Ids, ",") != "m1,m2" || api.modified[0].RemoveLabelIds[0] != "INBOX" {
+		t.Errorf("batchModify = %+v", api.modified)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if got["archived"] != float64(2) {
+		t.Errorf("output = %v", got)
+	}
+}
+
+func TestRunMessagesAction_UntrashQuery(t *testing.T) {
+	api := newFakeTrashAPI(t)
+	api.matches["in:trash (from:colleague@example.com)"] = []string{"m1"}
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	sel := bulkFlags{Query: "from:colleague@example.com", Max: 1000}
+	if err := runMessagesAction(context.Background(), api.conn(), untrashAction, "", sel, false, out); err != nil {
+		t.Fatalf("runMessagesAction() error = %v", err)
+	}
+	if len(api.modified) != 1 || api.modified[0].Ids[0] != "m1" || api.modified[0].RemoveLabelIds[0] != "TRASH" {
+		t.Errorf("batchModify = %+v", api.modified)
+	}
+}
+
+func TestRunTrashEmpty_OlderThan(t *testing.T) {
+	api := newFakeTrashAPI(t)
+	api.matches["in:trash older_than:30d"] = []string{"m1", "m2"}
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runTrashEmpty(context.Background(), api.conn(), "30d", true, false, false, out); err != nil {
+		t.Fatalf("runTrashEmpty() error = %v", err)
+	}
+	if strings.Join(api.deleted, ",") != "m1,m2" {
+		t.Errorf("deleted = %v, want [m1 m2]", api.deleted)
+	}
+}
+
+func TestRunTrashEmpty_Validation(t *testing.T) {
+	api := newFakeTrashAPI(t)
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+
+	if err := runTrashEmpty(context.Background(), api.conn(), "30d", false, false, false, out); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("without --force: error = %v", err)
+	}
+	if err := runTrashEmpty(context.Background(), api.conn(), "2w", true, false, false, out); err == nil || !strings.Contains(err.Error(), "invalid --older-than") {
+		t.Errorf("weeks: error = %v", err)
+	}
+}
+
+func TestIsPurgeDenied(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no purge client", fmt.Errorf("deleting: %w", gwcli.ErrPurgeNotAllowed), true},
+		{"insufficient scope", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "insufficientPermissions"}}}, true},
+		{"scope details", &googleapi.Error{Code: 403, Details: []interface{}{map[string]interface{}{"reason": "ACCESS_TOKEN_SCOPE_INSUFFICIENT"}}}, true},
+		{"delegation refused", &oauth2.RetrieveError{ErrorCode: "unauthorized_client"}, true},
+		{"rate limit", &googleapi.Error{Code: 403, Message: "Error 403", Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, false},
+		{"other 403 text", fmt.Errorf("googleapi: Error 403: Forbidden"), false},
+	}
+	for _, tt := range tests {
+		if got := isPurgeDenied(tt.err); got != tt.want {
+			t.Errorf("%s: isPurgeDenied() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// messagesUnsubscribeCmd holds the flags for `gwcli messages unsubscribe`.
+type messagesUnsubscribeCmd struct {
+	MessageID string    `arg:"" optional:"" help:"Message ID"`
+	Filter    string    `help:"Also create a filter for future mail from each sender" enum:"none,archive,trash" default:"none"`
+	Bulk      bulkFlags `embed:""`
+}
+
+// newUnsubscribeClient returns the HTTP client for one-click unsubscribes.
+// RFC 8058 senders must not redirect, so redirects are not followed.
+func newUnsubscribeClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// unsubscribeResult is the outcome of unsubscribing from one sender.
+type unsubscribeResult struct {
+	Sender      string `json:"sender"`
+	MessageID   string `json:"messageId"`
+	Method      string `json:"method"` // one-click, mailto, link or none
+	Target      string `json:"target,omitempty"`
+	Status      string `json:"status"` // unsubscribed, failed, manual, unavailable or planned
+	Error       string `json:"error,omitempty"`
+	FilterID    string `json:"filterId,omitempty"`
+	FilterError string `json:"filterError,omitempty"`
+}
+
+// unsubscribeMethod picks how to unsubscribe from the List-Unsubscribe and
+// List-Unsubscribe-Post headers: an RFC 8058 one-click POST if offered,
+// else a mailto:, else an https link the user has to open themselves.
+func unsubscribeMethod(listUnsubscribe, listUnsubscribePost string) (method, target string) {
+	var web, mailto string
+	for _, f := range strings.Split(listUnsubscribe, ",") {
+		f = strings.TrimSpace(f)
+		if !strings.HasPrefix(f, "<") || !strings.HasSuffix(f, ">") {
+			continue
+		}
+		uri := strings.TrimSpace(f[1 : len(f)-1])
+		switch lower := strings.ToLower(uri); {
+		case strings.HasPrefix(lower, "https:") && web == "":
+			web = uri
+		case strings.HasPrefix(lower, "mailto:") && mailto == "":
+			mailto = uri
+		}
+	}
+	oneClick := strings.EqualFold(strings.TrimSpace(listUnsubscribePost), "List-Unsubscribe=One-Click")
+	switch {
+	case web != "" && oneClick:
+		return "one-click", web
+	case mailto != "":
+		return "mailto", mailto
+	case web != "":
+		return "link", web
+	}
+	return "none", ""
+}
+
+// postOneClick sends an RFC 8058 one-click unsubscribe request.
+func postOneClick(ctx context.Context, client *http.Client, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unsubscribe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unsubscribe request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendUnsubscribeMail sends the message a mailto: unsubscribe URI asks for.
+// It is read by the list's software, not a person, so it goes out exactly
+// as the URI specifies: from the default address and without a signature.
+func sendUnsubscribeMail(ctx context.Context, conn *gwcli.CmdG, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe address: %w", err)
+	}
+	to, err := url.PathUnescape(u.Opaque)
+	if err != nil || to == "" {
+		return fmt.Errorf("invalid unsubscribe address %q", target)
+	}
+	subject, body := u.Query().Get("subject"), u.Query().Get("body")
+	if subject == "" {
+		subject = "unsubscribe"
+	}
+	if body == "" {
+		body = "unsubscribe"
+	}
+	headers, parts, err := buildOutgoingMessage(strings.Split(to, ","), nil, nil, subject, body, nil, nil, composeOptions{})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.SendParts(ctx, gwcli.NewThread, "mixed", headers, parts); err != nil {
+		return fmt.Errorf("failed to send unsubscribe mail: %w", err)
+	}
+	return nil
+}
+
+// senderAddress returns the email address of a From header, lowercased.
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(from))
+}
+
+// unsubscribeFilter is a filter that archives or trashes mail from sender.
+func unsubscribeFilter(sender, action string) *gmail.Filter {
+	f := &gmail.Filter{
+		Criteria: &gmail.FilterCriteria{From: sender},
+		Action:   &gmail.FilterAction{},
+	}
+	if action == "trash" {
+		f.Action.AddLabelIds = []string{gwcli.Trash}
+	} else {
+		f.Action.RemoveLabelIds = []string{gwcli.Inbox}
+	}
+	return f
+}
+
+// runMessagesUnsubscribe unsubscribes from the senders of the given
+// messages, once per sender. client sends the one-click POSTs.
+func runMessagesUnsubscribe(ctx context.Context, conn *gwcli.CmdG, client *http.Client, c messagesUnsubscribeCmd, out *outputWriter) error {
+	ids, err := c.Bulk.messageIDs(ctx, conn, c.MessageID, "message ID", out)
+	if err != nil {
+		return err
+	}
+	ids, more := c.Bulk.overMax(ids)
+	if more {
+		fmt.Fprintf(os.Stderr, "Warning: query matches more than --max %d messages; planning for the newest %d only\n", c.Bulk.Max, c.Bulk.Max)
+	}
+
+	results := []unsubscribeResult{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		msg := gwcli.NewMessage(conn, id)
+		from, err := msg.GetHeader(ctx, "From")
+		if err != nil {
+			results = append(results, unsubscribeResult{MessageID: id, Method: "none", Status: "failed", Error: err.Error()})
+			continue
+		}
+		sender := senderAddress(from)
+		if seen[sender] {
+			continue
+		}
+		seen[sender] = true
+
+		// Missing headers read as empty: the sender offers no method.
+		listUnsubscribe, _ := msg.GetHeader(ctx, "List-Unsubscribe")
+		listUnsubscribePost, _ := msg.GetHeader(ctx, "List-Unsubscribe-Post")
+		r := unsubscribeResult{Sender: sender, MessageID: id}
+		r.Method, r.Target = unsubscribeMethod(listUnsubscribe, listUnsubscribePost)
+		results = append(results, r)
+	}
+
+	for i := range results {
+		r := &results[i]
+		if r.Status == "failed" {
+			continue
+		}
+		if c.Bulk.DryRun {
+			r.Status = "planned"
+			continue
+		}
+
+		out.writeVerbose("Unsubscribing from %s by %s...", r.Sender, r.Method)
+		var err error
+		switch r.Method {
+		case "one-click":
+			err = postOneClick(ctx, client, r.Target)
+		case "mailto":
+			err = sendUnsubscribeMail(ctx, conn, r.Target)
+		}
+		switch {
+		case err != nil:
+			r.Status, r.Error = "failed", err.Error()
+		case r.Method == "link":
+			r.Status = "manual"
+		case r.Method == "none":
+			r.Status = "unavailable"
+		default:
+			r.Status = "unsubscribed"
+		}
+
+		if c.Filter != "" && c.Filter != "none" {
+			f, err := createFilter(ctx, conn.GmailService(), unsubscribeFilter(r.Sender, c.Filter))
+			if err != nil {
+				r.FilterError = err.Error()
+				continue
+			}
+			r.FilterID = f.Id
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == "failed" || r.FilterError != "" {
+			failed++
+		}
+	}
+
+	if err := writeUnsubscribeResults(results, out); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d senders failed", failed, len(results))
+	}
+	return nil
+}
+
+// writeUnsubscribeResults prints one row per sender.
+func writeUnsubscribeResults(results []unsubscribeResult, out *outputWriter) error {
+	if out.json {
+		return out.writeJSON(results)
+	}
+	if len(results) == 0 {
+		out.writeMessage("No messages matched")
+		return nil
+	}
+	headers := []string{"SENDER", "METHOD", "STATUS", "DETAIL"}
+	rows := make([][]string, len(results))
+	for i, r := range results {
+		detail := r.Error
+		switch {
+		case detail == "" && r.Status == "manual":
+			detail = "open " + r.Target
+		case detail == "" && r.FilterError != "":
+			detail = "filter: " + r.FilterError
+		case detail == "" && r.FilterID != "":
+			detail = "filter " + r.FilterID
+		}
+		rows[i] = []string{r.Sender, r.Method, r.Status, detail}
+	}
+	return out.writeTable(headers, rows)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+func TestUnsubscribeMethod(t *testing.T) {
+	tests := []struct {
+		header, post string
+		method       string
+		target       string
+	}{
+		{"<mailto:u@list.example.com>, <https://list.example.com/u/1>", "List-Unsubscribe=One-Click", "one-click", "https://list.example.com/u/1"},
+		{"<https://list.example.com/u/1>, <mailto:u@list.example.com?subject=stop>", "", "mailto", "mailto:u@list.example.com?subject=stop"},
+		{"<http://list.example.com/u/1>", "List-Unsubscribe=One-Click", "none", ""},
+		{"<https://list.example.com/u/1>", "", "link", "https://list.example.com/u/1"},
+		{"", "", "none", ""},
+	}
+	for _, tt := range tests {
+		method, target := unsubscribeMethod(tt.header, tt.post)
+		if method != tt.method || target != tt.target {
+			t.Errorf("unsubscribeMethod(%q, %q) = %q, %q, want %q, %q", tt.header, tt.post, method, target, tt.method, tt.target)
+		}
+	}
+}
+
+func TestRunMessagesUnsubscribe(t *testing.T) {
+	var posted []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		posted = append(posted, r.Method+" "+r.URL.Path+" "+string(b))
+	}))
+	defer srv.Close()
+
+	headers := map[string][]*gmail.MessagePartHeader{
+		"m1": {
+			{Name: "From", Value: "News <news@shop.example.com>"},
+			{Name: "List-Unsubscribe", Value: "<" + srv.URL + "/unsub?u=1>"},
+			{Name: "List-Unsubscribe-Post", Value: "List-Unsubscribe=One-Click"},
+		},
+		"m2": {{Name: "From", Value: "news@shop.example.com"}},
+		"m3": {
+			{Name: "From", Value: "Digest <digest@list.example.org>"},
+			{Name: "List-Unsubscribe", Value: "<mailto:leave@list.example.org?subject=unsubscribe%20me>"},
+		},
+	}
+	var sent []string
+	var filters []*gmail.Filter
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "messages" && req.URL.Query().Get("q") == "label:newsletters":
+			return gmail.ListMessagesResponse{Messages: []*gmail.Message{{Id: "m1"}, {Id: "m2"}, {Id: "m3"}}}
+		case path == "messages/send":
+			var m gmail.Message
+			decodeRequest(t, req, &m)
+			raw, _ := gwcli.MIMEDecode(m.Raw)
+			sent = append(sent, raw)
+			return &gmail.Message{Id: "S1"}
+		case path == "settings/filters":
+			var f gmail.Filter
+			decodeRequest(t, req, &f)
+			f.Id = "F" + f.Criteria.From
+			filters = append(filters, &f)
+			return &f
+		case strings.HasPrefix(path, "messages/"):
+			id := strings.TrimPrefix(path, "messages/")
+			return &gmail.Message{Id: id, Payload: &gmail.MessagePart{Headers: headers[id]}}
+		}
+		return nil
+	})
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	c := messagesUnsubscribeCmd{Filter: "archive", Bulk: bulkFlags{Query: "label:newsletters", Max: 1000}}
+	if err := runMessagesUnsubscribe(context.Background(), conn, srv.Client(), c, out); err != nil {
+		t.Fatalf("runMessagesUnsubscribe() error = %v", err)
+	}
+
+	if len(posted) != 1 || posted[0] != "POST /unsub List-Unsubscribe=One-Click" {
+		t.Errorf("posted = %q", posted)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "To: leave@list.example.org") || !strings.Contains(sent[0], "Subject: unsubscribe me") {
+		t.Errorf("sent = %q", sent)
+	}
+	if len(filters) != 2 || filters[0].Action.RemoveLabelIds[0] != "INBOX" {
+		t.Errorf("filters = %+v", filters)
+	}
+
+	var got []unsubscribeResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v (raw: %s)", err, buf.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d senders, want 2: %+v", len(got), got)
+	}
+	if got[0].Sender != "news@shop.example.com" || got[0].Method != "one-click" || got[0].Status != "unsubscribed" || got[0].FilterID == "" {
+		t.Errorf("first sender = %+v", got[0])
+	}
+	if got[1].Method != "mailto" || got[1].Status != "unsubscribed" {
+		t.Errorf("second sender = %+v", got[1])
+	}
+}
+
+func TestRunMessagesUnsubscribe_DryRun(t *testing.T) {
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "messages/m1" {
+			return nil
+		}
+		return &gmail.Message{Id: "m1", Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "From", Value: "news@shop.example.com"},
+			{Name: "List-Unsubscribe", Value: "<https://shop.example.com/u>"},
+			{Name: "List-Unsubscribe-Post", Value: "List-Unsubscribe=One-Click"},
+		}}}
+	})
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+
+	// A nil client would panic if the dry run sent anything.
+	c := messagesUnsubscribeCmd{MessageID: "m1", Filter: "trash", Bulk: bulkFlags{DryRun: true}}
+	if err := runMessagesUnsubscribe(context.Background(), conn, nil, c, out); err != nil {
+		t.Fatalf("runMessagesUnsubscribe() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `"status": "planned"`) {
+		t.Errorf("output = %s", buf.String())
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// watchStateFile is the default checkpoint file name inside the config dir.
+const watchStateFile = "watch-state.json"
+
+// watchAllKey is the checkpoint key used when no --label is given.
+const watchAllKey = "*"
+
+// watchEvent is one NDJSON line emitted by messages watch.
+type watchEvent struct {
+	Type          string   `json:"type"` // added, deleted, labeled, unlabeled, reset
+	HistoryID     string   `json:"historyId"`
+	MessageID     string   `json:"messageId,omitempty"`
+	ThreadID      string   `json:"threadId,omitempty"`
+	LabelIDs      []string `json:"labelIds,omitempty"`
+	ChangedLabels []string `json:"changedLabelIds,omitempty"`
+	From          string   `json:"from,omitempty"`
+	Subject       string   `json:"subject,omitempty"`
+	Time          string   `json:"time"`
+}
+
+// defaultWatchStatePath returns the checkpoint file in the config dir.
+func defaultWatchStatePath(configDir string) (string, error) {
+	paths, err := gwcli.GetConfigPaths(configDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(paths.Dir, watchStateFile), nil
+}
+
+// loadWatchState reads the label -> history ID checkpoint map. A missing
+// file is an empty state.
+func loadWatchState(path string) (map[string]string, error) {
+	state := map[string]string{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse watch state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveWatchState writes the checkpoint map atomically.
+func saveWatchState(path string, state map[string]string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write watch state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write watch state: %w", err)
+	}
+	return nil
+}
+
+// historyEvents flattens history records into watch events.
+func historyEvents(records []*gmail.History) []watchEvent {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var events []watchEvent
+	add := func(typ string, id uint64, m *gmail.Message, changed []string) {
+		if m == nil {
+			return
+		}
+		events = append(events, watchEvent{
+			Type:          typ,
+			HistoryID:     strconv.FormatUint(id, 10),
+			MessageID:     m.Id,
+			ThreadID:      m.ThreadId,
+			LabelIDs:      m.LabelIds,
+			ChangedLabels: changed,
+			Time:          now,
+		})
+	}
+	for _, h := range records {
+		for _, r := range h.MessagesAdded {
+			add("added", h.Id, r.Message, nil)
+		}
+		for _, r := range h.MessagesDeleted {
+			add("deleted", h.Id, r.Message, nil)
+		}
+		for _, r := range h.LabelsAdded {
+			add("labeled", h.Id, r.Message, r.LabelIds)
+		}
+		for _, r := range h.LabelsRemoved {
+			add("unlabeled", h.Id, r.Message, r.LabelIds)
+		}
+	}
+	return events
+}
+
+// runWatchHooks runs each hook through sh -c with the event JSON on stdin
+// and the main fields in GWCLI_* environment variables. Failures are
+// reported but do not stop the watch.
+func runWatchHooks(ctx context.Context, hooks []string, ev watchEvent, line []byte) {
+	for _, hook := range hooks {
+		cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+		cmd.Stdin = bytes.NewReader(line)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"GWCLI_EVENT_TYPE="+ev.Type,
+			"GWCLI_MESSAGE_ID="+ev.MessageID,
+			"GWCLI_THREAD_ID="+ev.ThreadID,
+			"GWCLI_HISTORY_ID="+ev.HistoryID,
+		)
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: hook %q failed for %s %s: %v\n", hook, ev.Type, ev.MessageID, err)
+		}
+	}
+}
+
+// isHistoryExpired reports whether err means the start history ID is too
+// old for the history API.
+func isHistoryExpired(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
+
+// runMessagesWatch polls the Gmail history API and writes one NDJSON event
+// per added, deleted or relabeled message. The last processed history ID is
+// checkpointed in statePath after every poll, so a restart resumes where the
+// previous run stopped. Rate limits and server errors are retried with
+// backoff and then at the next poll; other errors end the watch. With once
+// set it polls a single time and returns.
+func runMessagesWatch(ctx context.Context, conn *gwcli.CmdG, label, statePath string, hooks []string, interval time.Duration, once bool, out *outputWriter) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	labelID := ""
+	key := watchAllKey
+	if label != "" {
+		if err := conn.LoadLabels(ctx, out.verbose); err != nil {
+			return fmt.Errorf("failed to load labels: %w", err)
+		}
+		id, err := resolveLabelID(conn, label)
+		if err != nil {
+			return err
+		}
+		labelID, key = id, id
+	}
+
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		return err
+	}
+
+	var start gwcli.HistoryID
+	if saved, ok := state[key]; ok {
+		n, err := strconv.ParseUint(saved, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid history ID %q in %s", saved, statePath)
+		}
+		start = gwcli.HistoryID(n)
+		out.writeVerbose("Resuming watch of %s from history ID %d", key, start)
+	} else {
+		if start, err = conn.HistoryID(ctx); err != nil {
+			return fmt.Errorf("failed to get current history ID: %w", err)
+		}
+		out.writeVerbose("Starting watch of %s at current history ID %d", key, start)
+		state[key] = strconv.FormatUint(uint64(start), 10)
+		if err := saveWatchState(statePath, state); err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(out.writer)
+	emit := func(ev watchEvent) error {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+		runWatchHooks(ctx, hooks, ev, line)
+		return nil
+	}
+
+	for {
+		var records []*gmail.History
+		var next gwcli.HistoryID
+		err := retryAPI(ctx, func() error {
+			var err error
+			records, next, err = conn.History(ctx, start, labelID)
+			return err
+		}, func(err error, delay time.Duration) {
+			out.writeVerbose("Retrying history in %v: %v", delay, err)
+		})
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case isHistoryExpired(err):
+			// The checkpoint is older than Gmail keeps history for; start
+			// over from now and tell consumers they may have missed mail.
+			if start, err = conn.HistoryID(ctx); err != nil {
+				return fmt.Errorf("failed to get current history ID: %w", err)
+			}
+			if err := emit(watchEvent{
+				Type:      "reset",
+				HistoryID: strconv.FormatUint(uint64(start), 10),
+				Time:      time.Now().UTC().Format(time.RFC3339),
+			}); err != nil {
+				return err
+			}
+		case isRetryable(err) && !once:
+			// Gmail is still failing after the retries; keep the
+			// checkpoint and try again at the next poll.
+			fmt.Fprintf(os.Stderr, "Warning: failed to get history, retrying at the next poll: %v\n", err)
+		case err != nil:
+			return fmt.Errorf("failed to get history: %w", err)
+		default:
+			for _, ev := range historyEvents(records) {
+				if ev.Type == "added" {
+					msg := gwcli.NewMessage(conn, ev.MessageID)
+					if err := msg.Preload(ctx, gwcli.LevelMetadata); err == nil {
+						ev.From, _ = msg.GetHeader(ctx, "From")
+						ev.Subject, _ = msg.GetHeader(ctx, "Subject")
+					}
+				}
+				if err := emit(ev); err != nil {
+					return err
+				}
+			}
+			if next > start {
+				start = next
+			}
+		}
+
+		state[key] = strconv.FormatUint(uint64(start), 10)
+		if err := saveWatchState(statePath, state); err != nil {
+			return err
+		}
+
+		if once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
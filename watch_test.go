@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wesnick/gwcli/pkg/gwcli"
+)
+
+// newFakeWatchConn serves the profile (current history ID 500) and history
+// list. history is the JSON body for history.list; an empty string makes
+// it return 404 like an expired start ID.
+func newFakeWatchConn(t *testing.T, history string, starts *[]string) *gwcli.CmdG {
+	return newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		switch {
+		case path == "profile":
+			return `{"emailAddress":"me@example.com","historyId":"500"}`
+		case path == "history":
+			*starts = append(*starts, req.URL.Query().Get("startHistoryId"))
+			if history == "" {
+				return fakeError{code: http.StatusNotFound, message: "Requested entity was not found."}
+			}
+			return history
+		case strings.HasPrefix(path, "messages/"):
+			id := strings.TrimPrefix(path, "messages/")
+			return fmt.Sprintf(`{"id":%q,"payload":{"headers":[{"name":"From","value":"bob@example.com"},{"name":"Subject","value":"New %s"}]}}`, id, id)
+		}
+		return nil
+	})
+}
+
+func readEvents(t *testing.T, buf *bytes.Buffer) []watchEvent {
+	t.Helper()
+	var events []watchEvent
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var ev watchEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q is not JSON: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestRunMessagesWatch_CheckpointsAndEmits(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	hookOut := filepath.Join(dir, "hook.out")
+
+	// First run: no checkpoint, so it starts at the current history ID.
+	var starts []string
+	conn := newFakeWatchConn(t, `{"historyId":"500"}`, &starts)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesWatch(context.Background(), conn, "", statePath, nil, time.Second, true, out); err != nil {
+		t.Fatalf("first run error = %v", err)
+	}
+	if len(starts) != 1 || starts[0] != "500" {
+		t.Errorf("history start IDs = %v, want [500]", starts)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no events on first run, got %s", buf.String())
+	}
+
+	// Second run resumes from the checkpoint and emits one event per change.
+	starts = nil
+	conn = newFakeWatchConn(t, `{"historyId":"620","history":[
+		{"id":"610","messagesAdded":[{"message":{"id":"M1","threadId":"T1","labelIds":["INBOX","UNREAD"]}}]},
+		{"id":"615","labelsRemoved":[{"message":{"id":"M1","threadId":"T1","labelIds":["INBOX"]},"labelIds":["UNREAD"]}]},
+		{"id":"618","messagesDeleted":[{"message":{"id":"M0","threadId":"T0"}}]}]}`, &starts)
+	buf.Reset()
+	hook := fmt.Sprintf(`echo "$GWCLI_EVENT_TYPE $GWCLI_MESSAGE_ID" >> %q`, hookOut)
+	if err := runMessagesWatch(context.Background(), conn, "", statePath, []string{hook}, time.Second, true, out); err != nil {
+		t.Fatalf("second run error = %v", err)
+	}
+	if len(starts) != 1 || starts[0] != "500" {
+		t.Errorf("history start IDs = %v, want [500]", starts)
+	}
+
+	events := readEvents(t, &buf)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %s", len(events), buf.String())
+	}
+	if events[0].Type != "added" || events[0].MessageID != "M1" || events[0].Subject != "New M1" {
+		t.Errorf("unexpected added event: %+v", events[0])
+	}
+	if events[1].Type != "unlabeled" || strings.Join(events[1].ChangedLabels, ",") != "UNREAD" {
+		t.Errorf("unexpected unlabeled event: %+v", events[1])
+	}
+	if events[2].Type != "deleted" || events[2].MessageID != "M0" {
+		t.Errorf("unexpected deleted event: %+v", events[2])
+	}
+
+	hookData, err := os.ReadFile(hookOut)
+	if err != nil {
+		t.Fatalf("hook did not run: %v", err)
+	}
+	if got := string(hookData); got != "added M1\nunlabeled M1\ndeleted M0\n" {
+		t.Errorf("hook output = %q", got)
+	}
+
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		t.Fatalf("loadWatchState() error = %v", err)
+	}
+	if state[watchAllKey] != "620" {
+		t.Errorf("checkpoint = %q, want 620", state[watchAllKey])
+	}
+}
+
+func TestRunMessagesWatch_ExpiredCheckpoint(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := saveWatchState(statePath, map[string]string{watchAllKey: "7"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var starts []string
+	conn := newFakeWatchConn(t, "", &starts)
+	var buf bytes.Buffer
+	out := &outputWriter{json: true, writer: &buf}
+	if err := runMessagesWatch(context.Background(), conn, "", statePath, nil, time.Second, true, out); err != nil {
+		t.Fatalf("runMessagesWatch() error = %v", err)
+	}
+
+	events := readEvents(t, &buf)
+	if len(events) != 1 || events[0].Type != "reset" || events[0].HistoryID != "500" {
+		t.Fatalf("expected a single reset event, got %s", buf.String())
+	}
+	state, _ := loadWatchState(statePath)
+	if state[watchAllKey] != "500" {
+		t.Errorf("checkpoint = %q, want 500", state[watchAllKey])
+	}
+}
+
+func TestRunMessagesWatch_RetriesServerErrors(t *testing.T) {
+	apiRetryDelay = time.Millisecond
+	defer func() { apiRetryDelay = time.Second }()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := saveWatchState(statePath, map[string]string{watchAllKey: "500"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first poll fails through all of its retries; the watch keeps
+	// going and the next poll succeeds.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	conn := newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "history" {
+			return nil
+		}
+		calls++
+		if calls <= apiRetries+1 {
+			return fakeError{code: http.StatusServiceUnavailable, message: "Backend Error"}
+		}
+		cancel()
+		return `{"historyId":"500"}`
+	})
+	out := &outputWriter{json: true, writer: &bytes.Buffer{}}
+	if err := runMessagesWatch(ctx, conn, "", statePath, nil, time.Millisecond, false, out); err != nil {
+		t.Fatalf("runMessagesWatch() error = %v", err)
+	}
+	if calls != apiRetries+2 {
+		t.Errorf("history calls = %d, want %d", calls, apiRetries+2)
+	}
+
+	// Permanent errors end the watch at once.
+	calls = 0
+	conn = newFakeGmail(t, func(req *http.Request, path string) interface{} {
+		if path != "history" {
+			return nil
+		}
+		calls++
+		return fakeError{code: http.StatusForbidden, message: "Insufficient Permission"}
+	})
+	if err := runMessagesWatch(context.Background(), conn, "", statePath, nil, time.Millisecond, false, out); err == nil {
+		t.Fatal("runMessagesWatch() error = nil, want the permission error")
+	}
+	if calls != 1 {
+		t.Errorf("history calls = %d, want 1", calls)
+	}
+}